@@ -0,0 +1,393 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	cmdBackup = &command{
+		run:       runBackup,
+		UsageLine: "backup [-c config] -pass-file file [-o file]",
+		Short:     "write an encrypted archive of the config dir",
+		Long: `
+Backup writes an archive of the whole config dir, including accounts,
+their keys, certificates, keys of certificates, settings and audit logs,
+encrypted with a password read from the first line of the file specified
+with -pass-file. It can be restored on another host with acme restore.
+
+The archive is written to the file specified with -o, created with 0600
+permissions, or to the standard output if -o is not specified.
+
+The archive is encrypted with AES-256-GCM, with a key derived from
+the password with PBKDF2-HMAC-SHA256, which also protects its integrity.
+Account keys kept in the OS keyring, and files outside of the config dir,
+such as those written with -k or -out arguments of cert command,
+are not included.
+
+With -tenant, only the config dir of the tenant is archived.
+
+Default location of the config dir is {{.ConfigDir}}.
+		`,
+	}
+
+	cmdRestore = &command{
+		run:       runRestore,
+		UsageLine: "restore [-c config] -pass-file file [-force] file",
+		Short:     "restore the config dir from a backup",
+		Long: `
+Restore extracts an archive written with acme backup into the config dir.
+The password is read from the first line of the file specified with
+-pass-file. A file argument of "-" reads the archive from the standard input.
+
+The archive is decrypted and verified not to be modified, and all of its
+entries are checked, before anything is written. Restore refuses
+to write into an existing config dir which is not empty, unless -force
+is specified, in which case files of the archive replace existing ones
+and other files are kept.
+
+Default location of the config dir is {{.ConfigDir}}.
+		`,
+	}
+
+	backupPass   string
+	backupOut    string
+	restoreForce bool
+)
+
+func init() {
+	cmdBackup.flag.StringVar(&backupPass, "pass-file", "", "")
+	cmdBackup.flag.StringVar(&backupOut, "o", "", "")
+	cmdRestore.flag.StringVar(&backupPass, "pass-file", "", "")
+	cmdRestore.flag.BoolVar(&restoreForce, "force", restoreForce, "")
+}
+
+const (
+	// backupMagic starts every backup archive.
+	backupMagic = "acme-backup-v1\n"
+	// backupIter is the PBKDF2 iteration count of new archives.
+	backupIter = 600000
+	// maxBackupIter limits the iteration count of archives to restore.
+	maxBackupIter = 100 * backupIter
+	// maxBackupSize limits the size of archives to restore.
+	maxBackupSize = 1 << 30
+)
+
+func runBackup(args []string) {
+	if len(args) != 0 {
+		fatalf("backup: unexpected arguments %q", args)
+	}
+	if backupPass == "" {
+		fatalf("backup: -pass-file is required")
+	}
+	pass, err := readPassword(backupPass)
+	if err != nil {
+		fatalf("-pass-file: %v", err)
+	}
+	if pass == "" {
+		fatalf("-pass-file: empty password")
+	}
+	warnKeyringAccounts(configDir)
+	plain, err := archiveDir(configDir)
+	if err != nil {
+		fatalf("backup: %v", err)
+	}
+	b, err := sealBackup(plain, pass, backupIter)
+	if err != nil {
+		fatalf("backup: %v", err)
+	}
+	if backupOut == "" {
+		_, err = os.Stdout.Write(b)
+	} else {
+		err = ioutil.WriteFile(backupOut, b, 0600)
+	}
+	if err != nil {
+		fatalf("backup: %v", err)
+	}
+}
+
+func runRestore(args []string) {
+	if len(args) != 1 {
+		fatalf("usage: acme restore -pass-file file archive")
+	}
+	if backupPass == "" {
+		fatalf("restore: -pass-file is required")
+	}
+	pass, err := readPassword(backupPass)
+	if err != nil {
+		fatalf("-pass-file: %v", err)
+	}
+	r := io.Reader(os.Stdin)
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fatalf("restore: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxBackupSize+1))
+	if err != nil {
+		fatalf("restore: %v", err)
+	}
+	if len(b) > maxBackupSize {
+		fatalf("restore: archive is larger than %d bytes", maxBackupSize)
+	}
+	plain, err := openBackup(b, pass)
+	if err != nil {
+		fatalf("restore: %v", err)
+	}
+	entries, err := readArchive(plain)
+	if err != nil {
+		fatalf("restore: %v", err)
+	}
+	if err := restoreEntries(configDir, entries, restoreForce); err != nil {
+		fatalf("restore: %v", err)
+	}
+	logf("restored %d files into %s", len(entries), configDir)
+}
+
+// warnKeyringAccounts logs a warning for every account in dir
+// whose key is kept in the OS keyring, and thus not backed up.
+func warnKeyringAccounts(dir string) {
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || fi.Name() != accountFile {
+			return nil
+		}
+		var uc userConfig
+		if b, err := ioutil.ReadFile(p); err == nil && json.Unmarshal(b, &uc) == nil && uc.Keyring {
+			logf("warning: %s: account key is in the OS keyring and is not included", p)
+		}
+		return nil
+	})
+}
+
+// archiveDir returns a gzip compressed tar archive of the files,
+// directories and symbolic links in dir, with names relative to it.
+func archiveDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil // sockets, pipes and such
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// backupKey derives an AES-256 key from pass.
+func backupKey(pass string, salt []byte, iter int) []byte {
+	return pbkdf2.Key([]byte(pass), salt, iter, 32, sha256.New)
+}
+
+// sealBackup encrypts plain with a key derived from pass with iter
+// PBKDF2 iterations. The result is backupMagic, followed by
+// a 16 byte salt, the 4 byte big-endian iteration count, a 12 byte nonce
+// and the AES-GCM sealed plain, authenticated together with the former.
+func sealBackup(plain []byte, pass string, iter int) ([]byte, error) {
+	hdr := make([]byte, len(backupMagic)+16+4)
+	copy(hdr, backupMagic)
+	salt := hdr[len(backupMagic) : len(backupMagic)+16]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(hdr[len(backupMagic)+16:], uint32(iter))
+	aead, err := backupAEAD(pass, salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b := append(hdr, nonce...)
+	return aead.Seal(b, nonce, plain, hdr), nil
+}
+
+// openBackup decrypts and verifies an archive written by sealBackup.
+func openBackup(b []byte, pass string) ([]byte, error) {
+	n := len(backupMagic) + 16 + 4
+	if !bytes.HasPrefix(b, []byte(backupMagic)) || len(b) < n+12 {
+		return nil, errors.New("not an acme backup archive")
+	}
+	hdr := b[:n]
+	salt := hdr[len(backupMagic) : len(backupMagic)+16]
+	iter := int(binary.BigEndian.Uint32(hdr[len(backupMagic)+16:]))
+	if iter < 1 || iter > maxBackupIter {
+		return nil, fmt.Errorf("invalid iteration count %d", iter)
+	}
+	aead, err := backupAEAD(pass, salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	nonce := b[n : n+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, b[n+aead.NonceSize():], hdr)
+	if err != nil {
+		return nil, errors.New("wrong password or modified archive")
+	}
+	return plain, nil
+}
+
+func backupAEAD(pass string, salt []byte, iter int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(backupKey(pass, salt, iter))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupEntry is a file, directory or symbolic link of an archive.
+type backupEntry struct {
+	hdr  *tar.Header
+	data []byte
+}
+
+// readArchive reads all entries of a tar.gz archive written by archiveDir,
+// verifying their names stay within the archived dir.
+func readArchive(b []byte) ([]backupEntry, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	var entries []backupEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%q: invalid name", hdr.Name)
+		}
+		hdr.Name = name
+		e := backupEntry{hdr: hdr}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeSymlink:
+		case tar.TypeReg:
+			if e.data, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s: unsupported entry type %q", name, hdr.Typeflag)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// restoreEntries writes entries into dir. It refuses to write into
+// a non-empty dir, unless force is true. Symbolic links are created last,
+// so that no file is written through one.
+func restoreEntries(dir string, entries []backupEntry, force bool) error {
+	if ff, err := ioutil.ReadDir(dir); err == nil && len(ff) > 0 && !force {
+		return fmt.Errorf("%s is not empty; use -force to restore into it", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	var links []backupEntry
+	for _, e := range entries {
+		p := filepath.Join(dir, filepath.FromSlash(e.hdr.Name))
+		mode := os.FileMode(e.hdr.Mode).Perm()
+		switch e.hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			links = append(links, e)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+				return err
+			}
+			os.Remove(p) // do not write through an existing link
+			if err := ioutil.WriteFile(p, e.data, mode); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range links {
+		p := filepath.Join(dir, filepath.FromSlash(e.hdr.Name))
+		os.Remove(p)
+		if err := os.Symlink(e.hdr.Linkname, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	files := map[string]string{
+		accountFile:                           "{}",
+		accountKey:                            "key",
+		"example.com.crt":                     "cert",
+		filepath.Join("ca", "x", "audit.log"): "log",
+	}
+	for name, data := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("example.com.crt", filepath.Join(src, "current.crt")); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := archiveDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sealBackup(plain, "secret", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openBackup(b, "wrong"); err == nil {
+		t.Error("wrong password: no error")
+	}
+	tampered := append([]byte{}, b...)
+	tampered[len(tampered)-20] ^= 1
+	if _, err := openBackup(tampered, "secret"); err == nil {
+		t.Error("modified archive: no error")
+	}
+	if _, err := openBackup(plain, "secret"); err == nil {
+		t.Error("unencrypted archive: no error")
+	}
+	if plain, err = openBackup(b, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readArchive(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := restoreEntries(dst, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		p := filepath.Join(dst, name)
+		b, err := ioutil.ReadFile(p)
+		if err != nil || string(b) != data {
+			t.Errorf("%s: %q, %v; want %q", name, b, err, data)
+		}
+		if fi, err := os.Stat(p); err == nil && fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode %v", name, fi.Mode())
+		}
+	}
+	if l, err := os.Readlink(filepath.Join(dst, "current.crt")); err != nil || l != "example.com.crt" {
+		t.Errorf("current.crt links to %q, %v", l, err)
+	}
+	if err := restoreEntries(dst, entries, false); err == nil {
+		t.Error("restore into non-empty dir: no error")
+	}
+	if err := restoreEntries(dst, entries, true); err != nil {
+		t.Errorf("restore -force: %v", err)
+	}
+}
+
+func TestReadArchiveInvalid(t *testing.T) {
+	for _, name := range []string{"../escape", "/etc/passwd", "a/../../escape"} {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: 1})
+		tw.Write([]byte("x"))
+		tw.Close()
+		zw.Close()
+		if _, err := readArchive(buf.Bytes()); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+	}
+}
@@ -12,18 +12,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"golang.org/x/crypto/acme"
@@ -32,63 +46,784 @@ import (
 var (
 	cmdCert = &command{
 		run:       runCert,
-		UsageLine: "cert [-c config] [-d url] [-s host:port] [-k key] [-expiry dur] [-bundle=true] [-manual=false] [-dns=false] domain [domain ...]",
+		UsageLine: "cert [-c config] [-ca name] [-d url] [-challenge-listen host:port] [-challenge-public host:port] [-check-from url] [-k key | -signer url] [-key-type type] [-cn name] [-eku usage,...] [-ext oid=hex] [-expiry dur] [-timeout dur] [-propagation-timeout dur] [-validation-timeout dur] [-retry n] [-poll dur] [-poll-max n] [-renew-before dur] [-issuer sha256,...] [-force] [-out path] [-link path] [-leaf path] [-chain path] [-fullchain path] [-root file|url] [-cert-mode 0644] [-key-mode 0600] [-owner user] [-group group] [-selinux context] [-format pem|der|jks] [-store-pass-file file] [-cert-store name] [-bundle=true] [-pins=false] [-csr=false] [-manual=false] [-dns=false] [-manual-output text|json] [-success-hook cmd] [-failure-hook cmd] [-hook-timeout dur] [-hook-user user] [-csr-dir dir -cert-dir dir] [-pack file [-pack-size n]] domain [domain ...]",
 		Short:     "request a new certificate",
 		Long: `
 Cert creates a new certificate for the given domain.
 It uses the http-01 challenge type by default and dns-01 if -dns is specified.
 
 The certificate will be placed alongside key file, specified with -k argument.
-If the key file does not exist, a new one will be created.
+If the key file does not exist, a new one will be created,
+of the type specified with -key-type, {{.DefaultKeyType}} by default.
+See acme help reg for the types. Certificate keys can also be
+-key-type=ec521 for ECDSA P-521, or -key-type=ed25519, for CAs which
+issue certificates for Ed25519 keys; many, including public ones,
+do not and reject the request. A key below
+RSA 2048 or ECDSA P-256 is rejected, and one weaker than -key-type
+is reported.
 Default location for the key file is {{.ConfigDir}}/domain.key,
 where domain is the actually domain name provided as the command argument.
 
+Domain names are validated before contacting the CA. They are converted
+to lower case and a trailing dot, if any, is removed. All domains are
+requested as Subject Alternative Names, sorted and without duplicates,
+and the issued certificate is verified to contain exactly that set.
+
+The -signer argument makes the command use a certificate key kept
+by a remote signer service, instead of a key file, so that the host
+never has the private key. Its value is the URL of the key at the service,
+which must implement this HTTP interface:
+
+	GET url returns the public key, PEM encoded "PUBLIC KEY"
+	POST url with a JSON object of "digest", the base64 encoded digest
+	  to sign, "hash", the name of the hash function, such as SHA-256,
+	  and "pss": true for RSA-PSS with salt length equal to the hash size,
+	  returns a JSON object with the base64 encoded "signature";
+	  for Ed25519 keys, "hash" is empty and "digest" is the message
+
+Requests carry an Authorization: Bearer header with the value
+of ACME_SIGNER_TOKEN environment variable, if set. With a token,
+the signer URL must be https, or http to a loopback address.
+The certificate is written to the config dir, or -out, as it is with
+a default key file. -signer cannot be combined with -k, -pins, -format=jks
+or -cert-store, which need the private key, and -success-hook gets
+an empty ACME_KEY_FILE.
+
+The -cn argument specifies which of the domains is used as the certificate
+subject Common Name. It defaults to the first domain. Use -cn={{.NoCN}}
+to omit the Common Name, for CAs which ignore or forbid it.
+
+The -eku argument is a comma separated list of extended key usages
+to request, e.g. serverAuth,clientAuth. Known values are:
+{{range $name, $oid := .ExtKeyUsages}}
+	{{$name}}{{end}}
+
+The -ext argument adds a custom extension to the certificate request.
+Its value is an object identifier and a hex encoded DER value,
+e.g. 1.3.6.1.4.1.11129.2.4.3=0500. The flag can be repeated.
+Whether these are honored is up to the CA; public CAs typically ignore them.
+
+The -out argument overrides the certificate file location. Its value is
+a template which can refer to {{"{{.Domain}}"}}, {{"{{.Serial}}"}} and {{"{{.Date}}"}}
+of the certificate, for example /etc/ssl/{{"{{.Domain}}/{{.Serial}}"}}.crt,
+to keep every issued certificate. The -link argument, a template
+which can refer to {{"{{.Domain}}"}}, specifies a symbolic link which is atomically
+updated to point to the new certificate, e.g. /etc/ssl/{{"{{.Domain}}"}}/current.crt.
+It requires -out, so that the certificate is never written through the link.
+
+The -cert-mode and -key-mode arguments specify permissions of the written
+certificate and newly generated key files, 0644 and 0600 by default.
+The -owner and -group arguments, user and group names or IDs, change
+the ownership of the written files and created directories,
+where the platform allows it. The command warns if an existing key file
+is accessible by group or others. On Linux, the -selinux argument sets
+the SELinux security context of the written files, e.g.
+system_u:object_r:cert_t:s0. After writing, every file is read back to verify
+its content and permissions.
+
+The -format argument selects the certificate file encoding:
+
+	pem: PEM encoded certificate followed by the CA chain, if any;
+	     default file extension is .crt
+	der: DER encoded certificate alone, without the CA chain; .der
+	jks: Java KeyStore holding the key and the certificate chain
+	     under the -cn domain alias, or the first domain with -cn={{.NoCN}};
+	     .jks
+
+A keystore is protected with a password read from the first line
+of the file specified with -store-pass-file. Since it contains the key,
+the keystore file is written with -key-mode permissions.
+
+On Windows, the -cert-store argument imports the certificate, its key
+and the CA chain into the named local machine certificate store, such
+as My, with certutil -importPFX. This requires administrator rights,
+and Windows 10 1709, Windows Server 2019 or newer. The certificate
+thumbprint is logged after the import, for binding the certificate to
+an IIS site or with netsh http add sslcert certhash=<thumbprint>.
+
+If a certificate for the same set of domains and key already exists and
+does not expire within the -renew-before duration, {{.CertRenewBefore}} by default,
+no new certificate is requested. Use -force to request one anyway.
+The same applies to the CA certificates bundled with it: a new certificate
+is requested when one of them expires within -renew-before.
+The -issuer argument lists hex SHA-256 fingerprints of the CA certificates
+acceptable as the issuer of an existing certificate, separated by comma,
+for example when the CA announces a new intermediate. A certificate bundled
+with a different issuer is then renewed early. With -bundle=false
+the CA chain is read from the -chain or -fullchain file instead, and one of
+them is required; their paths must not depend on .Serial or .Date.
+The fingerprints of the CA chain are recorded in the issuance manifest.
+
 By default the obtained certificate will also contain the CA chain.
 If this is undesired, specify -bundle=false argument.
 
-The -s argument specifies the address where to run local server
-for the http-01 challenge. If not specified, 127.0.0.1:8080 will be used.
+The -leaf, -chain and -fullchain arguments specify additional files
+to write in PEM format: the certificate alone, the CA chain alone,
+and the certificate followed by the CA chain. Like -out, they are templates.
+The CA chain is always requested if -chain, -fullchain or -root is specified,
+in which case -bundle affects only the main certificate file.
+
+The -root argument specifies a root certificate, a PEM or DER encoded file
+or an http(s) URL, to append to the CA chain, for clients of private CAs
+which need the full path including the root. The root must be self-signed
+and must have issued the last certificate of the chain. If the directory
+was specified with a root fingerprint, the root must match it.
+
+The -pins argument makes the command also write SPKI pins in pin-sha256="..."
+form to domain.pins file next to the certificate. The file contains the pin
+of the certificate key followed by the pin of a backup key, domain.backup.key,
+which is generated once and kept for when the certificate key is replaced.
+If the certificate key file is missing, for example after it was removed
+or moved aside by revoke -reason keyCompromise, the backup key becomes
+the certificate key and a new backup key is generated, so that
+the previously published pins keep matching the key in use.
+
+The -csr argument makes the command write the certificate request
+in PEM format to domain.csr file next to the key and log its SHA-256 digest,
+also recorded in {{.AuditFile}} in the account dir for audit purposes.
+If the file already exists and was made for the same key,
+domains and extensions, it is reused as is, so that the CA receives
+a byte-identical request.
+
+The -pack argument makes the command group the domains into multiple
+certificates of at most -pack-size names each, 100 by default.
+The grouping is stored in the specified file and preserved by subsequent runs:
+removed domains leave their groups and new ones fill free space first,
+so that renewals do not move domains between certificates. Each group
+is then requested as if its domains were given to a separate cert command,
+with the first domain of the group naming the key and certificate files.
+A failed group does not stop the others, but an interrupt stops the run
+and the remaining groups are not attempted. The status of each group is
+printed at the end, and the command exits with status 1 if any failed
+or was not attempted.
+
+The -success-hook argument specifies a shell command to run after
+a new certificate is written, and -failure-hook one to run when it could not
+be obtained, including after all fallback CAs failed. Hooks are run for domain
+arguments, each -pack group and each -csr-dir request, with these environment variables, compatible
+with certbot deploy hooks:
+
+	RENEWED_DOMAINS   the certificate domains, separated by space
+	RENEWED_LINEAGE   the directory of the certificate file
+	ACME_CERT_FILE    the certificate file
+	ACME_KEY_FILE     the certificate key file; empty with -signer or -csr-dir
+	ACME_ATTEMPTS     the number of CAs the certificate was requested from
+
+and for -failure-hook:
+
+	FAILED_DOMAINS    the certificate domains, separated by space
+	ACME_ATTEMPTS     the number of CAs the certificate was requested from
+	ACME_ERROR        the error message of the last attempt
+
+Hooks are not run if the certificate is up to date, nor if the CA returned
+the same certificate as the one already in the file.
+A failed hook is reported and makes the command exit with status 1,
+but does not affect the written certificate.
+A hook is killed if it runs longer than -hook-timeout, {{.HookTimeout}} by default,
+along with the processes it started, except on Windows;
+zero means no limit. The -hook-user argument, a user name or ID, makes hooks
+run as that user and its primary group, which requires the privileges
+to switch to it. The hook output is copied to the standard error and
+recorded in {{.AuditFile}} in the account dir, along with the outcome.
+
+On success, the command records its arguments, the CA and the certificate
+names in domain.manifest.json file next to the key, along with the certificate
+URL at the CA, its ACME Renewal Information identifier and the written files.
+See acme help promote.
+
+The -challenge-listen argument, or its older name -s, specifies the address
+where to run local server for the http-01 challenge. If not specified,
+127.0.0.1:8080 will be used. The CA connects to port 80 of the domain,
+which must be forwarded to this address.
+
+The -challenge-public argument specifies the address, host[:port], at which
+the http-01 challenge response is reachable from the internet, such as
+the external address of a NAT router forwarding to -challenge-listen address.
+If set, the response is fetched from this address, with the domain
+as the Host header, before the CA is asked to validate it,
+and the command fails early if it cannot be reached. This also applies
+to -manual mode.
+
+The -check-from argument, which may be repeated, specifies a vantage point
+from which the challenge response is checked before the CA is asked
+to validate it, since CAs such as Let's Encrypt validate from several
+networks and a check from the local one alone may succeed while theirs fail.
+An http:// or https:// URL is an HTTP proxy, through which the http-01
+response is fetched as the CA would. A dns://host[:port] URL is a DNS
+resolver, with which the -dns TXT record is looked up. The value "dns"
+stands for a built-in list of public resolvers of different operators.
+The command fails if any of the checks fails.
+
+The -timeout argument limits the total time spent on the whole issuance flow,
+including challenge responses and waiting for the CA. The default is {{.CertTimeout}},
+or no limit with -manual or -dns, where publishing the challenge responses
+may take long. A zero value disables the deadline.
+Within it, -propagation-timeout limits waiting for a manual or -dns
+challenge response to be published and confirmed with enter, and
+-validation-timeout limits waiting for the CA to validate a challenge
+after it is accepted, for each domain. They are not limited by default.
+
+The -retry argument specifies how many times a failed challenge is posted
+to the CA again before giving up, the default being 0. It only helps with
+CAs which reset an invalid authorization to pending when its challenge
+is posted again; with other CAs the retries fail as well.
+
+The -poll argument specifies the interval of checking whether the CA
+has validated a challenge, and -poll-max the maximum number of checks.
+Their defaults are taken from ACME_POLL_INTERVAL and ACME_POLL_MAX
+environment variables, if set. Otherwise the CA's Retry-After hints
+are followed, with an increasing interval of up to 10s, until -timeout.
+
+The outcome of every challenge is logged with the time the CA took
+to validate it and, for the local http-01 server, the number of requests
+and their remote addresses, with the count of each. The local server keeps
+responding until the authorization is valid or invalid, not only until
+the challenge is accepted, since a CA may validate it from several
+vantage points over a few seconds. With -json, it is written to the standard error
+as a JSON object with "event": "challenge". The outcome of the command
+is written as one with "event": "cert", the "domains", the certificate "path"
+and whether it "changed", which is false if the certificate was up to date
+or the CA returned the same one. The latter is also recorded
+in the issuance manifest.
 
 An alternative to local server challenge response may be specified with -manual or -dns,
 in which case instructions are displayed on the standard output.
+With -manual-output=json, instead of the instructions and waiting for enter,
+the resources to publish are printed as JSON objects, one per line,
+and the command exits. For http-01 these are "url" and "content",
+and for dns-01 "fqdn" and "txt" of the TXT record, along with "domain"
+and "type". Once they are published, run acme continue with the same
+domains to have the CA validate them and obtain the certificate
+as specified by the original cert command arguments, which are kept
+in {{.PendingFile}} in the account dir meanwhile.
+
+Authorizations of an interrupted run are kept in {{.AuthzFile}}
+in the config dir. Running the command again for the same domains
+resumes them instead of requesting new ones.
+If the command is interrupted, pending authorizations it has created
+are deactivated and published challenge responses are removed.
+
+Alternatively, certificates can be requested for existing certificate
+requests, keeping the keys on the host where they were generated.
+The -csr-dir argument specifies a directory with PEM or DER encoded CSR files
+with .csr extension. For each CSR, the domains it contains are authorized
+and the certificate is written to the -cert-dir directory, using the CSR file
+name with .crt extension. Domain arguments are not allowed in this mode.
+A status line is printed for every CSR; a failed CSR does not prevent
+the others from being processed, but an interrupt stops the run.
+The -timeout argument and the fallback CAs described below apply to each
+CSR separately.
+
+The CA directory URL defaults to the one the account was registered with.
+
+If issuance fails with a CA-side error, such as an internal server error
+or a rate limit, and the account config has a fallback list of CA accounts,
+the certificate is requested again using the next account from the list.
+See -fallback argument of the update command.
 
 Default location of the config dir is
 {{.ConfigDir}}.
 		`,
 	}
 
-	certDisco   = defaultDiscoFlag
+	certDisco   discoAliasFlag // defaults to account's CA
 	certAddr    = "127.0.0.1:8080"
+	certPublic  string
 	certExpiry  = 365 * 12 * time.Hour
+	certTimeout = time.Hour
+	certRetry   = 0
+	certPoll    time.Duration // ACME_POLL_INTERVAL
+	certPollMax int           // ACME_POLL_MAX
+	certRenew   = 30 * 24 * time.Hour
+	certIssuer  string
+	certForce   = false
 	certBundle  = true
+	certPins    = false
+	certCSR     = false
 	certManual  = false
 	certDNS     = false
 	certKeypath string
+	certCN      string
+	certCSRDir  string
+	certCertDir string
+	certOut     string
+	certLink    string
+	certOwner   string
+	certFormat  = "pem"
+	certPass    string
+	certStore   string
+	certGroup   string
+	certEKU     string
+	certExts    extFlag
+)
+
+// certFlags are the cert command flags.
+// It is cmdCert.flag, which runCert cannot refer to directly.
+var certFlags *flag.FlagSet
+
+// Separate output files in addition to the main certificate file.
+var (
+	certLeafOut      string // -leaf
+	certChainOut     string // -chain
+	certFullchainOut string // -fullchain
+
+	// certRoot is a file name or URL of the root certificate to append
+	// to the CA chain.
+	certRoot string
+)
+
+// Limits of challenge phases within -timeout, per domain; zero means no limit.
+var (
+	certPropagationTimeout time.Duration // publishing a manual or -dns response
+	certValidationTimeout  time.Duration // CA validation after accepting a challenge
 )
 
+// certManualOutput is the format of -manual and -dns challenge instructions:
+// "text" prompts and waits for enter, "json" prints the resources to publish
+// and leaves the challenges to continue command.
+var certManualOutput = "text"
+
+// errChallengePending is returned by authz when a challenge awaits
+// continue command, as requested with -manual-output=json.
+var errChallengePending = errors.New("challenge awaits acme continue")
+
 func init() {
+	certFlags = &cmdCert.flag
+	// polling defaults from the environment; invalid values are ignored
+	if d, err := time.ParseDuration(os.Getenv("ACME_POLL_INTERVAL")); err == nil {
+		certPoll = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("ACME_POLL_MAX")); err == nil {
+		certPollMax = n
+	}
 	cmdCert.flag.Var(&certDisco, "d", "")
 	cmdCert.flag.StringVar(&certAddr, "s", certAddr, "")
+	cmdCert.flag.StringVar(&certAddr, "challenge-listen", certAddr, "")
+	cmdCert.flag.StringVar(&certPublic, "challenge-public", "", "")
+	cmdCert.flag.Var(&certCheckFrom, "check-from", "")
 	cmdCert.flag.DurationVar(&certExpiry, "expiry", certExpiry, "")
+	cmdCert.flag.DurationVar(&certTimeout, "timeout", certTimeout, "")
+	cmdCert.flag.DurationVar(&certPropagationTimeout, "propagation-timeout", 0, "")
+	cmdCert.flag.DurationVar(&certValidationTimeout, "validation-timeout", 0, "")
+	cmdCert.flag.IntVar(&certRetry, "retry", certRetry, "")
+	cmdCert.flag.DurationVar(&certPoll, "poll", certPoll, "")
+	cmdCert.flag.IntVar(&certPollMax, "poll-max", certPollMax, "")
+	cmdCert.flag.DurationVar(&certRenew, "renew-before", certRenew, "")
+	cmdCert.flag.StringVar(&certIssuer, "issuer", "", "")
+	cmdCert.flag.BoolVar(&certForce, "force", certForce, "")
 	cmdCert.flag.BoolVar(&certBundle, "bundle", certBundle, "")
+	cmdCert.flag.BoolVar(&certPins, "pins", certPins, "")
+	cmdCert.flag.BoolVar(&certCSR, "csr", certCSR, "")
+	cmdCert.flag.StringVar(&certPack, "pack", "", "")
+	cmdCert.flag.IntVar(&certPackSize, "pack-size", certPackSize, "")
 	cmdCert.flag.BoolVar(&certManual, "manual", certManual, "")
 	cmdCert.flag.BoolVar(&certDNS, "dns", certDNS, "")
+	cmdCert.flag.StringVar(&certManualOutput, "manual-output", certManualOutput, "")
 	cmdCert.flag.StringVar(&certKeypath, "k", "", "")
+	cmdCert.flag.StringVar(&keyType, "key-type", "", "")
+	cmdCert.flag.StringVar(&certSigner, "signer", "", "")
+	cmdCert.flag.StringVar(&certCN, "cn", "", "")
+	cmdCert.flag.StringVar(&certCSRDir, "csr-dir", "", "")
+	cmdCert.flag.StringVar(&certCertDir, "cert-dir", "", "")
+	cmdCert.flag.StringVar(&certOut, "out", "", "")
+	cmdCert.flag.StringVar(&certLink, "link", "", "")
+	cmdCert.flag.StringVar(&certLeafOut, "leaf", "", "")
+	cmdCert.flag.StringVar(&certChainOut, "chain", "", "")
+	cmdCert.flag.StringVar(&certFullchainOut, "fullchain", "", "")
+	cmdCert.flag.StringVar(&certRoot, "root", "", "")
+	cmdCert.flag.Var(&outCertMode, "cert-mode", "")
+	cmdCert.flag.Var(&outKeyMode, "key-mode", "")
+	cmdCert.flag.StringVar(&certOwner, "owner", "", "")
+	cmdCert.flag.StringVar(&certGroup, "group", "", "")
+	cmdCert.flag.StringVar(&outSELinux, "selinux", "", "")
+	cmdCert.flag.StringVar(&certFormat, "format", certFormat, "")
+	cmdCert.flag.StringVar(&certPass, "store-pass-file", "", "")
+	cmdCert.flag.StringVar(&certStore, "cert-store", "", "")
+	cmdCert.flag.StringVar(&certEKU, "eku", "", "")
+	cmdCert.flag.StringVar(&certSuccessHook, "success-hook", "", "")
+	cmdCert.flag.StringVar(&certFailureHook, "failure-hook", "", "")
+	cmdCert.flag.DurationVar(&hookTimeout, "hook-timeout", hookTimeout, "")
+	cmdCert.flag.StringVar(&hookUser, "hook-user", "", "")
+	cmdCert.flag.Var(&certExts, "ext", "")
 }
 
 func runCert(args []string) {
+	if certManual && certDNS {
+		fatalf("-dns and -manual are mutually exclusive, only one should be specified")
+	}
+	if certManual || certDNS {
+		// publishing the challenge responses may take long,
+		// so the flow is only limited if asked to
+		explicit := false
+		certFlags.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == "timeout"
+		})
+		if !explicit {
+			certTimeout = 0
+		}
+	}
+	switch certManualOutput {
+	case "text":
+	case "json":
+		if !certManual && !certDNS {
+			fatalf("-manual-output=json requires -manual or -dns")
+		}
+	default:
+		fatalf("-manual-output: unknown format %q", certManualOutput)
+	}
+	if err := setOwner(certOwner, certGroup); err != nil {
+		fatalf("-owner/-group: %v", err)
+	}
+	if _, ok := certFormats[certFormat]; !ok {
+		fatalf("-format: unknown format %q", certFormat)
+	}
+	if certFormat == "jks" && certPass == "" {
+		fatalf("-format=jks requires -store-pass-file")
+	}
+	if certFormat == "jks" && fipsMode {
+		// JKS protects keys and the store integrity with SHA-1
+		fatalf("-format=jks is not allowed in FIPS mode")
+	}
+	if err := checkKeyType(keyType); err != nil {
+		fatalf("-key-type: %v", err)
+	}
+	if certStore != "" && runtime.GOOS != "windows" {
+		fatalf("-cert-store is only supported on Windows")
+	}
+	if certSigner != "" && (certFormat == "jks" || certPins || certKeypath != "" || certStore != "") {
+		fatalf("-signer cannot be used with -format=jks, -pins, -k or -cert-store")
+	}
+	if certLink != "" && certOut == "" {
+		fatalf("-link requires -out")
+	}
+	if certIssuer != "" {
+		if _, err := parseFingerprints(certIssuer); err != nil {
+			fatalf("-issuer: %v", err)
+		}
+		if (!certBundle || certFormat != "pem") && certChainOut == "" && certFullchainOut == "" {
+			fatalf("-issuer requires the CA chain bundled with the certificate or written with -chain or -fullchain")
+		}
+	}
+	if certCSRDir != "" || certCertDir != "" {
+		if len(args) != 0 {
+			fatalf("domain arguments cannot be used with -csr-dir")
+		}
+		if certFormat == "jks" {
+			fatalf("-format=jks cannot be used with -csr-dir, which has no private keys")
+		}
+		if certStore != "" {
+			fatalf("-cert-store cannot be used with -csr-dir, which has no private keys")
+		}
+		ctx, cancel := interruptContext(context.Background())
+		defer cancel()
+		runCertBatch(ctx)
+		return
+	}
 	if len(args) == 0 {
 		fatalf("no domain specified")
 	}
-	if certManual && certDNS {
-		fatalf("-dns and -manual are mutually exclusive, only one should be specified")
+	for i, a := range args {
+		d, err := normalizeDomain(a)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		args[i] = d
+	}
+	ctx, cancel := interruptContext(context.Background())
+	defer cancel()
+	if certPack != "" {
+		runCertPack(ctx, args)
+		return
+	}
+	if err := obtainCert(ctx, args); err != nil {
+		fatalf("%v", err)
 	}
-	cn := args[0]
+}
+
+// obtainCert requests a certificate for normalized domain names args,
+// unless an existing one is still good, and writes it along with
+// the other outputs requested by the cert command flags.
+// The issuance flow is bounded by -timeout argument within ctx.
+// Issuance failures run -failure-hook, if any, before they are returned.
+func obtainCert(ctx context.Context, args []string) error {
+	name := args[0]
 	if certKeypath == "" {
-		certKeypath = filepath.Join(configDir, cn+".key")
+		certKeypath = filepath.Join(configDir, name+".key")
+	}
+	sans := uniqueSorted(args)
+	cn := name
+	switch certCN {
+	case "":
+		// default to the first domain
+	case noCN:
+		cn = ""
+	default:
+		var err error
+		if cn, err = normalizeDomain(certCN); err != nil {
+			return fmt.Errorf("-cn: %v", err)
+		}
 	}
 
 	// get user config
+	uc, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("read config: %v", err)
+	}
+	if uc.key == nil {
+		return fmt.Errorf("no key found for %s", uc.URI)
+	}
+
+	// read or generate new cert key
+	warnKeyPerm(accountKeyPath())
+	var certKey crypto.Signer
+	keyExists := true
+	if certSigner != "" {
+		if certKey, err = newRemoteSigner(certSigner); err != nil {
+			return fmt.Errorf("-signer: %v", err)
+		}
+	} else {
+		warnKeyPerm(certKeypath)
+		_, err = os.Stat(certKeypath)
+		keyExists = err == nil
+		if !keyExists && certPins {
+			if keyExists, err = promoteBackupKey(certKeypath, sameDir(certKeypath, name+".backup.key")); err != nil {
+				return fmt.Errorf("backup key: %v", err)
+			}
+		}
+		if certKey, err = writeOutputKey(certKeypath); err != nil {
+			return fmt.Errorf("cert key: %v", err)
+		}
+	}
+	if err := checkFIPSKey(certKey.Public()); err != nil {
+		return fmt.Errorf("cert key: %v", err)
+	}
+	if err := checkKeyStrength(certKey.Public()); err != nil {
+		return fmt.Errorf("cert key %s: %v; remove it to generate a new one", certKeypath, err)
+	}
+	if keyExists {
+		warnWeakKey(certKeypath, certKey.Public(), "remove it to generate a new one")
+	}
+	certPath := sameDir(certKeypath, name+certFormats[certFormat])
+	var linkPath string
+	if certLink != "" {
+		var err error
+		if linkPath, err = expandPath(certLink, outputData{Domain: name}); err != nil {
+			return fmt.Errorf("-link: %v", err)
+		}
+		certPath = linkPath
+	}
+	if !certForce && certFormat != "jks" && (certOut == "" || linkPath != "") {
+		if err := checkExistingCert(certPath, certKey.Public(), sans, unbundledChain(name)); err == nil {
+			logf("%s is up to date; use -force to request a new certificate", certPath)
+			reportCert(os.Stderr, sans, certPath, false)
+			return nil
+		} else if !os.IsNotExist(err) {
+			logf("%s: %v", certPath, err)
+		}
+	}
+
+	// generate CSR now to fail early in case of an error
+	exts := []pkix.Extension(certExts)
+	if certEKU != "" {
+		e, err := ekuExtension(certEKU)
+		if err != nil {
+			return fmt.Errorf("-eku: %v", err)
+		}
+		exts = append(exts, e)
+	}
+	csr, err := newCSR(certKey, cn, sans, exts)
+	if err != nil {
+		return fmt.Errorf("csr: %v", err)
+	}
+	if certCSR {
+		csrPath := sameDir(certKeypath, name+".csr")
+		csr = reuseCSR(csrPath, csr)
+		if err := writeCSR(csrPath, csr); err != nil {
+			return fmt.Errorf("csr: %v", err)
+		}
+		sum := csrHash(csr)
+		logf("%s: sha256 %s", csrPath, sum)
+		if err := writeAudit("csr %s sha256 %s", csrPath, sum); err != nil {
+			logf("%s: %v", auditFile, err)
+		}
+	}
+
+	ctx, cancel := withCertTimeout(ctx)
+	defer cancel()
+	disco := string(certDisco)
+	if disco == "" {
+		disco = accountDisco(uc)
+	}
+	res, err := issueFallback(ctx, uc, disco, csr, sans)
+	cert, attempts := res.cert, res.attempts
+	if errors.Is(err, errChallengePending) {
+		if err := savePendingCert(res.account.accountDir(), args, res.disco); err != nil {
+			return fmt.Errorf("%s: %v", pendingFile, err)
+		}
+		cont := "acme continue"
+		if res.ca != flagCA {
+			cont += " -ca " + res.ca
+		}
+		logf("publish the challenge responses, then run: %s %s", cont, strings.Join(sans, " "))
+		return nil
+	}
+	if err != nil {
+		return certFailed(sans, attempts, err)
+	}
+	cert.key = certKey
+	cert.name = name
+	if cn != "" {
+		cert.name = cn
+	}
+	data := outputData{
+		Domain: name,
+		Serial: fmt.Sprintf("%x", cert.leaf.SerialNumber),
+		Date:   cert.issued.Format("20060102"),
+	}
+	if certOut != "" {
+		if certPath, err = expandPath(certOut, data); err != nil {
+			return fmt.Errorf("-out: %v", err)
+		}
+		if err := mkdirOutput(filepath.Dir(certPath), outCertMode); err != nil {
+			return fmt.Errorf("write cert: %v", err)
+		}
+		if certPath == linkPath {
+			return fmt.Errorf("-out and -link refer to the same file %s", certPath)
+		}
+	}
+	if prev, err := readLeaf(certPath); err == nil && bytes.Equal(prev.Raw, cert.chain[0]) {
+		logf("%s: the CA returned the same certificate", certPath)
+	} else {
+		cert.changed = true
+	}
+	if err := writeCert(certPath, cert); err != nil {
+		return certFailed(sans, attempts, fmt.Errorf("write cert: %w", err))
+	}
+	files := map[string]string{"cert": certPath}
+	if linkPath != "" {
+		files["cert"] = linkPath
+	}
+	parts := []struct {
+		flag, tmpl string
+		certs      [][]byte
+	}{
+		{"-leaf", certLeafOut, cert.chain[:1]},
+		{"-chain", certChainOut, cert.chain[1:]},
+		{"-fullchain", certFullchainOut, cert.chain},
+	}
+	for _, p := range parts {
+		if p.tmpl == "" {
+			continue
+		}
+		path, err := expandPath(p.tmpl, data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.flag, err)
+		}
+		if err := mkdirOutput(filepath.Dir(path), outCertMode); err != nil {
+			return fmt.Errorf("%s: %v", p.flag, err)
+		}
+		if err := writePEMCerts(path, p.certs); err != nil {
+			return fmt.Errorf("%s: %v", p.flag, err)
+		}
+		files[p.flag[1:]] = path
+	}
+	if linkPath != "" {
+		if err := updateLink(linkPath, certPath); err != nil {
+			return fmt.Errorf("update link: %v", err)
+		}
+	}
+	if certPins {
+		backup, err := writeOutputKey(sameDir(certKeypath, name+".backup.key"))
+		if err != nil {
+			return fmt.Errorf("backup key: %v", err)
+		}
+		if err := writePins(sameDir(certKeypath, name+".pins"), certKey, backup); err != nil {
+			return fmt.Errorf("write pins: %v", err)
+		}
+	}
+	if err := writeManifest(manifestPath(certKeypath, name), newManifest(args, cert, files)); err != nil {
+		errorf("write manifest: %v", err)
+	}
+	if err := removePendingCert(res.account.accountDir(), args); err != nil {
+		errorf("%s: %v", pendingFile, err)
+	}
+	if certStore != "" {
+		if err := importCertStore(certStore, cert); err != nil {
+			return certFailed(sans, attempts, fmt.Errorf("-cert-store: %w", err))
+		}
+		logf("%s: imported into the %s store, thumbprint %X", certPath, certStore, sha1.Sum(cert.chain[0]))
+	}
+	reportCert(os.Stderr, sans, certPath, cert.changed)
+	if cert.changed {
+		keyPath := certKeypath
+		if certSigner != "" {
+			keyPath = "" // no key file
+		}
+		certIssued(sans, certPath, keyPath, attempts)
+	}
+	return nil
+}
+
+// issuance is the outcome of issueFallback.
+type issuance struct {
+	cert     *certificate
+	ca       string      // -ca name of the account which issued cert, or failed last
+	account  *userConfig // the account of ca
+	disco    string      // CA directory URL used with account
+	attempts int
+}
+
+// issueFallback requests a certificate as issue does, using account uc
+// with CA directory disco first, then the accounts of uc.Fallback CAs
+// in turn, for as long as issuance fails with a CA-side error.
+// The returned issuance is never nil.
+func issueFallback(ctx context.Context, uc *userConfig, disco string, csr []byte, sans []string) (*issuance, error) {
+	res := &issuance{ca: flagCA, account: uc, disco: disco, attempts: 1}
+	var err error
+	res.cert, err = issue(ctx, uc, disco, csr, sans)
+	for _, fca := range uc.Fallback {
+		if err == nil || !shouldFallback(err) {
+			break
+		}
+		if !validDirName(fca) {
+			logf("fallback: invalid CA name %q", fca)
+			continue
+		}
+		logf("%v; falling back to %s", err, fca)
+		fuc, ferr := readCAConfig(fca)
+		if ferr != nil {
+			logf("%s: read config: %v", fca, ferr)
+			continue
+		}
+		if fuc.key == nil {
+			logf("%s: no key found for %s", fca, fuc.URI)
+			continue
+		}
+		res.ca, res.account, res.disco = fca, fuc, accountDisco(fuc)
+		res.cert, err = issue(ctx, fuc, res.disco, csr, sans)
+		res.attempts++
+	}
+	return res, err
+}
+
+// runCertBatch requests certificates for all CSR files found in certCSRDir
+// and writes them to certCertDir. The -timeout argument applies to each CSR.
+// Once ctx is cancelled, as on interrupt, the remaining CSRs are not attempted.
+func runCertBatch(ctx context.Context) {
+	if certCSRDir == "" || certCertDir == "" {
+		fatalf("both -csr-dir and -cert-dir must be specified")
+	}
+	files, err := filepath.Glob(filepath.Join(certCSRDir, "*.csr"))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(files) == 0 {
+		fatalf("no .csr files found in %s", certCSRDir)
+	}
+	if err := mkdirOutput(certCertDir, outCertMode); err != nil {
+		fatalf("%v", err)
+	}
+
 	uc, err := readConfig()
 	if err != nil {
 		fatalf("read config: %v", err)
@@ -96,87 +831,576 @@ func runCert(args []string) {
 	if uc.key == nil {
 		fatalf("no key found for %s", uc.URI)
 	}
+	disco := string(certDisco)
+	if disco == "" {
+		disco = accountDisco(uc)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	defer tw.Flush()
+	for i, f := range files {
+		if err := ctx.Err(); err != nil {
+			errorf("%v: %d CSRs not attempted", err, len(files)-i)
+			for _, f := range files[i:] {
+				fmt.Fprintf(tw, "%s\tnot attempted\n", f)
+			}
+			return
+		}
+		base := strings.TrimSuffix(filepath.Base(f), ".csr")
+		certPath := filepath.Join(certCertDir, base+certFormats[certFormat])
+		cctx, cancel := withCertTimeout(ctx)
+		err := issueCSRFile(cctx, uc, disco, f, certPath)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(tw, "%s\tfailed\t%v\n", f, err)
+			setExitStatus(1)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tok\t%s\n", f, certPath)
+	}
+}
 
-	// read or generate new cert key
-	certKey, err := anyKey(certKeypath, true)
+// issueCSRFile obtains a certificate for the CSR stored in csrPath
+// from the CA directory disco, or a fallback CA, and writes it to certPath.
+func issueCSRFile(ctx context.Context, uc *userConfig, disco, csrPath, certPath string) error {
+	b, err := ioutil.ReadFile(csrPath)
 	if err != nil {
-		fatalf("cert key: %v", err)
+		return err
 	}
-	// generate CSR now to fail early in case of an error
-	req := &x509.CertificateRequest{
-		Subject: pkix.Name{CommonName: cn},
+	if p, _ := pem.Decode(b); p != nil {
+		b = p.Bytes
+	}
+	req, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return err
+	}
+	if err := req.CheckSignature(); err != nil {
+		return err
+	}
+	if err := checkFIPSKey(req.PublicKey); err != nil {
+		return err
+	}
+	if err := checkFIPSSignature(req.SignatureAlgorithm); err != nil {
+		return err
+	}
+	names := req.DNSNames
+	if req.Subject.CommonName != "" {
+		names = append(names, req.Subject.CommonName)
+	}
+	if len(names) == 0 {
+		return errors.New("no domain names in the request")
+	}
+	for i, n := range names {
+		if names[i], err = normalizeDomain(n); err != nil {
+			return err
+		}
+	}
+	sans := uniqueSorted(names)
+	res, err := issueFallback(ctx, uc, disco, req.Raw, sans)
+	if err != nil {
+		return certFailed(sans, res.attempts, err)
+	}
+	if err := writeCert(certPath, res.cert); err != nil {
+		return certFailed(sans, res.attempts, fmt.Errorf("write cert: %w", err))
+	}
+	certIssued(sans, certPath, "", res.attempts)
+	return nil
+}
+
+// checkExistingCert verifies that the PEM encoded certificate at path
+// is issued for sans and public key pub, and does not need renewal yet
+// according to -renew-before and -issuer arguments.
+// If the certificate is not bundled with the CA chain, DER encoded ca
+// certificates are checked instead.
+// The returned error describes the reason the certificate cannot be used.
+func checkExistingCert(path string, pub crypto.PublicKey, sans []string, ca [][]byte) error {
+	chain, err := readCertChain(path)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 1 {
+		chain = append(chain, ca...)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return err
+	}
+	if err := checkCertNames(leaf, sans); err != nil {
+		return err
+	}
+	want, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	have, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want, have) {
+		return errors.New("certificate key does not match")
+	}
+	if renew := leaf.NotAfter.Add(-certRenew); !timeNow().Before(renew) {
+		return fmt.Errorf("expires at %s, due for renewal", leaf.NotAfter)
+	}
+	return checkIssuers(chain[1:])
+}
+
+// readCertChain reads DER or PEM encoded certificates from path.
+// The result contains at least one element.
+func readCertChain(path string) ([][]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	chain := [][]byte{b}
+	if p, rest := pem.Decode(b); p != nil {
+		chain = [][]byte{p.Bytes}
+		for p, rest = pem.Decode(rest); p != nil; p, rest = pem.Decode(rest) {
+			if p.Type == "CERTIFICATE" {
+				chain = append(chain, p.Bytes)
+			}
+		}
+	}
+	return chain, nil
+}
+
+// unbundledChain returns the CA chain of the existing certificate
+// for domain name as written with -chain or -fullchain argument.
+// It returns nil if neither is specified or the file cannot be read.
+func unbundledChain(name string) [][]byte {
+	data := outputData{Domain: name}
+	if certChainOut != "" {
+		if path, err := expandPath(certChainOut, data); err == nil {
+			if chain, err := readCertChain(path); err == nil {
+				return chain
+			}
+		}
+	}
+	if certFullchainOut != "" {
+		if path, err := expandPath(certFullchainOut, data); err == nil {
+			if chain, err := readCertChain(path); err == nil {
+				return chain[1:]
+			}
+		}
+	}
+	return nil
+}
+
+// checkIssuers verifies that none of the DER encoded CA certificates
+// expire within -renew-before duration, and the first one, the issuer,
+// is listed in -issuer argument, if specified.
+func checkIssuers(chain [][]byte) error {
+	for _, der := range chain {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		if renew := c.NotAfter.Add(-certRenew); !timeNow().Before(renew) {
+			return fmt.Errorf("CA certificate %q expires at %s, due for renewal", c.Subject.CommonName, c.NotAfter)
+		}
+	}
+	if certIssuer == "" {
+		return nil
+	}
+	if len(chain) == 0 {
+		return errors.New("no CA certificate bundled to match -issuer")
+	}
+	fps, err := parseFingerprints(certIssuer)
+	if err != nil {
+		return fmt.Errorf("-issuer: %v", err)
+	}
+	sum := sha256.Sum256(chain[0])
+	for _, fp := range fps {
+		if bytes.Equal(fp, sum[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("issuer %x is not listed in -issuer, due for renewal", sum)
+}
+
+// parseFingerprints decodes comma separated hex SHA-256 fingerprints,
+// optionally with colons between bytes.
+func parseFingerprints(v string) ([][]byte, error) {
+	var fps [][]byte
+	for _, s := range strings.Split(v, ",") {
+		fp, err := hex.DecodeString(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+		if err != nil || len(fp) != sha256.Size {
+			return nil, fmt.Errorf("%q: invalid SHA-256 fingerprint", s)
+		}
+		fps = append(fps, fp)
+	}
+	return fps, nil
+}
+
+// certContext returns a context for the issuance flow,
+// bounded by -timeout argument and cancelled on interrupt.
+func certContext() (context.Context, context.CancelFunc) {
+	ctx, stop := interruptContext(context.Background())
+	ctx, cancel := withCertTimeout(ctx)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// withCertTimeout returns a copy of parent bounded by -timeout argument,
+// if it is set.
+func withCertTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if certTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, certTimeout)
+}
+
+// certFormats maps -format values to default certificate file extensions.
+var certFormats = map[string]string{
+	"pem": ".crt",
+	"der": ".der",
+	"jks": ".jks",
+}
+
+// writeCert writes certificate c to path, encoded according to -format argument.
+func writeCert(path string, c *certificate) error {
+	var (
+		b     []byte
+		mode  = outCertMode
+		chain = c.chain
+	)
+	if !certBundle {
+		// the chain was requested for -chain or -fullchain only
+		chain = chain[:1]
+	}
+	switch certFormat {
+	case "der":
+		b = c.chain[0]
+	case "jks":
+		if c.key == nil {
+			return errors.New("jks format requires the certificate key")
+		}
+		pass, err := readPassword(certPass)
+		if err != nil {
+			return err
+		}
+		if b, err = encodeJKS(c.name, c.key, chain, pass, c.issued); err != nil {
+			return err
+		}
+		mode = outKeyMode
+	default:
+		b = pemCerts(chain)
+	}
+	return writeOutput(path, b, mode)
+}
+
+// writePEMCerts writes DER encoded certs to path in PEM format,
+// with -cert-mode permissions.
+func writePEMCerts(path string, certs [][]byte) error {
+	return writeOutput(path, pemCerts(certs), outCertMode)
+}
+
+// pemCerts encodes DER encoded certs in PEM format.
+func pemCerts(certs [][]byte) []byte {
+	var b []byte
+	for _, der := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return b
+}
+
+// writeOutput writes b to path with the given mode,
+// and applies and verifies the output file attributes.
+func writeOutput(path string, b []byte, mode modeFlag) error {
+	if err := ioutil.WriteFile(path, b, os.FileMode(mode)); err != nil {
+		return err
+	}
+	if err := setPerm(path, mode); err != nil {
+		return err
+	}
+	return verifyOutput(path, b, mode)
+}
+
+// readPassword returns the first line of file.
+func readPassword(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
 	}
-	if len(args) > 1 {
-		req.DNSNames = args
+	if i := bytes.IndexAny(b, "\r\n"); i >= 0 {
+		b = b[:i]
 	}
-	csr, err := x509.CreateCertificateRequest(rand.Reader, req, certKey)
+	return string(b), nil
+}
+
+// certificate is the result of a successful issuance.
+type certificate struct {
+	leaf   *x509.Certificate
+	chain  [][]byte      // DER encoded leaf followed by CA chain, if requested
+	key    crypto.Signer // nil if the key is not available to the command
+	url    string        // certificate URL at the CA
+	issued time.Time     // when the certificate was received
+	sans   []string      // sorted DNS names
+	name   string        // -cn domain or the first domain argument, the JKS alias and PFX friendly name
+	ca     string        // directory URL of the issuing CA
+
+	// changed reports whether the certificate differs from the one
+	// previously written to the same file, if any.
+	changed bool
+}
+
+// ariCertID returns the certificate identifier used with the ACME
+// Renewal Information extension, RFC 9773: base64url encoded
+// authority key identifier and serial number, joined with a dot.
+func (c *certificate) ariCertID() string {
+	enc := base64.RawURLEncoding
+	// the encoding must retain the leading zero of a DER integer, if any
+	serial := c.leaf.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return enc.EncodeToString(c.leaf.AuthorityKeyId) + "." + enc.EncodeToString(serial)
+}
+
+// accountDisco returns the CA directory URL recorded in account uc,
+// or the default one if there is none.
+func accountDisco(uc *userConfig) string {
+	if uc.CA == "" {
+		return string(defaultDiscoFlag)
+	}
+	return uc.CA
+}
+
+// issue obtains a certificate for csr from the CA directory disco,
+// as in -d, with account uc, authorizing all sans first.
+// The returned certificate is verified to be issued for sans.
+func issue(ctx context.Context, uc *userConfig, disco string, csr []byte, sans []string) (*certificate, error) {
+	// authorizations of a previously interrupted run, if any
+	dir := uc.accountDir()
+	state, err := readAuthzState(dir)
 	if err != nil {
-		fatalf("csr: %v", err)
+		return nil, fmt.Errorf("read authz state: %w", err)
 	}
 
 	// initialize acme client and start authz flow
 	// we only look for http-01 challenges at the moment
-	client := &acme.Client{
-		Key:          uc.key,
-		DirectoryURL: string(certDisco),
+	client, err := newClient(uc.key, disco)
+	if err != nil {
+		return nil, fmt.Errorf("-d: %w", err)
 	}
-	for _, domain := range args {
-		ctx, cancel := context.Background(), func() {}
-		if !certManual && !certDNS {
-			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+	fp, err := discoverCA(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCA(uc, disco, fp); err != nil {
+		return nil, fmt.Errorf("write config: %v", err)
+	}
+	prev := make(authzState, len(state))
+	for k, v := range state {
+		prev[k] = v
+	}
+	var pending bool
+	for _, domain := range sans {
+		err := authz(ctx, client, dir, domain, state)
+		if err == errChallengePending {
+			pending = true
+			continue
 		}
-		if err := authz(ctx, client, domain); err != nil {
-			fatalf("%s: %v", domain, err)
+		if err != nil {
+			if ctx.Err() == context.Canceled || len(uc.Fallback) > 0 && shouldFallback(err) {
+				// interrupted, or the certificate is to be requested
+				// from a fallback CA instead
+				abandonAuthz(client, dir, state, prev)
+			}
+			return nil, fmt.Errorf("%s: %w", domain, err)
 		}
-		cancel()
+	}
+	if pending {
+		return nil, errChallengePending
 	}
 
 	// challenge fulfilled: get the cert
-	// wait at most 30 min
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	cert, curl, err := client.CreateCert(ctx, csr, certExpiry, certBundle)
+	bundle := certBundle || certChainOut != "" || certFullchainOut != "" || certRoot != ""
+	cert, curl, err := client.CreateCert(ctx, csr, certExpiry, bundle)
 	if err != nil {
-		fatalf("cert: %v", err)
+		return nil, fmt.Errorf("cert: %w", err)
 	}
 	logf("cert url: %s", curl)
-	var pemcert []byte
-	for _, b := range cert {
-		b = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})
-		pemcert = append(pemcert, b...)
+	if len(cert) == 0 || len(cert[0]) == 0 {
+		return nil, fmt.Errorf("cert: %s: no certificate in CA response", curl)
+	}
+	leaf, err := x509.ParseCertificate(cert[0])
+	if err != nil {
+		return nil, fmt.Errorf("cert: %w", err)
+	}
+	if err := checkCertNames(leaf, sans); err != nil {
+		return nil, fmt.Errorf("cert: %w", err)
+	}
+	if certRoot != "" {
+		if cert, err = appendRoot(ctx, client.HTTPClient, disco, cert, certRoot); err != nil {
+			return nil, fmt.Errorf("-root: %w", err)
+		}
+	}
+
+	// keep valid authorizations for reuse until they expire
+	for _, domain := range sans {
+		exp, err := authzExpiry(ctx, client, state[domain].URI)
+		if err != nil {
+			logf("%s: authorization will not be reused: %v", domain, err)
+			delete(state, domain)
+			continue
+		}
+		state[domain] = authzEntry{URI: state[domain].URI, Expires: exp}
+	}
+	for domain, e := range state {
+		if e.expired() {
+			delete(state, domain)
+		}
 	}
-	certPath := sameDir(certKeypath, cn+".crt")
-	if err := ioutil.WriteFile(certPath, pemcert, 0644); err != nil {
-		fatalf("write cert: %v", err)
+	if err := writeAuthzState(dir, state); err != nil {
+		errorf("write authz state: %v", err)
 	}
+	return &certificate{
+		leaf:   leaf,
+		chain:  cert,
+		url:    curl,
+		issued: timeNow(),
+		sans:   sans,
+		ca:     disco,
+	}, nil
 }
 
-func authz(ctx context.Context, client *acme.Client, domain string) error {
-	z, err := client.Authorize(ctx, domain)
+// appendRoot appends the root certificate read from src, a file name
+// or an http(s) URL fetched with hc, to chain of DER encoded certificates.
+// The root must be self-signed and have signed the last certificate in chain.
+// If the CA directory disco was specified with a root fingerprint, the root
+// must match it.
+func appendRoot(ctx context.Context, hc *http.Client, disco string, chain [][]byte, src string) ([][]byte, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		b, err = fetchURL(ctx, hc, src)
+	} else {
+		b, err = ioutil.ReadFile(src)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if p, _ := pem.Decode(b); p != nil {
+		b = p.Bytes
+	}
+	root, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := root.CheckSignatureFrom(root); err != nil {
+		return nil, fmt.Errorf("%s is not a self-signed root: %v", src, err)
+	}
+	last, err := x509.ParseCertificate(chain[len(chain)-1])
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(last.Raw, root.Raw) {
+		return chain, nil // already included
+	}
+	if err := last.CheckSignatureFrom(root); err != nil {
+		return nil, fmt.Errorf("%s did not issue %q: %v", src, last.Subject.CommonName, err)
+	}
+	if _, fp, err := splitDisco(disco); err == nil && fp != nil {
+		if sum := sha256.Sum256(root.Raw); !bytes.Equal(sum[:], fp) {
+			return nil, fmt.Errorf("%s does not match the directory root fingerprint %x", src, fp)
+		}
+	}
+	return append(chain, root.Raw), nil
+}
+
+// fetchURL returns the body of a successful GET request to url,
+// sent with hc or http.DefaultClient if hc is nil.
+func fetchURL(ctx context.Context, hc *http.Client, url string) ([]byte, error) {
+	b, _, err := fetchURLHeader(ctx, hc, url)
+	return b, err
+}
+
+// fetchURLHeader is like fetchURL but also returns the response header.
+func fetchURLHeader(ctx context.Context, hc *http.Client, url string) ([]byte, http.Header, error) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", url, res.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	return b, res.Header, err
+}
+
+// shouldFallback reports whether err is a CA-side failure,
+// such as an internal server error or a rate limit,
+// which is not expected to happen with a different CA.
+func shouldFallback(err error) bool {
+	var e *acme.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.StatusCode >= 500 || strings.HasSuffix(e.ProblemType, ":rateLimited")
+}
+
+// authz proves domain ownership to the CA.
+// It resumes an authorization found in state, if it is still usable,
+// or starts a new one, recording its URI in state, stored in account dir.
+func authz(ctx context.Context, client *acme.Client, dir, domain string, state authzState) error {
+	var z *acme.Authorization
+	if e := state[domain]; !e.expired() {
+		z = resumeAuthz(ctx, client, e.URI)
+	}
+	if z == nil {
+		var err error
+		if z, err = client.Authorize(ctx, domain); err != nil {
+			return err
+		}
+		state[domain] = authzEntry{URI: z.URI}
+		if err := writeAuthzState(dir, state); err != nil {
+			return fmt.Errorf("write authz state: %v", err)
+		}
 	}
 	if z.Status == acme.StatusValid {
 		return nil
 	}
+	solve := "http-01"
+	if certDNS {
+		solve = "dns-01"
+	}
 	var chal *acme.Challenge
 	for _, c := range z.Challenges {
-		if (c.Type == "http-01" && !certDNS) || (c.Type == "dns-01" && certDNS) {
+		if c.Type == solve {
 			chal = c
 			break
 		}
 	}
 	if chal == nil {
-		return errors.New("no supported challenge found")
+		e := &challengeError{solvable: []string{solve}}
+		for _, c := range z.Challenges {
+			e.offered = append(e.offered, c.Type)
+		}
+		return e
 	}
 
-	// respond to http-01 challenge
-	ln, err := net.Listen("tcp", certAddr)
-	if err != nil {
-		return fmt.Errorf("listen %s: %v", certAddr, err)
+	if certManualOutput == "json" && (certManual || certDNS) {
+		if err := printManualChallenge(os.Stdout, client, domain, chal); err != nil {
+			return err
+		}
+		state[domain] = authzEntry{URI: z.URI, Challenge: chal.URI}
+		if err := writeAuthzState(dir, state); err != nil {
+			return fmt.Errorf("write authz state: %v", err)
+		}
+		return errChallengePending
 	}
-	defer ln.Close()
 
+	st := &challengeStats{Domain: domain, Type: chal.Type}
 	switch {
 	case certManual:
 		// manual challenge response
@@ -188,10 +1412,18 @@ func authz(ctx context.Context, client *acme.Client, domain string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Copy %s to http://%s%s and press enter.\n",
-			file, domain, client.HTTP01ChallengePath(chal.Token))
-		var x string
-		fmt.Scanln(&x)
+		defer os.Remove(file)
+		path := client.HTTP01ChallengePath(chal.Token)
+		fmt.Printf("Copy %s to http://%s%s and press enter.\n", file, domain, path)
+		if err := waitPropagation(ctx); err != nil {
+			return err
+		}
+		if err := selfCheck(ctx, domain, path, tok); err != nil {
+			return err
+		}
+		if err := checkPerspectives(ctx, domain, path, tok); err != nil {
+			return err
+		}
 	case certDNS:
 		val, err := client.DNS01ChallengeRecord(chal.Token)
 		if err != nil {
@@ -199,28 +1431,322 @@ func authz(ctx context.Context, client *acme.Client, domain string) error {
 		}
 		fmt.Printf("Add a TXT record for _acme-challenge.%s with the value %q and press enter after it has propagated.\n",
 			domain, val)
-		var x string
-		fmt.Scanln(&x)
+		defer func() {
+			if ctx.Err() != nil {
+				fmt.Printf("The TXT record for _acme-challenge.%s is no longer needed and can be removed.\n", domain)
+			}
+		}()
+		if err := waitPropagation(ctx); err != nil {
+			return err
+		}
+		if err := checkDNSPerspectives(ctx, domain, val); err != nil {
+			return err
+		}
 	default:
-		// auto, via local server
+		// auto, via local server, which keeps responding until
+		// the authorization is valid or invalid: CAs may validate
+		// from several vantage points after Accept returns
 		val, err := client.HTTP01ChallengeResponse(chal.Token)
 		if err != nil {
 			return err
 		}
+		ln, err := net.Listen("tcp", certAddr)
+		if err != nil {
+			return fmt.Errorf("listen %s: %v", certAddr, err)
+		}
+		defer ln.Close()
 		path := client.HTTP01ChallengePath(chal.Token)
-		go http.Serve(ln, http01Handler(path, val))
+		go http.Serve(ln, http01Handler(path, val, st))
+		if err := selfCheck(ctx, domain, path, val); err != nil {
+			return err
+		}
+		if err := checkPerspectives(ctx, domain, path, val); err != nil {
+			return err
+		}
+	}
+
+	st.begin()
+	for n := 0; ; n++ {
+		if _, err := client.Accept(ctx, chal); err != nil {
+			return fmt.Errorf("accept challenge: %w", err)
+		}
+		err := waitValidation(ctx, client, z.URI)
+		if err != acme.ErrAuthorizationFailed || n >= certRetry {
+			st.report(err)
+			return err
+		}
+		c, err := client.GetChallenge(ctx, chal.URI)
+		if err != nil {
+			return err
+		}
+		logf("%s: %s challenge is %s; retrying", domain, c.Type, c.Status)
+	}
+}
 
+// manualChallenge describes a resource to publish for a challenge,
+// as printed with -manual-output=json.
+type manualChallenge struct {
+	Domain  string `json:"domain"`
+	Type    string `json:"type"`
+	URL     string `json:"url,omitempty"`     // http-01 resource location
+	Content string `json:"content,omitempty"` // http-01 resource body
+	FQDN    string `json:"fqdn,omitempty"`    // dns-01 TXT record name
+	TXT     string `json:"txt,omitempty"`     // dns-01 TXT record value
+}
+
+// printManualChallenge writes the manualChallenge of chal for domain to w
+// as a single line JSON object.
+func printManualChallenge(w io.Writer, client *acme.Client, domain string, chal *acme.Challenge) error {
+	mc := manualChallenge{Domain: domain, Type: chal.Type}
+	var err error
+	switch chal.Type {
+	case "http-01":
+		mc.URL = "http://" + domain + client.HTTP01ChallengePath(chal.Token)
+		mc.Content, err = client.HTTP01ChallengeResponse(chal.Token)
+	case "dns-01":
+		mc.FQDN = "_acme-challenge." + domain
+		mc.TXT, err = client.DNS01ChallengeRecord(chal.Token)
+	default:
+		err = fmt.Errorf("unsupported challenge type %q", chal.Type)
+	}
+	if err != nil {
+		return err
 	}
+	b, err := json.Marshal(mc)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// waitPropagation waits for enter, as waitEnter,
+// for up to -propagation-timeout.
+func waitPropagation(ctx context.Context) error {
+	return withPhaseTimeout(ctx, certPropagationTimeout, "propagation-timeout", waitEnter)
+}
+
+// waitValidation waits for the authorization at uri, as waitAuthz,
+// for up to -validation-timeout.
+func waitValidation(ctx context.Context, client *acme.Client, uri string) error {
+	return withPhaseTimeout(ctx, certValidationTimeout, "validation-timeout", func(ctx context.Context) error {
+		return waitAuthz(ctx, client, uri)
+	})
+}
 
-	if _, err := client.Accept(ctx, chal); err != nil {
-		return fmt.Errorf("accept challenge: %v", err)
+// withPhaseTimeout runs f with ctx limited to d, unless d is zero.
+// If the limit is reached, the error names the flag which set it.
+func withPhaseTimeout(ctx context.Context, d time.Duration, flag string, f func(context.Context) error) error {
+	if d <= 0 {
+		return f(ctx)
+	}
+	pctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := f(pctx)
+	if err != nil && ctx.Err() == nil && pctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("-%s of %v exceeded: %w", flag, d, err)
 	}
-	_, err = client.WaitAuthorization(ctx, z.URI)
 	return err
 }
 
+// waitAuthz waits until the authorization at uri becomes valid,
+// returning acme.ErrAuthorizationFailed if it becomes invalid.
+// Unless -poll or -poll-max is specified, the acme package's polling
+// with the CA's Retry-After hints is used.
+func waitAuthz(ctx context.Context, client *acme.Client, uri string) error {
+	if certPoll <= 0 && certPollMax <= 0 {
+		_, err := client.WaitAuthorization(ctx, uri)
+		return err
+	}
+	interval := certPoll
+	if interval <= 0 {
+		interval = 3 * time.Second
+	}
+	for n := 1; ; n++ {
+		z, err := client.GetAuthorization(ctx, uri)
+		if err != nil {
+			return err
+		}
+		switch z.Status {
+		case acme.StatusValid:
+			return nil
+		case acme.StatusInvalid:
+			return acme.ErrAuthorizationFailed
+		}
+		if certPollMax > 0 && n >= certPollMax {
+			return fmt.Errorf("authorization is still %s after %d attempts", z.Status, n)
+		}
+		if err := timeSleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// selfCheck verifies that the http-01 challenge response for domain
+// is reachable at the -challenge-public address, as the CA would request it,
+// before the challenge is accepted. It does nothing if -challenge-public
+// is not set.
+func selfCheck(ctx context.Context, domain, path, want string) error {
+	if certPublic == "" {
+		return nil
+	}
+	u := "http://" + certPublic + path
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = domain
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := checkChallenge(ctx, http.DefaultClient, req, want); err != nil {
+		return fmt.Errorf("self-check: %v", err)
+	}
+	return nil
+}
+
+// checkChallenge sends req with hc and verifies that the response
+// is an http-01 challenge response want.
+func checkChallenge(ctx context.Context, hc *http.Client, req *http.Request, want string) error {
+	res, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	if err != nil {
+		return fmt.Errorf("%s: %v", req.URL, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req.URL, res.Status)
+	}
+	if strings.TrimSpace(string(b)) != want {
+		return fmt.Errorf("%s: unexpected response %q", req.URL, b)
+	}
+	return nil
+}
+
+// challengeError reports that none of the challenges offered by the CA
+// can be solved with the current command arguments.
+type challengeError struct {
+	offered  []string // challenge types offered by the CA
+	solvable []string // challenge types the command is set up to solve
+}
+
+func (e *challengeError) Error() string {
+	msg := fmt.Sprintf("no supported challenge found: CA offered [%s], configured for [%s]",
+		strings.Join(e.offered, " "), strings.Join(e.solvable, " "))
+	if h := e.hint(); h != "" {
+		msg += "; " + h
+	}
+	return msg
+}
+
+// hint suggests how to make one of the offered challenges solvable.
+func (e *challengeError) hint() string {
+	switch {
+	case contains(e.offered, "dns-01") && !contains(e.solvable, "dns-01"):
+		return "try -dns to solve dns-01 challenge"
+	case contains(e.offered, "http-01") && !contains(e.solvable, "http-01"):
+		return "try without -dns to solve http-01 challenge"
+	}
+	return ""
+}
+
+// resumeAuthz fetches the authorization at uri.
+// It returns nil if uri is empty or the authorization cannot be continued.
+func resumeAuthz(ctx context.Context, client *acme.Client, uri string) *acme.Authorization {
+	if uri == "" {
+		return nil
+	}
+	z, err := client.GetAuthorization(ctx, uri)
+	if err != nil {
+		logf("%s: %v", uri, err)
+		return nil
+	}
+	if z.Status != acme.StatusPending && z.Status != acme.StatusValid {
+		return nil
+	}
+	logf("resuming authorization %s", uri)
+	return z
+}
+
+// authzExpiry returns the expiration time of the valid authorization at uri.
+// The acme package does not expose it, so the authorization is fetched
+// directly; this is an unauthenticated GET request in ACME v1.
+func authzExpiry(ctx context.Context, client *acme.Client, uri string) (time.Time, error) {
+	hc := client.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	res, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("%s: %s", uri, res.Status)
+	}
+	var v struct {
+		Status  string
+		Expires time.Time
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %v", uri, err)
+	}
+	if v.Status != acme.StatusValid || v.Expires.IsZero() {
+		return time.Time{}, fmt.Errorf("%s: status %q, expires %v", uri, v.Status, v.Expires)
+	}
+	return v.Expires, nil
+}
+
+// abandonAuthz deactivates pending authorizations which were created
+// after state was copied to prev, and removes them from the state
+// persisted in account dir.
+// Authorizations already valid are kept so that a later run can reuse them.
+func abandonAuthz(client *acme.Client, dir string, state, prev authzState) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for domain, e := range state {
+		if prev[domain] == e {
+			continue
+		}
+		if z, err := client.GetAuthorization(ctx, e.URI); err == nil && z.Status == acme.StatusValid {
+			continue
+		}
+		if err := client.RevokeAuthorization(ctx, e.URI); err != nil {
+			errorf("%s: deactivate %s: %v", domain, e.URI, err)
+			continue
+		}
+		delete(state, domain)
+	}
+	if err := writeAuthzState(dir, state); err != nil {
+		errorf("write authz state: %v", err)
+	}
+}
+
+// waitEnter blocks until a line is read from the standard input
+// or ctx is done.
+func waitEnter(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		var x string
+		fmt.Scanln(&x)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func challengeFile(domain, content string) (string, error) {
-	f, err := ioutil.TempFile("", domain)
+	f, err := ioutil.TempFile("", challengeFilePrefix+domain+"-")
 	if err != nil {
 		return "", err
 	}
@@ -231,13 +1757,110 @@ func challengeFile(domain, content string) (string, error) {
 	return f.Name(), err
 }
 
-func http01Handler(path, value string) http.Handler {
+func http01Handler(path, value string, st *challengeStats) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != path {
 			log.Printf("unknown request path: %s", r.URL.Path)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if st != nil {
+			st.hit(r.RemoteAddr)
+		}
 		w.Write([]byte(value))
 	})
 }
+
+// certEvent is the outcome of cert command written in -json mode.
+type certEvent struct {
+	Event   string   `json:"event"` // always "cert"
+	Domains []string `json:"domains"`
+	Path    string   `json:"path"`
+	Changed bool     `json:"changed"` // false if up to date or the same
+}
+
+// reportCert writes the outcome of cert command for domains sans
+// as a JSON line to w in -json mode: whether the certificate
+// at path changed.
+func reportCert(w io.Writer, sans []string, path string, changed bool) {
+	if !flagJSON {
+		return
+	}
+	b, _ := json.Marshal(certEvent{Event: "cert", Domains: sans, Path: path, Changed: changed})
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+// challengeStats collects diagnostics of a single challenge:
+// the time it took the CA to validate it and the requests
+// made to the local http-01 responder.
+type challengeStats struct {
+	Event       string   `json:"event"` // always "challenge"
+	Domain      string   `json:"domain"`
+	Type        string   `json:"type"`
+	Status      string   `json:"status"`  // "valid" or the error
+	Seconds     float64  `json:"seconds"` // since the response was in place
+	Requests    int      `json:"requests"`
+	RemoteAddrs []string `json:"remoteAddrs,omitempty"` // unique IP addresses
+
+	// Hits counts requests by remote address, such as each vantage point
+	// of a CA validating from several.
+	Hits map[string]int `json:"hits,omitempty"`
+
+	mu    sync.Mutex // guards Requests, RemoteAddrs and Hits
+	start time.Time
+}
+
+// begin starts timing the challenge once the response is in place.
+// Requests made before, such as by selfCheck, are not counted.
+func (st *challengeStats) begin() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.start = timeNow()
+	st.Requests = 0
+	st.RemoteAddrs = nil
+	st.Hits = nil
+}
+
+// hit records a request to the challenge response from remote address addr.
+func (st *challengeStats) hit(addr string) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Requests++
+	if !contains(st.RemoteAddrs, addr) {
+		st.RemoteAddrs = append(st.RemoteAddrs, addr)
+	}
+	if st.Hits == nil {
+		st.Hits = make(map[string]int)
+	}
+	st.Hits[addr]++
+}
+
+// report logs the challenge outcome err, or writes it as a JSON line
+// to the standard error in -json mode.
+func (st *challengeStats) report(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Event = "challenge"
+	st.Status = acme.StatusValid
+	if err != nil {
+		st.Status = err.Error()
+	}
+	st.Seconds = timeNow().Sub(st.start).Seconds()
+	if flagJSON {
+		b, _ := json.Marshal(st)
+		fmt.Fprintf(os.Stderr, "%s\n", b)
+		return
+	}
+	msg := fmt.Sprintf("%s: %s challenge %s after %.1fs", st.Domain, st.Type, st.Status, st.Seconds)
+	if st.Type == "http-01" && !certManual {
+		hits := make([]string, len(st.RemoteAddrs))
+		for i, a := range st.RemoteAddrs {
+			hits[i] = fmt.Sprintf("%s (%d)", a, st.Hits[a])
+		}
+		msg += fmt.Sprintf("; %d requests from [%s]", st.Requests, strings.Join(hits, ", "))
+	}
+	logf("%s", msg)
+}
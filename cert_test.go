@@ -0,0 +1,1006 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestShouldFallback(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&acme.Error{StatusCode: 500, ProblemType: "urn:acme:error:serverInternal"}, true},
+		{&acme.Error{StatusCode: 429, ProblemType: "urn:acme:error:rateLimited"}, true},
+		{fmt.Errorf("example.com: %w", &acme.Error{StatusCode: 503}), true},
+		{&acme.Error{StatusCode: 403, ProblemType: "urn:acme:error:unauthorized"}, false},
+		{acme.ErrAuthorizationFailed, false},
+		{errors.New("network"), false},
+	}
+	for i, test := range tests {
+		if v := shouldFallback(test.err); v != test.want {
+			t.Errorf("%d: shouldFallback(%v) = %v; want %v", i, test.err, v, test.want)
+		}
+	}
+}
+
+func TestIssueAbandonsAuthzForFallback(t *testing.T) {
+	defer func(m bool, o string) { certManual, certManualOutput = m, o }(certManual, certManualOutput)
+	certManual, certManualOutput = true, "json"
+	var authorized, deactivated int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		base := "http://" + r.Host
+		switch {
+		case r.Method == "HEAD":
+		case r.URL.Path == "/":
+			fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke"}`, base)
+		case r.URL.Path == "/authz" && authorized == 0:
+			authorized++
+			w.Header().Set("Location", base+"/authz/1")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"status":"pending","challenges":[{"type":"http-01","uri":"%s/chal/1","token":"tok"}]}`, base)
+		case r.URL.Path == "/authz":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"type":"urn:acme:error:serverInternal","detail":"down"}`)
+		case r.URL.Path == "/authz/1" && r.Method == "POST":
+			deactivated++
+			fmt.Fprint(w, `{"status":"deactivated"}`)
+		case r.URL.Path == "/authz/1":
+			fmt.Fprint(w, `{"status":"pending"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "acme-fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sans := []string{"a.example.com", "b.example.com"}
+
+	for _, fallback := range [][]string{nil, {"backup"}} {
+		authorized, deactivated = 0, 0
+		if err := writeAuthzState(dir, nil); err != nil {
+			t.Fatal(err)
+		}
+		uc := &userConfig{key: key, dir: dir, Fallback: fallback}
+		_, err := issue(context.Background(), uc, ts.URL, nil, sans)
+		if !shouldFallback(err) {
+			t.Fatalf("fallback %q: issue: %v; want CA-side failure", fallback, err)
+		}
+		state, err := readAuthzState(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, kept := state["a.example.com"]
+		if want := len(fallback) == 0; kept != want || deactivated != len(fallback) {
+			t.Errorf("fallback %q: kept = %v, deactivated %d times", fallback, kept, deactivated)
+		}
+	}
+}
+
+// fakeIssuingCA is an ACME v1 server which authorizes all domains
+// without challenges and issues certificates for all CSRs, except
+// those for domains found in its errors map, which responds to them
+// with the mapped problem type.
+//
+// Domains found in its failures map are authorized with an http-01
+// challenge instead, whose validation fails the mapped number of times
+// before it succeeds. Like some CAs, it resets an invalid authorization
+// to pending when the challenge is accepted again.
+type fakeIssuingCA struct {
+	key      *ecdsa.PrivateKey
+	errors   map[string]string // domain: problem type
+	failures map[string]int    // domain: failed validations
+	accepts  map[string]int    // domain: accepted challenges
+}
+
+func (ca *fakeIssuingCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce")
+	base := "http://" + r.Host
+	if r.Method == "HEAD" {
+		return
+	}
+	if r.Method != "POST" {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke"}`, base)
+		case strings.HasPrefix(r.URL.Path, "/authz/"):
+			fmt.Fprintf(w, `{"status":%q,"expires":%q}`,
+				ca.authzStatus(strings.TrimPrefix(r.URL.Path, "/authz/")), time.Now().Add(time.Hour).Format(time.RFC3339))
+		case strings.HasPrefix(r.URL.Path, "/chal/"):
+			fmt.Fprintf(w, `{"type":"http-01","uri":"%s%s","token":"tok","status":%q}`,
+				base, r.URL.Path, ca.authzStatus(strings.TrimPrefix(r.URL.Path, "/chal/")))
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+	var jws struct{ Payload string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	b, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	var req struct {
+		Identifier struct{ Value string }
+		CSR        string
+	}
+	json.Unmarshal(b, &req)
+	switch r.URL.Path {
+	case "/authz":
+		domain := req.Identifier.Value
+		if typ, ok := ca.errors[domain]; ok {
+			status := http.StatusForbidden
+			if typ == "urn:acme:error:serverInternal" {
+				status = http.StatusInternalServerError
+			}
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"type":%q,"detail":"%s refused"}`, typ, domain)
+			return
+		}
+		w.Header().Set("Location", base+"/authz/"+domain)
+		w.WriteHeader(http.StatusCreated)
+		if _, ok := ca.failures[domain]; ok {
+			fmt.Fprintf(w, `{"status":"pending","identifier":{"type":"dns","value":%q},"challenges":[{"type":"http-01","uri":"%s/chal/%s","token":"tok"}]}`,
+				domain, base, domain)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"valid","identifier":{"type":"dns","value":%q}}`, domain)
+	case "/chal/" + path.Base(r.URL.Path):
+		ca.accepts[path.Base(r.URL.Path)]++
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"type":"http-01","uri":"%s%s","token":"tok","status":"pending"}`, base, r.URL.Path)
+	case "/cert":
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		if der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, csr.PublicKey, ca.key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", base+"/cert/1")
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(der)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authzStatus returns the status of the authorization of domain:
+// valid unless it is in ca.failures, and then pending until its challenge
+// is accepted, and invalid until it has been accepted more times
+// than the failures.
+func (ca *fakeIssuingCA) authzStatus(domain string) string {
+	f, ok := ca.failures[domain]
+	switch n := ca.accepts[domain]; {
+	case !ok:
+		return "valid"
+	case n == 0:
+		return "pending"
+	case n <= f:
+		return "invalid"
+	}
+	return "valid"
+}
+
+func TestAuthzRetry(t *testing.T) {
+	defer func(n int, addr string, d time.Duration) { certRetry, certAddr, certPoll = n, addr, d }(certRetry, certAddr, certPoll)
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	logf = func(string, ...interface{}) {}
+	certAddr, certPoll = "127.0.0.1:0", time.Millisecond
+	ca := &fakeIssuingCA{}
+	ts := httptest.NewServer(ca)
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "acme-retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := &acme.Client{Key: key, DirectoryURL: ts.URL}
+
+	tests := []struct {
+		failures, retry int
+		ok              bool
+		accepts         int
+	}{
+		{failures: 1, retry: 0, ok: false, accepts: 1},
+		// a challenge which fails once, then passes
+		{failures: 1, retry: 1, ok: true, accepts: 2},
+		// -retry stops after the initial attempt and n retries
+		{failures: 5, retry: 2, ok: false, accepts: 3},
+	}
+	for i, test := range tests {
+		ca.failures = map[string]int{"example.com": test.failures}
+		ca.accepts = make(map[string]int)
+		certRetry = test.retry
+		err := authz(context.Background(), client, dir, "example.com", make(authzState))
+		if (err == nil) != test.ok || ca.accepts["example.com"] != test.accepts {
+			t.Errorf("%d: authz: %v, %d accepts; want ok = %v, %d accepts", i, err, ca.accepts["example.com"], test.ok, test.accepts)
+		}
+		if !test.ok && err != acme.ErrAuthorizationFailed {
+			t.Errorf("%d: authz: %v; want %v", i, err, acme.ErrAuthorizationFailed)
+		}
+	}
+}
+
+func TestRunCertBatch(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	defer func(in, out string, b bool) { certCSRDir, certCertDir, certBundle = in, out, b }(certCSRDir, certCertDir, certBundle)
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	defer func() { exitStatus = 0 }()
+	var logs []string
+	logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	dir, err := ioutil.TempDir("", "acme-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = filepath.Join(dir, "config")
+	certCSRDir = filepath.Join(dir, "in")
+	certCertDir = filepath.Join(dir, "out")
+	certBundle = false
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the primary CA refuses fail.example.com and is unavailable
+	// for busy.example.com, which the fallback one issues
+	primary := httptest.NewServer(&fakeIssuingCA{key: key, errors: map[string]string{
+		"fail.example.com": "urn:acme:error:unauthorized",
+		"busy.example.com": "urn:acme:error:serverInternal",
+	}})
+	defer primary.Close()
+	backup := httptest.NewServer(&fakeIssuingCA{key: key, errors: map[string]string{
+		"fail.example.com": "urn:acme:error:unauthorized",
+	}})
+	defer backup.Close()
+	accounts := []struct {
+		ca string
+		uc *userConfig
+	}{
+		{"", &userConfig{Account: acme.Account{URI: primary.URL + "/reg/1"}, CA: primary.URL, Fallback: []string{"backup"}}},
+		{"backup", &userConfig{Account: acme.Account{URI: backup.URL + "/reg/1"}, CA: backup.URL}},
+	}
+	for _, a := range accounts {
+		a.uc.dir = caDir(a.ca)
+		if err := writeConfig(a.uc); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeKey(caKeyPath(a.ca), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(certCSRDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ok", "fail", "busy"} {
+		csr, err := newCSR(key, "", []string{name + ".example.com"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(certCSRDir, name+".csr"), csr, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hooked := filepath.Join(dir, "hooked")
+	if runtime.GOOS != "windows" {
+		defer func(s, f string) { certSuccessHook, certFailureHook = s, f }(certSuccessHook, certFailureHook)
+		certSuccessHook = `echo "ok $RENEWED_DOMAINS $ACME_ATTEMPTS" >> ` + hooked
+		certFailureHook = `echo "failed $FAILED_DOMAINS $ACME_ATTEMPTS" >> ` + hooked
+	}
+
+	runCertBatch(context.Background())
+	if exitStatus != 1 {
+		t.Errorf("exitStatus = %d; want 1", exitStatus)
+	}
+	if runtime.GOOS != "windows" {
+		b, err := ioutil.ReadFile(hooked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		sort.Strings(lines)
+		want := []string{"failed fail.example.com 1", "ok busy.example.com 2", "ok ok.example.com 1"}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("hooks ran for %q; want %q", lines, want)
+		}
+	}
+	for name, issued := range map[string]bool{"ok": true, "busy": true, "fail": false} {
+		leaf, err := readLeaf(filepath.Join(certCertDir, name+".crt"))
+		if !issued {
+			if err == nil {
+				t.Errorf("%s: certificate written", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if want := name + ".example.com"; len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != want {
+			t.Errorf("%s: certificate for %q; want %s", name, leaf.DNSNames, want)
+		}
+	}
+	var fellBack bool
+	for _, l := range logs {
+		fellBack = fellBack || strings.Contains(l, "falling back to backup")
+	}
+	if !fellBack {
+		t.Errorf("no fallback logged: %q", logs)
+	}
+
+	// an interrupted run attempts no more CSRs
+	if err := os.RemoveAll(certCertDir); err != nil {
+		t.Fatal(err)
+	}
+	logs, exitStatus = nil, 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runCertBatch(ctx)
+	if want := []string{"context canceled: 3 CSRs not attempted"}; exitStatus != 1 || !reflect.DeepEqual(logs, want) {
+		t.Errorf("interrupted: exitStatus = %d, logs = %q; want 1, %q", exitStatus, logs, want)
+	}
+	if _, err := readLeaf(filepath.Join(certCertDir, "ok.crt")); err == nil {
+		t.Error("interrupted: certificate written")
+	}
+}
+
+func TestWritePins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "example.com.pins")
+	if err := writePins(path, k1, k2); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(k1.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("len(lines) = %d; want 2", len(lines))
+	}
+	want := fmt.Sprintf("pin-sha256=%q", base64.StdEncoding.EncodeToString(sum[:]))
+	if lines[0] != want {
+		t.Errorf("lines[0] = %s; want %s", lines[0], want)
+	}
+}
+
+func TestPromoteBackupKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "example.com.key")
+	backupPath := filepath.Join(dir, "example.com.backup.key")
+	if _, err := writeOutputKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := writeOutputKey(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := promoteBackupKey(keyPath, backupPath); ok || err != nil {
+		t.Errorf("promoteBackupKey with a key in place: %v, %v", ok, err)
+	}
+
+	// rotation: the certificate key is removed
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := promoteBackupKey(keyPath, backupPath); !ok || err != nil {
+		t.Fatalf("promoteBackupKey: %v, %v", ok, err)
+	}
+	key, err := writeOutputKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Public(), backup.Public()) {
+		t.Error("the certificate key is not the former backup key")
+	}
+	next, err := writeOutputKey(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(next.Public(), backup.Public()) {
+		t.Error("no new backup key generated")
+	}
+	if ok, err := promoteBackupKey(filepath.Join(dir, "other.key"), filepath.Join(dir, "other.backup.key")); ok || err != nil {
+		t.Errorf("promoteBackupKey without a backup: %v, %v", ok, err)
+	}
+}
+
+func TestChallengeError(t *testing.T) {
+	tests := []struct {
+		offered, solvable []string
+		hint              string
+	}{
+		{[]string{"dns-01"}, []string{"http-01"}, "try -dns"},
+		{[]string{"http-01", "tls-sni-01"}, []string{"dns-01"}, "try without -dns"},
+		{[]string{"tls-sni-01"}, []string{"http-01"}, ""},
+	}
+	for _, test := range tests {
+		e := &challengeError{offered: test.offered, solvable: test.solvable}
+		if h := e.hint(); !strings.HasPrefix(h, test.hint) || (test.hint == "" && h != "") {
+			t.Errorf("%v/%v: hint = %q; want %q", test.offered, test.solvable, h, test.hint)
+		}
+		if !strings.Contains(e.Error(), test.offered[0]) {
+			t.Errorf("%v: Error() = %q; want offered types listed", test.offered, e.Error())
+		}
+	}
+}
+
+func TestARICertID(t *testing.T) {
+	// example from RFC 9773, section 4.1
+	c := &certificate{leaf: &x509.Certificate{
+		AuthorityKeyId: []byte{0x69, 0x88, 0x5b, 0x6b, 0x87, 0x46, 0x40, 0x41, 0xe1, 0xb3,
+			0x7b, 0x84, 0x7b, 0xa0, 0xae, 0x2c, 0xde, 0x01, 0xc8, 0xd4},
+		SerialNumber: new(big.Int).SetBytes([]byte{0x00, 0x87, 0x65, 0x43, 0x21}),
+	}}
+	want := "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"
+	if v := c.ariCertID(); v != want {
+		t.Errorf("ariCertID() = %q; want %q", v, want)
+	}
+}
+
+func TestCheckExistingCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { timeNow = time.Now }()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    now,
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "example.com.crt")
+	if err := writeCert(path, &certificate{chain: [][]byte{der}}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		now  time.Time
+		pub  interface{}
+		sans []string
+		ok   bool
+	}{
+		{now, key.Public(), []string{"example.com"}, true},
+		{now.Add(59 * 24 * time.Hour), key.Public(), []string{"example.com"}, true},
+		{now.Add(61 * 24 * time.Hour), key.Public(), []string{"example.com"}, false},
+		{now, other.Public(), []string{"example.com"}, false},
+		{now, key.Public(), []string{"example.com", "www.example.com"}, false},
+	}
+	for i, test := range tests {
+		timeNow = func() time.Time { return test.now }
+		err := checkExistingCert(path, test.pub, test.sans, nil)
+		if (err == nil) != test.ok {
+			t.Errorf("%d: checkExistingCert: %v; want ok = %v", i, err, test.ok)
+		}
+	}
+	if err := checkExistingCert(filepath.Join(dir, "none.crt"), key.Public(), nil, nil); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v; want not exist", err)
+	}
+}
+
+func TestCheckIssuers(t *testing.T) {
+	defer func(s string) { certIssuer = s }(certIssuer)
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now,
+		NotAfter:              now.Add(45 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	ca, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(ca)
+	tests := []struct {
+		now    time.Time
+		issuer string
+		chain  [][]byte
+		ok     bool
+	}{
+		{now, "", nil, true},
+		{now, "", [][]byte{ca}, true},
+		{now.Add(16 * 24 * time.Hour), "", [][]byte{ca}, false}, // CA expires within 30d
+		{now, fmt.Sprintf("%x", sum), [][]byte{ca}, true},
+		{now, fmt.Sprintf("%x,%X", make([]byte, 32), sum[:]), [][]byte{ca}, true},
+		{now, fmt.Sprintf("%x", make([]byte, 32)), [][]byte{ca}, false},
+		{now, fmt.Sprintf("%x", sum), nil, false},
+		{now, "abc", [][]byte{ca}, false},
+	}
+	for i, test := range tests {
+		now, certIssuer = test.now, test.issuer
+		err := checkIssuers(test.chain)
+		if (err == nil) != test.ok {
+			t.Errorf("%d: checkIssuers: %v; want ok = %v", i, err, test.ok)
+		}
+	}
+}
+
+func TestUnbundledChain(t *testing.T) {
+	defer func(c, f string) { certChainOut, certFullchainOut = c, f }(certChainOut, certFullchainOut)
+	dir, err := ioutil.TempDir("", "acme-chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	leaf, ca := []byte("leaf"), []byte("ca")
+	full := filepath.Join(dir, "{{.Domain}}.full.pem")
+	if err := writePEMCerts(filepath.Join(dir, "example.com.full.pem"), [][]byte{leaf, ca}); err != nil {
+		t.Fatal(err)
+	}
+	chain := filepath.Join(dir, "{{.Domain}}.chain.pem")
+	if err := writePEMCerts(filepath.Join(dir, "example.com.chain.pem"), [][]byte{ca}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		chain, full string
+		want        [][]byte
+	}{
+		{"", "", nil},
+		{chain, "", [][]byte{ca}},
+		{"", full, [][]byte{ca}},
+		{filepath.Join(dir, "none.pem"), full, [][]byte{ca}},
+		{"", filepath.Join(dir, "none.pem"), nil},
+	}
+	for i, test := range tests {
+		certChainOut, certFullchainOut = test.chain, test.full
+		if got := unbundledChain("example.com"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: unbundledChain = %q; want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestAuthzExpiry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/valid":
+			fmt.Fprint(w, `{"status":"valid","expires":"2016-02-01T00:00:00Z"}`)
+		case "/pending":
+			fmt.Fprint(w, `{"status":"pending","expires":"2016-02-01T00:00:00Z"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	client := &acme.Client{}
+	exp, err := authzExpiry(context.Background(), client, ts.URL+"/valid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)
+	if !exp.Equal(want) {
+		t.Errorf("authzExpiry = %v; want %v", exp, want)
+	}
+	for _, p := range []string{"/pending", "/missing"} {
+		if _, err := authzExpiry(context.Background(), client, ts.URL+p); err == nil {
+			t.Errorf("%s: no error", p)
+		}
+	}
+
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return want.Add(-time.Second) }
+	if e := (authzEntry{URI: "u", Expires: want}); e.expired() {
+		t.Error("expired() = true before expiration")
+	}
+	timeNow = func() time.Time { return want }
+	if e := (authzEntry{URI: "u", Expires: want}); !e.expired() {
+		t.Error("expired() = false at expiration")
+	}
+	if e := (authzEntry{URI: "u"}); e.expired() {
+		t.Error("expired() = true for a pending authorization")
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	defer func(p string) { certPublic = p }(certPublic)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "example.com" {
+			http.NotFound(w, r)
+			return
+		}
+		http01Handler("/.well-known/acme-challenge/tok", "tok.thumb", nil).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	certPublic = strings.TrimPrefix(ts.URL, "http://")
+
+	ctx := context.Background()
+	if err := selfCheck(ctx, "example.com", "/.well-known/acme-challenge/tok", "tok.thumb"); err != nil {
+		t.Errorf("selfCheck: %v", err)
+	}
+	if err := selfCheck(ctx, "example.com", "/.well-known/acme-challenge/tok", "other"); err == nil {
+		t.Error("selfCheck with a wrong response: no error")
+	}
+	if err := selfCheck(ctx, "example.org", "/.well-known/acme-challenge/tok", "tok.thumb"); err == nil {
+		t.Error("selfCheck with a wrong host: no error")
+	}
+	certPublic = ""
+	if err := selfCheck(ctx, "example.org", "/", "x"); err != nil {
+		t.Errorf("selfCheck without -challenge-public: %v", err)
+	}
+}
+
+func TestChallengeStats(t *testing.T) {
+	st := &challengeStats{Domain: "example.com", Type: "http-01"}
+	h := http01Handler("/tok", "tok.thumb", st)
+	for _, addr := range []string{"192.0.2.1:1234", "192.0.2.1:1235", "[2001:db8::1]:80"} {
+		r := httptest.NewRequest("GET", "/tok", nil)
+		r.RemoteAddr = addr
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+	if st.Requests != 3 {
+		t.Errorf("st.Requests = %d; want 3", st.Requests)
+	}
+	if want := []string{"192.0.2.1", "2001:db8::1"}; !reflect.DeepEqual(st.RemoteAddrs, want) {
+		t.Errorf("st.RemoteAddrs = %v; want %v", st.RemoteAddrs, want)
+	}
+	if want := map[string]int{"192.0.2.1": 2, "2001:db8::1": 1}; !reflect.DeepEqual(st.Hits, want) {
+		t.Errorf("st.Hits = %v; want %v", st.Hits, want)
+	}
+	st.begin()
+	if st.Requests != 0 || st.RemoteAddrs != nil || st.Hits != nil {
+		t.Errorf("after begin: %d requests from %v", st.Requests, st.Hits)
+	}
+}
+
+func TestWaitAuthz(t *testing.T) {
+	defer func(d time.Duration, n int) { certPoll, certPollMax = d, n }(certPoll, certPollMax)
+	defer func(f func(context.Context, time.Duration) error) { timeSleep = f }(timeSleep)
+	var slept []time.Duration
+	timeSleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return ctx.Err()
+	}
+	var polls int
+	status := "pending"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		s := status
+		if polls >= 3 {
+			s = "valid"
+		}
+		fmt.Fprintf(w, `{"status":%q}`, s)
+	}))
+	defer ts.Close()
+	client := &acme.Client{}
+	ctx := context.Background()
+
+	certPoll, certPollMax = time.Minute, 0
+	if err := waitAuthz(ctx, client, ts.URL); err != nil {
+		t.Fatalf("waitAuthz: %v", err)
+	}
+	if polls != 3 {
+		t.Errorf("polls = %d; want 3", polls)
+	}
+	if len(slept) != 2 || slept[0] != time.Minute || slept[1] != time.Minute {
+		t.Errorf("slept = %v; want [1m0s 1m0s]", slept)
+	}
+
+	polls, certPollMax = 0, 2
+	if err := waitAuthz(ctx, client, ts.URL); err == nil {
+		t.Error("waitAuthz with -poll-max=2: no error")
+	}
+	if polls != 2 {
+		t.Errorf("polls = %d; want 2", polls)
+	}
+
+	polls, status = -10, "invalid"
+	if err := waitAuthz(ctx, client, ts.URL); err != acme.ErrAuthorizationFailed {
+		t.Errorf("waitAuthz: %v; want %v", err, acme.ErrAuthorizationFailed)
+	}
+
+	polls, status, certPollMax = 0, "pending", 0
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := waitAuthz(cctx, client, ts.URL); err == nil {
+		t.Error("waitAuthz with cancelled context: no error")
+	}
+}
+
+func TestWithPhaseTimeout(t *testing.T) {
+	block := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	ctx := context.Background()
+	err := withPhaseTimeout(ctx, time.Millisecond, "validation-timeout", block)
+	if err == nil || !strings.Contains(err.Error(), "-validation-timeout") {
+		t.Errorf("phase deadline: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("phase deadline: %v does not wrap %v", err, context.DeadlineExceeded)
+	}
+
+	// the overall deadline is reported as is
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := withPhaseTimeout(cctx, time.Hour, "validation-timeout", block); err != context.DeadlineExceeded {
+		t.Errorf("overall deadline: %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	done := func(context.Context) error { return nil }
+	if err := withPhaseTimeout(ctx, 0, "validation-timeout", done); err != nil {
+		t.Errorf("no limit: %v", err)
+	}
+}
+
+func TestWriteCertBundle(t *testing.T) {
+	defer func(b bool) { certBundle = b }(certBundle)
+	dir, err := ioutil.TempDir("", "acme-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "example.com.crt")
+	c := &certificate{chain: [][]byte{[]byte("leaf"), []byte("ca")}}
+
+	count := func() int {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(b), "BEGIN CERTIFICATE")
+	}
+	certBundle = true
+	if err := writeCert(path, c); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("-bundle=true: %d certificates; want 2", n)
+	}
+	certBundle = false
+	if err := writeCert(path, c); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("-bundle=false: %d certificates; want 1", n)
+	}
+	if err := writePEMCerts(path, c.chain[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("chain: %d certificates; want 1", n)
+	}
+}
+
+func TestWriteCertJKSAlias(t *testing.T) {
+	defer func(f, p string) { certFormat, certPass = f, p }(certFormat, certPass)
+	dir, err := ioutil.TempDir("", "acme-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFormat = "jks"
+	certPass = filepath.Join(dir, "pass")
+	if err := ioutil.WriteFile(certPass, []byte("changeit\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "www.example.com.jks")
+	c := &certificate{
+		chain: [][]byte{[]byte("leaf")},
+		key:   key,
+		sans:  []string{"example.com", "www.example.com"},
+		name:  "www.example.com",
+	}
+	if err := writeCert(path, c); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(b[16:]) // magic, version, count and tag
+	if alias := readUTF(r); alias != "www.example.com" {
+		t.Errorf("alias = %q; want www.example.com", alias)
+	}
+}
+
+func TestAppendRoot(t *testing.T) {
+	newCert := func(cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, key
+	}
+	root, rootKey := newCert("root", nil, nil)
+	other, _ := newCert("other", nil, nil)
+	inter, _ := newCert("inter", root, rootKey)
+
+	dir, err := ioutil.TempDir("", "acme-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rootFile := filepath.Join(dir, "root.pem")
+	if err := ioutil.WriteFile(rootFile, pemCerts([][]byte{root.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Client") == "" {
+			http.Error(w, "not the CA client", http.StatusForbidden)
+			return
+		}
+		w.Write(root.Raw)
+	}))
+	defer ts.Close()
+	// the CA client, as configured with -header
+	hc := &http.Client{Transport: &headerTransport{
+		base:   http.DefaultTransport,
+		header: http.Header{"X-Client": {"acme"}},
+	}}
+
+	ctx := context.Background()
+	chain := [][]byte{[]byte("leaf"), inter.Raw}
+	for _, src := range []string{rootFile, ts.URL} {
+		got, err := appendRoot(ctx, hc, "", chain, src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if len(got) != 3 || !bytes.Equal(got[2], root.Raw) {
+			t.Errorf("%s: root not appended", src)
+		}
+	}
+	if got, err := appendRoot(ctx, hc, "", [][]byte{inter.Raw, root.Raw}, rootFile); err != nil || len(got) != 2 {
+		t.Errorf("root already in chain: len = %d, err = %v", len(got), err)
+	}
+
+	otherFile := filepath.Join(dir, "other.pem")
+	if err := ioutil.WriteFile(otherFile, other.Raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendRoot(ctx, hc, "", chain, otherFile); err == nil {
+		t.Error("unrelated root: no error")
+	}
+	if _, err := appendRoot(ctx, hc, "", chain, filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestReportCert(t *testing.T) {
+	defer func(v bool) { flagJSON = v }(flagJSON)
+	var buf bytes.Buffer
+	flagJSON = false
+	reportCert(&buf, []string{"example.com"}, "/etc/ssl/example.com.crt", true)
+	if buf.Len() != 0 {
+		t.Errorf("text mode output: %q", buf.String())
+	}
+	flagJSON = true
+	reportCert(&buf, []string{"example.com", "www.example.com"}, "/etc/ssl/example.com.crt", false)
+	want := `{"event":"cert","domains":["example.com","www.example.com"],"path":"/etc/ssl/example.com.crt","changed":false}` + "\n"
+	if buf.String() != want {
+		t.Errorf("reportCert = %s; want %s", buf.String(), want)
+	}
+}
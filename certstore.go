@@ -0,0 +1,71 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// importCertStore imports certificate c, its key and CA chain into
+// the Windows local machine certificate store named store, with certutil.
+// certutil reads them from a PFX file, which is written to the config dir
+// encrypted with a random one-time password and removed after the import.
+func importCertStore(store string, c *certificate) error {
+	if c.key == nil {
+		return errors.New("the certificate key is required")
+	}
+	p := make([]byte, 16)
+	if _, err := rand.Read(p); err != nil {
+		return err
+	}
+	// the password is visible in the process list,
+	// but only for as long as the file exists
+	pass := hex.EncodeToString(p)
+	pfx, err := encodePKCS12(c.name, c.key, c.chain, pass)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(configDir, "import-*.pfx")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(pfx)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// -f replaces a certificate already in the store
+	return runCertutil(exec.Command("certutil", "-f", "-p", pass, "-importPFX", store, f.Name()))
+}
+
+// runCertutil runs cmd, including its output in the error:
+// certutil reports failures on stdout. It is replaced in tests.
+var runCertutil = func(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	return nil
+}
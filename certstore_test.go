@@ -0,0 +1,105 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportCertStore(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	defer func(f func(*exec.Cmd) error) { runCertutil = f }(runCertutil)
+	dir, err := ioutil.TempDir("", "acme-certstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = dir
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &certificate{name: "example.com", key: key, chain: [][]byte{[]byte("leaf"), []byte("ca")}}
+	var args []string
+	runCertutil = func(cmd *exec.Cmd) error {
+		args = cmd.Args
+		if len(args) != 7 {
+			return errors.New("unexpected arguments")
+		}
+		b, err := ioutil.ReadFile(args[6])
+		if err != nil {
+			return err
+		}
+		// the file is protected with the password passed to certutil
+		var pfx pfxPDU
+		if _, err := asn1.Unmarshal(b, &pfx); err != nil {
+			return err
+		}
+		var authSafe []byte
+		if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, pkcs12MACKey(args[3], pfx.MacData.MacSalt, pfx.MacData.Iterations))
+		mac.Write(authSafe)
+		if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+			return errors.New("MAC mismatch")
+		}
+		return nil
+	}
+	if err := importCertStore("My", c); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"certutil", "-f", "-p", "", "-importPFX", "My"}
+	for i, a := range want {
+		if a != "" && args[i] != a {
+			t.Errorf("args[%d] = %q; want %q", i, args[i], a)
+		}
+	}
+	if len(args[3]) != 32 {
+		t.Errorf("password = %q; want 32 hex digits", args[3])
+	}
+	if filepath.Dir(args[6]) != dir || !strings.HasSuffix(args[6], ".pfx") {
+		t.Errorf("file = %q; want a .pfx file in %s", args[6], dir)
+	}
+	if _, err := os.Stat(args[6]); !os.IsNotExist(err) {
+		t.Errorf("%s is not removed: %v", args[6], err)
+	}
+
+	runCertutil = func(cmd *exec.Cmd) error {
+		args = cmd.Args
+		return errors.New("access denied")
+	}
+	if err := importCertStore("My", c); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("err = %v; want access denied", err)
+	}
+	if _, err := os.Stat(args[6]); !os.IsNotExist(err) {
+		t.Errorf("%s is not removed after failure: %v", args[6], err)
+	}
+
+	c.key = nil
+	if err := importCertStore("My", c); err == nil {
+		t.Error("no error without the certificate key")
+	}
+}
@@ -0,0 +1,706 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// newClient creates an ACME client for the CA directory disco,
+// signing requests with key.
+//
+// The disco argument may be followed by a "#" and a hex encoded SHA-256
+// fingerprint of the CA root certificate, in which case the client trusts
+// only the TLS certificate chains issued by that certificate.
+func newClient(key crypto.Signer, disco string) (*acme.Client, error) {
+	dirURL, fp, err := splitDisco(disco)
+	if err != nil {
+		return nil, err
+	}
+	c := &acme.Client{
+		Key:          key,
+		DirectoryURL: dirURL,
+	}
+	proxy, err := proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = proxy
+	u, err := url.Parse(dirURL)
+	if err != nil {
+		return nil, err
+	}
+	if fp != nil {
+		base.TLSClientConfig = pinnedTLSConfig(u.Host, fp, proxy)
+	}
+	var t http.RoundTripper = &headerTransport{
+		base:   &linkTransport{base: &bufferTransport{base: &decodeTransport{base: base}}},
+		header: http.Header{"User-Agent": {clientUserAgent()}},
+		host:   u.Host,
+		extra:  http.Header(flagHeader),
+	}
+	if flagTrace {
+		t = &traceTransport{base: t}
+	}
+	if flagQPS > 0 {
+		t = &limitTransport{
+			base:   t,
+			bucket: qpsBucket(),
+		}
+	}
+	c.HTTPClient = &http.Client{Transport: t}
+	return c, nil
+}
+
+// discover fetches the CA directory of c, verifying it is a valid ACME v1
+// one with validateDirectory. A directory of RFC 8555, also known
+// as ACME v2, is reported with a distinct error, rather than as missing
+// endpoints.
+func discover(ctx context.Context, c *acme.Client) (acme.Directory, error) {
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return dir, err
+	}
+	if dir.RegURL != "" {
+		return dir, validateDirectory(c.DirectoryURL, dir)
+	}
+	// the acme package ignores decoding errors and unknown endpoints
+	req, err := http.NewRequest("GET", c.DirectoryURL, nil)
+	if err != nil {
+		return dir, err
+	}
+	res, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return dir, err
+	}
+	defer res.Body.Close()
+	var v map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return dir, fmt.Errorf("%s: invalid directory: %v", c.DirectoryURL, err)
+	}
+	if _, ok := v["newAccount"]; ok {
+		return dir, fmt.Errorf("%s is an ACME v2 (RFC 8555) directory; only ACME v1 is supported", c.DirectoryURL)
+	}
+	return dir, validateDirectory(c.DirectoryURL, dir)
+}
+
+// discoverCA is like discover, but also returns the chainFingerprint
+// of the TLS certificate chain the CA directory is served with,
+// or an empty string if it is not served over TLS.
+func discoverCA(ctx context.Context, c *acme.Client) (string, error) {
+	var fp string
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if tc, ok := info.Conn.(*tls.Conn); ok {
+				fp = chainFingerprint(tc.ConnectionState().PeerCertificates)
+			}
+		},
+	}
+	_, err := discover(httptrace.WithClientTrace(ctx, trace), c)
+	return fp, err
+}
+
+// chainFingerprint returns hex encoded SHA-256 digest of the certificates
+// of a TLS chain above the server certificate, which change less often
+// than the latter, or of the server certificate if it is the only one.
+func chainFingerprint(chain []*x509.Certificate) string {
+	if len(chain) == 0 {
+		return ""
+	}
+	if len(chain) > 1 {
+		chain = chain[1:]
+	}
+	h := sha256.New()
+	for _, c := range chain {
+		h.Write(c.Raw)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkCA compares the CA directory disco, as in -d, and fingerprint fp
+// of its TLS certificate chain with those recorded in account uc,
+// warning if either changed: a mistyped -d or an intercepted connection.
+// The directory and the fingerprint are recorded in uc the first time
+// they are seen; a fingerprint only if the directory is the recorded one.
+func checkCA(uc *userConfig, disco, fp string) error {
+	url, _, _ := splitDisco(disco)
+	recorded, _, _ := splitDisco(uc.CA)
+	var changed bool
+	switch {
+	case recorded == "":
+		uc.CA, recorded = disco, url
+		changed = true
+	case url != recorded:
+		logf("warning: CA directory %s is not %s of the account; check -d value", url, recorded)
+	}
+	switch {
+	case fp == "":
+		// plain HTTP
+	case uc.CAFingerprint == "":
+		if url == recorded {
+			uc.CAFingerprint = fp
+			changed = true
+		}
+	case fp != uc.CAFingerprint:
+		logf("warning: TLS certificate chain of CA directory %s changed from %s to %s; "+
+			"the connection may be intercepted. If the CA changed its certificates, "+
+			"record the new ones with acme update -trust-ca", url, uc.CAFingerprint, fp)
+	}
+	if !changed {
+		return nil
+	}
+	return writeConfig(uc)
+}
+
+// validateDirectory verifies that dir, fetched from dirURL, lists
+// all ACME v1 endpoints as absolute URLs with the scheme and host
+// of the directory. The error lists all problems found.
+func validateDirectory(dirURL string, dir acme.Directory) error {
+	return validateEndpoints(dirURL, []endpoint{
+		{"new-reg", dir.RegURL},
+		{"new-authz", dir.AuthzURL},
+		{"new-cert", dir.CertURL},
+		{"revoke-cert", dir.RevokeURL},
+	})
+}
+
+// endpoint is a named URL listed in a CA directory.
+type endpoint struct{ name, v string }
+
+// validateEndpoints is like validateDirectory, but verifies
+// the given endpoints.
+func validateEndpoints(dirURL string, endpoints []endpoint) error {
+	base, err := url.Parse(dirURL)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, e := range endpoints {
+		if e.v == "" {
+			problems = append(problems, "missing "+e.name)
+			continue
+		}
+		u, err := url.Parse(e.v)
+		switch {
+		case err != nil || !u.IsAbs():
+			problems = append(problems, fmt.Sprintf("%s %q is not an absolute URL", e.name, e.v))
+		case u.Scheme != base.Scheme || !strings.EqualFold(u.Host, base.Host):
+			problems = append(problems, fmt.Sprintf("%s %q is not at %s://%s", e.name, e.v, base.Scheme, base.Host))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: invalid directory: %s", dirURL, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// flagProxy is the URL of an HTTP proxy to connect to a CA through,
+// instead of the one specified with HTTPS_PROXY and HTTP_PROXY environment
+// variables. It may include basic auth credentials, user:password@;
+// the password may be also given with ACME_PROXY_PASSWORD environment variable.
+// Hosts listed in NO_PROXY environment variable are connected to directly.
+// It is set with -proxy flag, common to all subcommands.
+var flagProxy string
+
+// proxyFunc returns the proxy selection function of CA requests.
+func proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if flagProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(flagProxy)
+	if err != nil {
+		return nil, fmt.Errorf("-proxy: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("-proxy: %q is not an http(s) URL", flagProxy)
+	}
+	if pass := os.Getenv("ACME_PROXY_PASSWORD"); pass != "" && u.User != nil {
+		u.User = url.UserPassword(u.User.Username(), pass)
+	}
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if skipProxy(req.URL.Host, noProxy) {
+			return nil, nil
+		}
+		return u, nil
+	}, nil
+}
+
+// skipProxy reports whether host, in host[:port] form, matches
+// a NO_PROXY list: comma separated domain names, which match the domain
+// and its subdomains, or subdomains only with a leading dot, IP addresses
+// and CIDR ranges, each optionally with a port, or "*" for all hosts.
+func skipProxy(host, noProxy string) bool {
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+	hostname = strings.ToLower(hostname)
+	ip := net.ParseIP(hostname)
+	for _, e := range strings.Split(noProxy, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if e == "*" {
+			return true
+		}
+		if _, n, err := net.ParseCIDR(e); err == nil {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(e); err == nil {
+			if p != port {
+				continue
+			}
+			e = h
+		}
+		if e == hostname {
+			return true
+		}
+		if ip == nil && strings.HasSuffix(hostname, "."+strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// userAgent identifies the tool in requests to a CA.
+// Release builds append the version.
+var userAgent = "google-acme"
+
+// flagUserAgent is appended to userAgent, e.g. to identify an organization
+// to a private CA. It is set with -ua flag, common to all subcommands,
+// and defaults to ACME_USER_AGENT environment variable.
+var flagUserAgent = os.Getenv("ACME_USER_AGENT")
+
+// clientUserAgent returns the User-Agent header value of CA requests.
+func clientUserAgent() string {
+	if flagUserAgent == "" {
+		return userAgent
+	}
+	return userAgent + " " + flagUserAgent
+}
+
+// flagHeader holds additional header fields of CA requests, such as
+// credentials of an API gateway in front of the CA. It is set with
+// -header flag, common to all subcommands.
+var flagHeader = make(headerFlag)
+
+// reservedHeaders are header fields which -header cannot set,
+// because the protocol or other flags define them.
+var reservedHeaders = []string{"Host", "Content-Type", "Content-Length", "User-Agent"}
+
+// headerFlag is a repeatable flag which collects "Name: value" header fields.
+type headerFlag http.Header
+
+func (f headerFlag) String() string {
+	var s []string
+	for k, vv := range f {
+		for _, v := range vv {
+			s = append(s, k+": "+v)
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+func (f headerFlag) Set(v string) error {
+	i := strings.IndexByte(v, ':')
+	if i <= 0 {
+		return fmt.Errorf("%q: want Name: value", v)
+	}
+	k := http.CanonicalHeaderKey(strings.TrimSpace(v[:i]))
+	if strings.ContainsAny(k, " \t") {
+		return fmt.Errorf("%q: invalid header name", k)
+	}
+	if contains(reservedHeaders, k) {
+		return fmt.Errorf("%s header cannot be set", k)
+	}
+	http.Header(f).Add(k, strings.TrimSpace(v[i+1:]))
+	return nil
+}
+
+// headerTransport is an http.RoundTripper which sets header fields
+// of every request, replacing existing values. The extra fields, which
+// may be credentials, are only set on requests to host, the CA directory
+// one, and not on those to other hosts such as issuer URLs or redirect
+// targets.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+	host   string
+	extra  http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the request
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	if strings.EqualFold(req.URL.Host, t.host) {
+		for k, v := range t.extra {
+			req.Header[k] = v
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// decodeTransport is an http.RoundTripper which decodes response bodies
+// compressed with gzip or deflate Content-Encoding, which some CDN fronted
+// CAs send regardless of the request. The http.Transport decodes only gzip
+// responses to requests it compressed itself.
+type decodeTransport struct {
+	base http.RoundTripper
+}
+
+func (t *decodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	enc := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	var r io.Reader
+	switch enc {
+	case "", "identity":
+		return res, nil
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, fmt.Errorf("%s: gzip response: %v", req.URL, err)
+		}
+		r = gr
+	case "deflate":
+		// RFC 7230 deflate is zlib framed, but some servers send raw deflate
+		br := bufio.NewReader(res.Body)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint(h[0])<<8|uint(h[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				res.Body.Close()
+				return nil, fmt.Errorf("%s: deflate response: %v", req.URL, err)
+			}
+			r = zr
+		} else {
+			r = flate.NewReader(br)
+		}
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unsupported response Content-Encoding %q", req.URL, enc)
+	}
+	res.Body = &decodedBody{Reader: r, body: res.Body}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// decodedBody reads a decoded response body, closing the original one.
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
+
+// maxResponseSize limits the size of CA responses read by bufferTransport.
+// It is larger than the largest certificate the acme package accepts.
+const maxResponseSize = 2 << 20
+
+// bufferTransport is an http.RoundTripper which reads response bodies
+// of unknown length, such as chunked or decoded ones, into memory,
+// so that ContentLength is the actual number of bytes. The acme package
+// relies on it to tell a pending certificate, with an empty body,
+// from an issued one.
+type bufferTransport struct {
+	base http.RoundTripper
+}
+
+func (t *bufferTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || res.ContentLength >= 0 {
+		return res, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: response body: %v", req.URL, err)
+	}
+	if len(b) > maxResponseSize {
+		return nil, fmt.Errorf("%s: response body exceeds %d bytes", req.URL, maxResponseSize)
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	res.ContentLength = int64(len(b))
+	res.TransferEncoding = nil
+	return res, nil
+}
+
+// linkTransport is an http.RoundTripper which rewrites response Link
+// header fields, parsed with parseLinks, into one field per link and
+// relation type, with the target resolved against the request URL.
+// The acme package splits the fields on semicolons and takes a single
+// relation type, so this is the form it reads correctly. Targets
+// containing a semicolon are still misread.
+type linkTransport struct {
+	base http.RoundTripper
+}
+
+func (t *linkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || len(res.Header["Link"]) == 0 {
+		return res, err
+	}
+	links, _ := parseLinks(res.Header["Link"])
+	var vs []string
+	for _, l := range links {
+		target := l.target
+		if u, err := req.URL.Parse(target); err == nil {
+			target = u.String()
+		}
+		for _, rel := range l.rel {
+			vs = append(vs, fmt.Sprintf("<%s>;rel=%q", target, rel))
+		}
+	}
+	if len(vs) == 0 {
+		res.Header.Del("Link")
+	} else {
+		res.Header["Link"] = vs
+	}
+	return res, nil
+}
+
+// flagQPS limits the rate of requests sent to a CA, per second.
+// It is set with -qps flag, common to all subcommands.
+// Zero or negative value means no limit.
+var flagQPS float64
+
+// qpsLimiter is the token bucket of -qps limit, shared by all clients
+// of the process, such as those of batch, -pack and fallback issuance.
+var qpsLimiter struct {
+	sync.Mutex
+	bucket *tokenBucket
+}
+
+// qpsBucket returns the token bucket of flagQPS rate.
+func qpsBucket() *tokenBucket {
+	qpsLimiter.Lock()
+	defer qpsLimiter.Unlock()
+	if b := qpsLimiter.bucket; b == nil || b.rate != flagQPS {
+		qpsLimiter.bucket = newTokenBucket(flagQPS, 1)
+	}
+	return qpsLimiter.bucket
+}
+
+// limitTransport is an http.RoundTripper which delays requests
+// to keep their rate within the limit of a token bucket.
+type limitTransport struct {
+	base   http.RoundTripper // http.DefaultTransport if nil
+	bucket *tokenBucket
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if d := t.bucket.take(); d > 0 {
+		if err := timeSleep(req.Context(), d); err != nil {
+			return nil, err
+		}
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// tokenBucket is a rate limiter which allows rate events per second
+// on average, with bursts of up to burst events.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64   // may be negative when tokens are reserved ahead
+	last   time.Time // when tokens was updated
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// take reserves a token and returns how long the caller must wait
+// before using it.
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := timeNow()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// splitDisco splits v of "url#fingerprint" form into the directory URL
+// and decoded root certificate fingerprint.
+// The fingerprint is nil if v does not contain one.
+func splitDisco(v string) (url string, fp []byte, err error) {
+	i := strings.LastIndexByte(v, '#')
+	if i < 0 {
+		return v, nil, nil
+	}
+	fp, err = hex.DecodeString(strings.Replace(v[i+1:], ":", "", -1))
+	if err != nil || len(fp) != sha256.Size {
+		return "", nil, fmt.Errorf("%q: invalid SHA-256 root fingerprint", v[i+1:])
+	}
+	return v[:i], fp, nil
+}
+
+// pinnedTLSConfig returns a TLS config which verifies server certificates
+// against the root certificate with SHA-256 fingerprint fp,
+// instead of the system roots.
+// TLS servers usually leave the root out of the chain they present,
+// so unless it is there, the root is fetched from the /root/<fingerprint>
+// endpoint of host, as served by step-ca, and used only if its fingerprint
+// matches fp.
+func pinnedTLSConfig(host string, fp []byte, proxy func(*http.Request) (*url.URL, error)) *tls.Config {
+	r := &pinnedRoot{host: host, fp: fp, proxy: proxy}
+	return &tls.Config{
+		// The default verification is replaced by VerifyConnection.
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verify,
+	}
+}
+
+// pinnedRoot verifies TLS connections against a root certificate
+// identified by its fingerprint.
+type pinnedRoot struct {
+	host  string
+	fp    []byte
+	proxy func(*http.Request) (*url.URL, error)
+
+	mu    sync.Mutex
+	roots *x509.CertPool // the fetched root, once found
+}
+
+func (r *pinnedRoot) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificates")
+	}
+	inter := x509.NewCertPool()
+	var roots *x509.CertPool
+	for _, c := range cs.PeerCertificates {
+		sum := sha256.Sum256(c.Raw)
+		if bytes.Equal(sum[:], r.fp) {
+			roots = x509.NewCertPool()
+			roots.AddCert(c)
+		} else {
+			inter.AddCert(c)
+		}
+	}
+	if roots == nil {
+		var err error
+		if roots, err = r.fetch(); err != nil {
+			return err
+		}
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: inter,
+	})
+	return err
+}
+
+// fetch returns a pool of the root certificate fetched from the
+// /root/<fingerprint> endpoint of r.host. The connection is not verified:
+// the root is trusted only because its fingerprint is r.fp.
+func (r *pinnedRoot) fetch() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.roots != nil {
+		return r.roots, nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = r.proxy
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	hc := &http.Client{Transport: t, Timeout: 30 * time.Second}
+	u := fmt.Sprintf("https://%s/root/%x", r.host, r.fp)
+	res, err := hc.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("root certificate %x not in the server chain: %v", r.fp, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("root certificate %x not in the server chain, and %s: %s", r.fp, u, res.Status)
+	}
+	var v struct {
+		CA string `json:"ca"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	b, _ := pem.Decode([]byte(v.CA))
+	if b == nil || b.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", u)
+	}
+	if sum := sha256.Sum256(b.Bytes); !bytes.Equal(sum[:], r.fp) {
+		return nil, fmt.Errorf("%s: root certificate fingerprint is %x", u, sum)
+	}
+	c, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	r.roots = x509.NewCertPool()
+	r.roots.AddCert(c)
+	return r.roots, nil
+}
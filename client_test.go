@@ -0,0 +1,640 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestSplitDisco(t *testing.T) {
+	url, fp, err := splitDisco("https://ca/dir")
+	if err != nil || url != "https://ca/dir" || fp != nil {
+		t.Errorf("splitDisco: %q, %x, %v", url, fp, err)
+	}
+	sum := sha256.Sum256([]byte("root"))
+	url, fp, err = splitDisco(fmt.Sprintf("https://ca/dir#%x", sum))
+	if err != nil || url != "https://ca/dir" || string(fp) != string(sum[:]) {
+		t.Errorf("splitDisco: %q, %x, %v", url, fp, err)
+	}
+	if _, _, err := splitDisco("https://ca/dir#abc"); err == nil {
+		t.Error("splitDisco: short fingerprint: no error")
+	}
+}
+
+func TestNewClientPinned(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(ts.Certificate().Raw)
+	c, err := newClient(key, fmt.Sprintf("%s#%x", ts.URL, sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.HTTPClient.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("pinned root: %v", err)
+	}
+	res.Body.Close()
+
+	sum = sha256.Sum256([]byte("other"))
+	if c, err = newClient(key, fmt.Sprintf("%s#%x", ts.URL, sum)); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := c.HTTPClient.Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Error("other root: no error")
+	}
+}
+
+func TestNewClientPinnedFetchRoot(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, leafTmpl, root, key.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(rootDER)
+	var fetched int
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == fmt.Sprintf("/root/%x", sum) {
+			fetched++
+			p := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER})
+			json.NewEncoder(w).Encode(map[string]string{"ca": string(p)})
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	// the server chain does not include the root, as with step-ca
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf}, PrivateKey: key}}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	c, err := newClient(key, fmt.Sprintf("%s#%x", ts.URL, sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := c.HTTPClient.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("fetched root: %v", err)
+		}
+		res.Body.Close()
+		c.HTTPClient.CloseIdleConnections()
+	}
+	if fetched != 1 {
+		t.Errorf("root fetched %d times; want 1", fetched)
+	}
+
+	other := sha256.Sum256([]byte("other"))
+	if c, err = newClient(key, fmt.Sprintf("%s#%x", ts.URL, other)); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := c.HTTPClient.Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Error("other root: no error")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	b := newTokenBucket(2, 1) // 2 per second
+	steps := []struct {
+		advance time.Duration
+		want    time.Duration
+	}{
+		{0, 0},                                // initial token
+		{0, 500 * time.Millisecond},           // next one in 1/2s
+		{0, time.Second},                      // and the one after
+		{time.Second, 500 * time.Millisecond}, // debt paid off partially
+		{10 * time.Second, 0},                 // refilled up to the burst
+		{100 * time.Millisecond, 400 * time.Millisecond}, // burst of 1
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		if d := b.take(); d != s.want {
+			t.Errorf("%d: take() = %v; want %v", i, d, s.want)
+		}
+	}
+}
+
+func TestLimitTransport(t *testing.T) {
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	defer func(f func(context.Context, time.Duration) error) { timeSleep = f }(timeSleep)
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	var slept time.Duration
+	timeSleep = func(ctx context.Context, d time.Duration) error {
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c := &http.Client{Transport: &limitTransport{bucket: newTokenBucket(4, 1)}}
+	for i := 0; i < 5; i++ {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if want := time.Second; slept != want {
+		t.Errorf("slept %v; want %v", slept, want)
+	}
+}
+
+func TestNewClientSharedLimit(t *testing.T) {
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	defer func(f func(context.Context, time.Duration) error) { timeSleep = f }(timeSleep)
+	defer func(qps float64) { flagQPS = qps }(flagQPS)
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	var slept time.Duration
+	timeSleep = func(ctx context.Context, d time.Duration) error {
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	flagQPS = 4
+	var clients []*acme.Client
+	for i := 0; i < 2; i++ {
+		c, err := newClient(nil, ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	for i := 0; i < 5; i++ {
+		res, err := clients[i%2].HTTPClient.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if want := time.Second; slept != want {
+		t.Errorf("slept %v; want %v", slept, want)
+	}
+}
+
+func TestNewClientUserAgent(t *testing.T) {
+	defer func(ua string) { flagUserAgent = ua }(flagUserAgent)
+	flagUserAgent = "team-a"
+	var ua string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua = r.UserAgent()
+	}))
+	defer ts.Close()
+	c, err := newClient(nil, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.HTTPClient.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want := userAgent + " team-a"; ua != want {
+		t.Errorf("User-Agent = %q; want %q", ua, want)
+	}
+}
+
+func TestNewClientHeader(t *testing.T) {
+	defer func(h headerFlag) { flagHeader = h }(flagHeader)
+	flagHeader = make(headerFlag)
+	for _, v := range []string{"X-Api-Key: k1", "x-trace: a", "X-Trace:b"} {
+		if err := flagHeader.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	for _, v := range []string{"no colon", ": empty", "Bad Name: x", "user-agent: x", "Content-Type: text/plain"} {
+		if err := flagHeader.Set(v); err == nil {
+			t.Errorf("Set(%q): no error", v)
+		}
+	}
+	var h http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h = r.Header
+	}))
+	defer ts.Close()
+	c, err := newClient(nil, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.HTTPClient.Post(ts.URL, "application/jose+json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if v := h.Get("X-Api-Key"); v != "k1" {
+		t.Errorf("X-Api-Key = %q; want k1", v)
+	}
+	if v := h["X-Trace"]; len(v) != 2 || v[0] != "a" || v[1] != "b" {
+		t.Errorf("X-Trace = %q; want [a b]", v)
+	}
+	if v := h.Get("Content-Type"); v != "application/jose+json" {
+		t.Errorf("Content-Type = %q", v)
+	}
+	if v := h.Get("User-Agent"); v != clientUserAgent() {
+		t.Errorf("User-Agent = %q", v)
+	}
+}
+
+func TestNewClientHeaderHost(t *testing.T) {
+	defer func(h headerFlag) { flagHeader = h }(flagHeader)
+	flagHeader = make(headerFlag)
+	if err := flagHeader.Set("Authorization: Bearer gateway"); err != nil {
+		t.Fatal(err)
+	}
+	var other []string
+	ots := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = append(other, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer ots.Close()
+	var ca []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ca = append(ca, r.URL.Path+" "+r.Header.Get("Authorization"))
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, ots.URL+"/moved", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+	c, err := newClient(nil, ts.URL+"/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, u := range []string{ts.URL + "/directory", ts.URL + "/moved", ots.URL + "/root.pem"} {
+		if _, err := fetchURL(ctx, c.HTTPClient, u); err != nil {
+			t.Fatalf("%s: %v", u, err)
+		}
+	}
+	if want := []string{"/directory Bearer gateway", "/moved Bearer gateway"}; fmt.Sprint(ca) != fmt.Sprint(want) {
+		t.Errorf("CA requests: %q; want %q", ca, want)
+	}
+	if want := []string{"/moved ", "/root.pem "}; fmt.Sprint(other) != fmt.Sprint(want) {
+		t.Errorf("other host requests: %q; want %q", other, want)
+	}
+}
+
+func TestSkipProxy(t *testing.T) {
+	const noProxy = "localhost, .internal,example.org:8443,10.0.0.0/8,192.0.2.1"
+	tests := []struct {
+		host string
+		skip bool
+	}{
+		{"localhost", true},
+		{"localhost:443", true},
+		{"ca.internal", true},
+		{"internal", false}, // leading dot matches subdomains only
+		{"ca.internal.example.com", false},
+		{"example.org:8443", true},
+		{"example.org:443", false},
+		{"www.example.org:8443", true},
+		{"10.1.2.3:443", true},
+		{"11.1.2.3", false},
+		{"192.0.2.1", true},
+		{"acme-v01.api.letsencrypt.org:443", false},
+	}
+	for _, test := range tests {
+		if skip := skipProxy(test.host, noProxy); skip != test.skip {
+			t.Errorf("skipProxy(%q) = %v; want %v", test.host, skip, test.skip)
+		}
+	}
+	if !skipProxy("example.com", "*") {
+		t.Error("skipProxy with * = false")
+	}
+}
+
+func TestNewClientProxy(t *testing.T) {
+	defer func(p string) { flagProxy = p }(flagProxy)
+	var auth, target string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Proxy-Authorization")
+		target = r.URL.String()
+		fmt.Fprint(w, "{}")
+	}))
+	defer proxy.Close()
+
+	flagProxy = strings.Replace(proxy.URL, "http://", "http://user:secret@", 1)
+	c, err := newClient(nil, "http://ca.example.com/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.HTTPClient.Get("http://ca.example.com/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if target != "http://ca.example.com/directory" {
+		t.Errorf("proxied request URL = %q", target)
+	}
+	if want := "Basic dXNlcjpzZWNyZXQ="; auth != want {
+		t.Errorf("Proxy-Authorization = %q; want %q", auth, want)
+	}
+
+	flagProxy = "ftp://proxy"
+	if _, err := newClient(nil, "http://ca.example.com/directory"); err == nil {
+		t.Error("newClient with ftp proxy: no error")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	var dir string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, dir, "http://"+r.Host)
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+	for _, test := range []struct {
+		dir  string
+		want string // error substring
+	}{
+		{`{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke"}`, ""},
+		{`{"newNonce":"%[1]s/nonce","newAccount":"%[1]s/acct"}`, "ACME v2"},
+		{`{"keyChange":"%[1]s/key"}`, "missing new-reg; missing new-authz; missing new-cert; missing revoke-cert"},
+		{`{"new-reg":"%[1]s/reg","new-authz":"/authz","new-cert":"https://other/cert"}`,
+			`new-authz "/authz" is not an absolute URL; new-cert "https://other/cert" is not at http://`},
+		{`{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert"}`, "missing revoke-cert"},
+		{`<html>`, "invalid directory"},
+	} {
+		dir = test.dir
+		c, err := newClient(nil, ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = discover(ctx, c)
+		if test.want == "" && err != nil || test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%s: discover: %v; want %q", test.dir, err, test.want)
+		}
+	}
+}
+
+func TestDiscoverCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke"}`, "https://"+r.Host)
+	}))
+	defer ts.Close()
+	sum := sha256.Sum256(ts.Certificate().Raw)
+	disco := fmt.Sprintf("%s#%x", ts.URL, sum)
+	c, err := newClient(nil, disco)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := discoverCA(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test server presents only its own certificate
+	if want := fmt.Sprintf("%x", sum); fp != want {
+		t.Errorf("fp = %s; want %s", fp, want)
+	}
+}
+
+func TestCheckCA(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	var logs []string
+	logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	dir, err := ioutil.TempDir("", "acme-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = dir
+
+	uc := &userConfig{CA: "https://ca/directory"}
+	if err := checkCA(uc, "https://ca/directory", "aa"); err != nil {
+		t.Fatal(err)
+	}
+	if uc.CAFingerprint != "aa" || len(logs) != 0 {
+		t.Errorf("first fingerprint: recorded %q; logs %q", uc.CAFingerprint, logs)
+	}
+	if uc, err = readConfig(); err != nil || uc.CAFingerprint != "aa" {
+		t.Fatalf("readConfig: %v, %+v", err, uc)
+	}
+	if err := checkCA(uc, "https://ca/directory#"+strings.Repeat("00", sha256.Size), "aa"); err != nil || len(logs) != 0 {
+		t.Errorf("same fingerprint: %v; logs %q", err, logs)
+	}
+	checkCA(uc, "https://ca/directory", "bb")
+	if len(logs) != 1 || !strings.Contains(logs[0], "changed from aa to bb") || uc.CAFingerprint != "aa" {
+		t.Errorf("changed fingerprint: recorded %q; logs %q", uc.CAFingerprint, logs)
+	}
+	logs = nil
+	checkCA(uc, "https://ca.typo/directory", "cc")
+	if len(logs) != 2 || !strings.Contains(logs[0], "https://ca.typo/directory is not https://ca/directory") ||
+		!strings.Contains(logs[1], "changed from aa to cc") {
+		t.Errorf("other CA: logs %q", logs)
+	}
+
+	// an account without recorded CA
+	logs = nil
+	uc = &userConfig{}
+	if err := checkCA(uc, "https://ca/directory", "aa"); err != nil {
+		t.Fatal(err)
+	}
+	if uc.CA != "https://ca/directory" || uc.CAFingerprint != "aa" || len(logs) != 0 {
+		t.Errorf("first use: recorded %q, %q; logs %q", uc.CA, uc.CAFingerprint, logs)
+	}
+	if uc, err = readConfig(); err != nil || uc.CA != "https://ca/directory" {
+		t.Fatalf("readConfig: %v, %+v", err, uc)
+	}
+	uc.CAFingerprint = ""
+	checkCA(uc, "https://ca.typo/directory", "cc")
+	if uc.CAFingerprint != "" {
+		t.Errorf("other CA: recorded fingerprint %q", uc.CAFingerprint)
+	}
+}
+
+func TestDecodeTransport(t *testing.T) {
+	const body = `{"type":"urn:acme:error:malformed","detail":"compressed"}`
+	compress := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"raw-deflate": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := r.URL.Query().Get("enc")
+		w.Header().Set("Content-Type", "application/problem+json")
+		if enc == "br" {
+			w.Header().Set("Content-Encoding", enc)
+			return
+		}
+		w.Header().Set("Content-Encoding", strings.TrimPrefix(enc, "raw-"))
+		w.WriteHeader(http.StatusNotFound)
+		cw := compress[enc](w)
+		io.WriteString(cw, body)
+		cw.Close()
+	}))
+	defer ts.Close()
+	c, err := newClient(nil, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for enc := range compress {
+		_, err := c.FetchCert(context.Background(), ts.URL+"?enc="+enc, false)
+		var e *acme.Error
+		if !errors.As(err, &e) || e.ProblemType != "urn:acme:error:malformed" || e.Detail != "compressed" {
+			t.Errorf("%s: FetchCert: %v; want compressed problem", enc, err)
+		}
+	}
+	if _, err := c.FetchCert(context.Background(), ts.URL+"?enc=br", false); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("br: FetchCert: %v; want unsupported encoding", err)
+	}
+}
+
+func TestBufferTransportPendingCert(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch {
+		case r.Method == "HEAD":
+		case r.URL.Path == "/":
+			fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke"}`, "http://"+r.Host)
+		case r.URL.Path == "/cert":
+			// issuance pending: chunked response with no body
+			w.Header().Set("Location", "http://"+r.Host+"/cert/1")
+			w.WriteHeader(http.StatusCreated)
+			w.(http.Flusher).Flush()
+		case r.URL.Path == "/cert/1":
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, "der")
+		case r.URL.Path == "/big":
+			w.(http.Flusher).Flush()
+			w.Write(make([]byte, maxResponseSize+1))
+		}
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newClient(key, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cert, curl, err := c.CreateCert(ctx, []byte("csr"), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert) != 1 || string(cert[0]) != "der" || curl != ts.URL+"/cert/1" {
+		t.Errorf("CreateCert = %q, %q; want der from %s/cert/1", cert, curl, ts.URL)
+	}
+	if res, err := c.HTTPClient.Get(ts.URL + "/big"); err == nil {
+		res.Body.Close()
+		t.Error("oversized response: no error")
+	}
+}
+
+func TestLinkTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cert/1":
+			// a single field with two links, a relative target
+			// and quoted parameters before rel
+			w.Header().Set("Link", `</issuer>; title="CA; 1"; rel="up", <https://ca/terms>;rel=terms-of-service`)
+			fmt.Fprint(w, "der")
+		case "/issuer":
+			fmt.Fprint(w, "issuer")
+		}
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newClient(key, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := c.FetchCert(context.Background(), ts.URL+"/cert/1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert) != 2 || string(cert[0]) != "der" || string(cert[1]) != "issuer" {
+		t.Errorf("FetchCert = %q; want der and issuer", cert)
+	}
+}
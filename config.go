@@ -14,11 +14,12 @@ package main
 import (
 	"crypto"
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -27,6 +28,7 @@ import (
 	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"golang.org/x/crypto/acme"
 )
@@ -36,9 +38,21 @@ const (
 	accountFile = "account.json"
 	// accountKey is the default user account private key file.
 	accountKey = "account.key"
+	// authzFile keeps authorizations of unfinished cert requests
+	// and valid authorizations for reuse.
+	authzFile = "authz.json"
+	// pendingFile keeps cert command runs awaiting continue command.
+	pendingFile = "pending.json"
+	// auditFile is an append-only log of account changes.
+	auditFile = "audit.log"
 
-	rsaPrivateKey = "RSA PRIVATE KEY"
-	ecPrivateKey  = "EC PRIVATE KEY"
+	// configSchemaVersion is the current version of accountFile format.
+	// See acme help migrate.
+	configSchemaVersion = 1
+
+	rsaPrivateKey   = "RSA PRIVATE KEY"
+	ecPrivateKey    = "EC PRIVATE KEY"
+	pkcs8PrivateKey = "PRIVATE KEY"
 )
 
 // configDir is acme configuration dir.
@@ -48,6 +62,77 @@ const (
 // using -c flag, common to all subcommands.
 var configDir string
 
+// flagCA selects one of multiple CA accounts kept in configDir.
+// It is set with -ca flag, common to all subcommands.
+// If empty, the account files are located directly in configDir.
+var flagCA string
+
+// flagTenant selects an isolated configuration of a tenant, such as
+// a customer of a hosting provider, kept in configDir/tenants/<name>.
+// It is set with -tenant flag, common to all subcommands, and defaults
+// to ACME_TENANT environment variable.
+var flagTenant = os.Getenv("ACME_TENANT")
+
+// useTenant moves configDir to the directory of flagTenant, if set.
+// Accounts, keys, certificates and settings of other tenants, as well
+// as those directly in configDir, are not used.
+func useTenant() error {
+	if flagTenant == "" {
+		return nil
+	}
+	if !validDirName(flagTenant) {
+		return fmt.Errorf("-tenant: invalid name %q", flagTenant)
+	}
+	configDir = filepath.Join(configDir, "tenants", flagTenant)
+	return nil
+}
+
+// validDirName reports whether name consists of ASCII letters, digits,
+// dots, dashes and underscores, and is a directory name.
+// Tenant and CA names must be valid so they cannot escape configDir.
+func validDirName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("._-", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// accountDir returns the directory containing account files
+// of the CA selected with -ca flag.
+func accountDir() string {
+	return caDir(flagCA)
+}
+
+// caDir returns the directory containing account files
+// of the CA named ca, as in -ca flag.
+func caDir(ca string) string {
+	if ca == "" {
+		return configDir
+	}
+	return filepath.Join(configDir, "ca", ca)
+}
+
+// accountKeyPath returns the account key file location.
+// A key in accountDir takes precedence over the one in configDir,
+// which may be shared by accounts with different CAs.
+func accountKeyPath() string {
+	return caKeyPath(flagCA)
+}
+
+// caKeyPath is like accountKeyPath for the CA named ca.
+func caKeyPath(ca string) string {
+	p := filepath.Join(caDir(ca), accountKey)
+	if _, err := os.Stat(p); err == nil || ca == "" {
+		return p
+	}
+	return filepath.Join(configDir, accountKey)
+}
+
 func init() {
 	configDir = os.Getenv("ACME_CONFIG")
 	if configDir != "" {
@@ -63,8 +148,27 @@ type userConfig struct {
 	acme.Account
 	CA string `json:"ca"` // CA discovery URL
 
+	// SchemaVersion is the format version of the file, configSchemaVersion
+	// when written. Files without it are version 0.
+	SchemaVersion int `json:"schemaVersion"`
+
+	// Fallback is an ordered list of CA account names, as in -ca flag,
+	// to obtain a certificate from if the CA fails to issue one.
+	Fallback []string `json:"fallback,omitempty"`
+
+	// Keyring indicates the account key is kept in the OS keyring
+	// instead of a file.
+	Keyring bool `json:"keyring,omitempty"`
+
+	// CAFingerprint is the chainFingerprint of the CA directory TLS
+	// certificate chain, recorded to detect its unexpected changes.
+	CAFingerprint string `json:"caFingerprint,omitempty"`
+
 	// key is stored separately
 	key crypto.Signer
+	// dir is the account dir the config was read from,
+	// or empty for accountDir
+	dir string
 }
 
 // readConfig reads userConfig from path and a private key.
@@ -72,17 +176,43 @@ type userConfig struct {
 // by replacing path extention with ".key".
 //func readConfig(name string) (*userConfig, error) {
 func readConfig() (*userConfig, error) {
-	b, err := ioutil.ReadFile(filepath.Join(configDir, accountFile))
+	return readCAConfig(flagCA)
+}
+
+// readCAConfig is like readConfig for the account of the CA named ca,
+// as in -ca flag.
+func readCAConfig(ca string) (*userConfig, error) {
+	dir := caDir(ca)
+	b, err := ioutil.ReadFile(filepath.Join(dir, accountFile))
 	if err != nil {
 		return nil, err
 	}
-	uc := &userConfig{}
+	uc := &userConfig{dir: dir}
 	if err := json.Unmarshal(b, uc); err != nil {
 		return nil, err
 	}
-	if key, err := readKey(filepath.Join(configDir, accountKey)); err == nil {
+	if uc.SchemaVersion > configSchemaVersion {
+		return nil, fmt.Errorf("%s: schema version %d is newer than supported %d; upgrade acme",
+			accountFile, uc.SchemaVersion, configSchemaVersion)
+	}
+	if uc.Keyring {
+		if uc.key, err = readKeyring(dir); err != nil {
+			return nil, err
+		}
+	} else if key, err := readKey(caKeyPath(ca)); err == nil {
 		uc.key = key
 	}
+	if uc.key != nil {
+		if err := checkFIPSKey(uc.key.Public()); err != nil {
+			return nil, fmt.Errorf("account key: %v", err)
+		}
+		if err := checkAccountKey(uc.key.Public()); err != nil {
+			return nil, fmt.Errorf("account key: %v", err)
+		}
+		if err := checkKeyStrength(uc.key.Public()); err != nil {
+			logf("warning: account key: %v; register a new account with acme reg -gen", err)
+		}
+	}
 	return uc, nil
 }
 
@@ -91,14 +221,90 @@ func readConfig() (*userConfig, error) {
 // This function does not store uc.key.
 //func writeConfig(path string, uc *userConfig) error {
 func writeConfig(uc *userConfig) error {
+	uc.SchemaVersion = configSchemaVersion
 	b, err := json.MarshalIndent(uc, "", "  ")
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(configDir, 0700); err != nil {
+	dir := uc.accountDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filepath.Join(configDir, accountFile), b, 0600)
+	return ioutil.WriteFile(filepath.Join(dir, accountFile), b, 0600)
+}
+
+// accountDir returns the directory of uc account files.
+func (uc *userConfig) accountDir() string {
+	if uc.dir != "" {
+		return uc.dir
+	}
+	return accountDir()
+}
+
+// authzState maps domain names to the authorizations obtained
+// by cert commands: pending ones of a run which has not completed yet,
+// and valid ones until they expire.
+// It allows a subsequent run to resume the authorization flow
+// or skip it entirely, instead of starting a new one.
+type authzState map[string]authzEntry
+
+// authzEntry is an authorization recorded in authzState.
+type authzEntry struct {
+	URI string `json:"uri"`
+	// Expires is the expiration time of a valid authorization.
+	// It is zero for pending ones.
+	Expires time.Time `json:"expires"`
+	// Challenge is the URI of the challenge awaiting continue command.
+	Challenge string `json:"challenge,omitempty"`
+}
+
+// UnmarshalJSON also accepts a bare authorization URI,
+// as stored by previous versions.
+func (e *authzEntry) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &e.URI)
+	}
+	type entry authzEntry // without UnmarshalJSON method
+	return json.Unmarshal(b, (*entry)(e))
+}
+
+// expired reports whether e is a valid authorization which has expired.
+func (e authzEntry) expired() bool {
+	return !e.Expires.IsZero() && !timeNow().Before(e.Expires)
+}
+
+// readAuthzState reads authzState from account dir.
+// A missing file results in an empty state and no error.
+func readAuthzState(dir string) (authzState, error) {
+	s := make(authzState)
+	b, err := ioutil.ReadFile(filepath.Join(dir, authzFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(b, &s)
+}
+
+// writeAuthzState stores s in account dir.
+// The file is removed if s is empty.
+func writeAuthzState(dir string, s authzState) error {
+	path := filepath.Join(dir, authzFile)
+	if len(s) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
 }
 
 // readKey reads a private rsa key from path.
@@ -108,32 +314,70 @@ func readKey(path string) (crypto.Signer, error) {
 	if err != nil {
 		return nil, err
 	}
+	k, err := parseKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return k, nil
+}
+
+// parseKey parses a PEM encoded private rsa, ecdsa or ed25519 key.
+func parseKey(b []byte) (crypto.Signer, error) {
 	d, _ := pem.Decode(b)
 	if d == nil {
-		return nil, fmt.Errorf("no block found in %q", path)
+		return nil, errors.New("no PEM block found")
 	}
 	switch d.Type {
 	case rsaPrivateKey:
 		return x509.ParsePKCS1PrivateKey(d.Bytes)
 	case ecPrivateKey:
 		return x509.ParseECPrivateKey(d.Bytes)
+	case pkcs8PrivateKey:
+		k, err := x509.ParsePKCS8PrivateKey(d.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := k.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case *ecdsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+		return nil, fmt.Errorf("%T in %q is unsupported", k, d.Type)
 	default:
 		return nil, fmt.Errorf("%q is unsupported", d.Type)
 	}
 }
 
-// writeKey writes k to the specified path in PEM format.
+// writeKey writes k, an ecdsa, rsa or ed25519 key, to the specified path
+// in PEM format. Ed25519 keys are written in PKCS #8 form.
 // If file does not exists, it will be created with 0600 mod.
-func writeKey(path string, k *ecdsa.PrivateKey) error {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+func writeKey(path string, k crypto.Signer) error {
+	var b *pem.Block
+	switch k := k.(type) {
+	case *ecdsa.PrivateKey:
+		bytes, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return err
+		}
+		b = &pem.Block{Type: ecPrivateKey, Bytes: bytes}
+	case *rsa.PrivateKey:
+		b = &pem.Block{Type: rsaPrivateKey, Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case ed25519.PrivateKey:
+		bytes, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return err
+		}
+		b = &pem.Block{Type: pkcs8PrivateKey, Bytes: bytes}
+	default:
+		return fmt.Errorf("unsupported key type %T", k)
 	}
-	bytes, err := x509.MarshalECPrivateKey(k)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	b := &pem.Block{Type: ecPrivateKey, Bytes: bytes}
 	if err := pem.Encode(f, b); err != nil {
 		f.Close()
 		return err
@@ -141,7 +385,8 @@ func writeKey(path string, k *ecdsa.PrivateKey) error {
 	return f.Close()
 }
 
-// anyKey reads the key from file or generates a new one if gen == true.
+// anyKey reads the key from file or generates a new one of -key-type
+// if gen == true.
 // It returns an error if filename exists but cannot be read.
 // A newly generated key is also stored to filename.
 func anyKey(filename string, gen bool) (crypto.Signer, error) {
@@ -152,11 +397,38 @@ func anyKey(filename string, gen bool) (crypto.Signer, error) {
 	if !os.IsNotExist(err) || !gen {
 		return nil, err
 	}
-	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	k, err = generateKey(keyType)
 	if err != nil {
 		return nil, err
 	}
-	return ecKey, writeKey(filename, ecKey)
+	return k, writeKey(filename, k)
+}
+
+// accountKeyName describes where the account key of uc is kept,
+// for display purposes.
+func accountKeyName(uc *userConfig) string {
+	if uc.Keyring {
+		return "OS keyring"
+	}
+	return accountKeyPath()
+}
+
+// writeAudit appends a timestamped line to the auditFile of the account,
+// prefixed with -log-id value, if any.
+func writeAudit(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if flagLogID != "" {
+		msg = "[" + flagLogID + "] " + msg
+	}
+	f, err := os.OpenFile(filepath.Join(accountDir(), auditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s\n", timeNow().UTC().Format(time.RFC3339), msg)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
 }
 
 // sameDir returns filename path placing it in the same dir as existing file.
@@ -178,6 +450,5 @@ func printAccount(w io.Writer, a *acme.Account, kp string) {
 		agreed = "yes"
 	}
 	fmt.Fprintln(tw, "Accepted:\t", agreed)
-	// TODO: print authorization and certificates
 	tw.Flush()
 }
@@ -13,8 +13,11 @@ package main
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/acme"
 )
@@ -35,6 +38,7 @@ func TestConfigReadWrite(t *testing.T) {
 			Authorizations: "https://authorizations",
 			Certificates:   "https://certificates",
 		},
+		dir: dir,
 	}
 	if err := writeConfig(write); err != nil {
 		t.Fatal(err)
@@ -47,3 +51,140 @@ func TestConfigReadWrite(t *testing.T) {
 		t.Errorf("read: %+v\nwant: %+v", read, write)
 	}
 }
+
+func TestAuthzStateReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDir = dir
+	s, err := readAuthzState(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 0 {
+		t.Errorf("len(s) = %d; want 0", len(s))
+	}
+	write := authzState{
+		"example.com":     {URI: "https://authz/1"},
+		"www.example.com": {URI: "https://authz/2", Expires: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if err := writeAuthzState(configDir, write); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readAuthzState(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, write) {
+		t.Errorf("read: %v\nwant: %v", read, write)
+	}
+
+	// previous versions stored bare URIs
+	old := []byte(`{"example.com": "https://authz/1"}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, authzFile), old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	read, err = readAuthzState(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (authzState{"example.com": {URI: "https://authz/1"}}); !reflect.DeepEqual(read, want) {
+		t.Errorf("read: %v\nwant: %v", read, want)
+	}
+
+	if err := writeAuthzState(configDir, authzState{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, authzFile)); !os.IsNotExist(err) {
+		t.Errorf("stat %s: %v; want not exist", authzFile, err)
+	}
+}
+
+func TestAccountKeyPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDir = dir
+	defer func() { flagCA = "" }()
+
+	shared := filepath.Join(dir, accountKey)
+	if p := accountKeyPath(); p != shared {
+		t.Errorf("accountKeyPath() = %q; want %q", p, shared)
+	}
+	flagCA = "staging"
+	if p := accountKeyPath(); p != shared {
+		t.Errorf("accountKeyPath() = %q; want shared %q", p, shared)
+	}
+	own := filepath.Join(dir, "ca", "staging", accountKey)
+	if err := os.MkdirAll(filepath.Dir(own), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(own, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if p := accountKeyPath(); p != own {
+		t.Errorf("accountKeyPath() = %q; want %q", p, own)
+	}
+}
+
+func TestReadCAConfig(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	dir, err := ioutil.TempDir("", "acme-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = dir
+
+	// a fallback account, written and read without -ca
+	uc := &userConfig{Account: acme.Account{URI: "https://ca/reg/1"}, dir: caDir("backup")}
+	if err := writeConfig(uc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ca", "backup", accountFile)); err != nil {
+		t.Errorf("account of backup CA: %v", err)
+	}
+	if _, err := readConfig(); !os.IsNotExist(err) {
+		t.Errorf("readConfig: %v; want not exist", err)
+	}
+	read, err := readCAConfig("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.URI != uc.URI || read.accountDir() != caDir("backup") {
+		t.Errorf("readCAConfig = %+v; want %+v", read, uc)
+	}
+}
+
+func TestUseTenant(t *testing.T) {
+	defer func(dir, tenant string) { configDir, flagTenant = dir, tenant }(configDir, flagTenant)
+	configDir, flagTenant = "/etc/acme", ""
+	if err := useTenant(); err != nil || configDir != "/etc/acme" {
+		t.Errorf("no tenant: configDir = %q, %v", configDir, err)
+	}
+	flagTenant = "customer-1.example"
+	if err := useTenant(); err != nil || configDir != filepath.Join("/etc/acme", "tenants", "customer-1.example") {
+		t.Errorf("tenant: configDir = %q, %v", configDir, err)
+	}
+	for _, name := range []string{"..", ".", "a/b", `a\b`, "a b"} {
+		configDir, flagTenant = "/etc/acme", name
+		if err := useTenant(); err == nil {
+			t.Errorf("useTenant(%q): no error; configDir = %q", name, configDir)
+		}
+	}
+}
+
+func TestValidDirName(t *testing.T) {
+	for _, name := range []string{"letsencrypt", "staging-2", "ca_1.example"} {
+		if !validDirName(name) {
+			t.Errorf("validDirName(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../x", "a/b", `a\b`, "/etc"} {
+		if validDirName(name) {
+			t.Errorf("validDirName(%q) = true", name)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	cmdConformance = &command{
+		run:       runConformance,
+		UsageLine: "conformance [-d url]",
+		Short:     "check a CA server against the ACME spec",
+		Long: `
+Conformance exercises the CA server at the discovery URL specified with
+-d argument and prints a report of the checks which passed and failed.
+The default value is {{.DefaultDisco}}.
+
+The checks cover directory parsing, Replay-Nonce behavior and the format
+of error responses. They do not need an account: requests are signed
+with a throwaway key and expected to be rejected. No account,
+authorization or certificate is created.
+
+Both ACME v1 and RFC 8555 (ACME v2) directories are checked, the latter
+as served by Pebble and step-ca. For RFC 8555, nonces come from the
+newNonce endpoint, errors are provoked with a newAccount request with
+onlyReturnExisting, and a POST-as-GET request signed for an unknown
+account is expected to be rejected. POST-as-GET is defined by RFC 8555
+only, and the order lifecycle needs an account; they are reported
+as skipped.
+
+The command exits with non-zero status if any check fails.
+
+See also: acme help disco.
+		`,
+	}
+
+	conformanceDisco discoAliasFlag // defaults to defaultDiscoFlag
+)
+
+func init() {
+	cmdConformance.flag.Var(&conformanceDisco, "d", "")
+}
+
+// errSkip is returned by conformance checks which are not applicable.
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+// conformance holds the state shared by conformance checks.
+type conformance struct {
+	client *http.Client
+	url    string            // directory URL
+	dir    map[string]string // endpoints by directory key, set by checkDirectory
+	v2     bool              // dir is an RFC 8555 directory
+	key    *ecdsa.PrivateKey // throwaway, unregistered key
+}
+
+// nonceRE matches valid nonce values: unpadded base64url.
+var nonceRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// conformanceChecks are run in order. A check may rely on the state
+// established by the preceding ones.
+var conformanceChecks = []struct {
+	name string
+	run  func(c *conformance, ctx context.Context) (string, error)
+}{
+	{"directory", (*conformance).checkDirectory},
+	{"nonce", (*conformance).checkNonce},
+	{"nonce-unique", (*conformance).checkNonceUnique},
+	{"bad-nonce", (*conformance).checkBadNonce},
+	{"error-format", (*conformance).checkErrorFormat},
+	{"post-as-get", (*conformance).checkPostAsGet},
+	{"order-lifecycle", func(c *conformance, _ context.Context) (string, error) {
+		if c.v2 {
+			return "", errSkip("needs an account, which is not created")
+		}
+		return "", errSkip("ACME v2 only; v1 uses new-authz and new-cert")
+	}},
+}
+
+func runConformance(args []string) {
+	if len(args) != 0 {
+		fatalf("conformance: unexpected arguments %q", args)
+	}
+	if conformanceDisco == "" {
+		conformanceDisco = defaultDiscoFlag
+	}
+	client, err := newClient(nil, string(conformanceDisco))
+	if err != nil {
+		fatalf("%s: %v", conformanceDisco, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	ctx, cancel = interruptContext(ctx)
+	defer cancel()
+
+	res, err := checkConformance(ctx, client.HTTPClient, client.DirectoryURL)
+	if err != nil {
+		fatalf("conformance: %v", err)
+	}
+	printChecks(os.Stdout, res)
+}
+
+// checkResult is the outcome of a single check of conformance
+// or doctor command.
+type checkResult struct {
+	name   string
+	status string // "ok", "WARN", "FAIL" or "skip"
+	detail string
+}
+
+// printChecks writes a table of results to w.
+// It sets a non-zero exit status if any check failed.
+func printChecks(w io.Writer, res []checkResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, r := range res {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.name, r.status, r.detail)
+		if r.status == "FAIL" {
+			setExitStatus(1)
+		}
+	}
+	tw.Flush()
+}
+
+// checkConformance runs conformanceChecks against the CA directory dirURL.
+// Checks following a failed directory check are skipped.
+func checkConformance(ctx context.Context, client *http.Client, dirURL string) ([]checkResult, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c := &conformance{client: client, url: dirURL, key: key}
+	var res []checkResult
+	for _, check := range conformanceChecks {
+		r := checkResult{name: check.name, status: "ok"}
+		var detail string
+		var err error
+		if c.dir == nil && check.name != "directory" {
+			err = errSkip("no directory")
+		} else {
+			detail, err = check.run(c, ctx)
+		}
+		var skip errSkip
+		switch {
+		case errors.As(err, &skip):
+			r.status, detail = "skip", skip.Error()
+		case err != nil:
+			r.status, detail = "FAIL", err.Error()
+		}
+		r.detail = detail
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// checkDirectory verifies the directory is a JSON object listing
+// absolute URLs of the ACME v1 endpoints, or of the RFC 8555 ones
+// if it has newAccount.
+func (c *conformance) checkDirectory(ctx context.Context) (string, error) {
+	res, err := c.do(ctx, "GET", c.url, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %s", res.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mt != "application/json" {
+		return "", fmt.Errorf("Content-Type %q; want application/json", mt)
+	}
+	var v map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return "", fmt.Errorf("decode: %v", err)
+	}
+	keys := []string{"new-reg", "new-authz", "new-cert", "revoke-cert"}
+	termsKey := "terms-of-service"
+	_, v2 := v["newAccount"]
+	if v2 {
+		keys = []string{"newNonce", "newAccount", "newOrder", "revokeCert", "keyChange"}
+		termsKey = "termsOfService"
+	}
+	dir := make(map[string]string)
+	var endpoints []endpoint
+	for _, k := range keys {
+		var s string
+		if v[k] != nil {
+			if err := json.Unmarshal(v[k], &s); err != nil {
+				return "", fmt.Errorf("%s: %v", k, err)
+			}
+		}
+		dir[k] = s
+		endpoints = append(endpoints, endpoint{k, s})
+	}
+	if err := validateEndpoints(c.url, endpoints); err != nil {
+		return "", err
+	}
+	c.dir, c.v2 = dir, v2
+	var meta map[string]interface{}
+	if m, ok := v["meta"]; ok {
+		if err := json.Unmarshal(m, &meta); err != nil {
+			return "", fmt.Errorf("meta: %v", err)
+		}
+	}
+	var notes []string
+	if v2 {
+		notes = append(notes, "ACME v2 (RFC 8555)")
+	}
+	if terms, _ := meta[termsKey].(string); terms == "" {
+		notes = append(notes, "no "+termsKey+" in meta")
+	}
+	return strings.Join(notes, "; "), nil
+}
+
+// checkNonce verifies a HEAD request of the directory returns a nonce.
+func (c *conformance) checkNonce(ctx context.Context) (string, error) {
+	n, err := c.nonce(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !nonceRE.MatchString(n) {
+		return "", fmt.Errorf("Replay-Nonce %q is not base64url", n)
+	}
+	if !c.v2 {
+		return "", nil
+	}
+	// RFC 8555 also allows GET of newNonce, with no content
+	res, err := c.do(ctx, "GET", c.dir["newNonce"], nil)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return "", fmt.Errorf("GET newNonce: status %s; want 204 No Content", res.Status)
+	}
+	if res.Header.Get("Replay-Nonce") == "" {
+		return "", errors.New("GET newNonce: no Replay-Nonce")
+	}
+	return "", nil
+}
+
+// checkNonceUnique verifies consecutive nonces differ.
+func (c *conformance) checkNonceUnique(ctx context.Context) (string, error) {
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		n, err := c.nonce(ctx)
+		if err != nil {
+			return "", err
+		}
+		if seen[n] {
+			return "", fmt.Errorf("Replay-Nonce %q returned twice", n)
+		}
+		seen[n] = true
+	}
+	return "", nil
+}
+
+// checkBadNonce verifies a request with a nonce not issued by the server
+// is rejected with badNonce error, along with a fresh nonce to retry with.
+func (c *conformance) checkBadNonce(ctx context.Context) (string, error) {
+	u, payload := c.dir["new-reg"], `{"resource":"new-reg"}`
+	if c.v2 {
+		u, payload = c.dir["newAccount"], `{"onlyReturnExisting":true}`
+	}
+	res, err := c.post(ctx, u, "bm90LWlzc3VlZA", "", payload)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	p, err := c.readProblem(res)
+	if err != nil {
+		return "", err
+	}
+	if want := c.problemNS() + "badNonce"; p.Type != want {
+		return "", fmt.Errorf("problem type %q; want %s", p.Type, want)
+	}
+	if res.Header.Get("Replay-Nonce") == "" {
+		return "", errors.New("no Replay-Nonce in badNonce response")
+	}
+	return "", nil
+}
+
+// checkErrorFormat verifies a request signed with an unregistered key
+// is rejected with a problem document. For RFC 8555, it is a newAccount
+// request with onlyReturnExisting, which must be rejected with
+// accountDoesNotExist error.
+func (c *conformance) checkErrorFormat(ctx context.Context) (string, error) {
+	n, err := c.nonce(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, payload := c.dir["new-authz"], `{"resource":"new-authz","identifier":{"type":"dns","value":"example.com"}}`
+	if c.v2 {
+		u, payload = c.dir["newAccount"], `{"onlyReturnExisting":true}`
+	}
+	res, err := c.post(ctx, u, n, "", payload)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	p, err := c.readProblem(res)
+	if err != nil {
+		return "", err
+	}
+	if want := c.problemNS() + "accountDoesNotExist"; c.v2 && p.Type != want {
+		return "", fmt.Errorf("problem type %q; want %s", p.Type, want)
+	}
+	return p.Type, nil
+}
+
+// checkPostAsGet verifies a POST-as-GET request, with an empty payload,
+// signed for an unknown account is rejected with a problem document.
+func (c *conformance) checkPostAsGet(ctx context.Context) (string, error) {
+	if !c.v2 {
+		return "", errSkip("ACME v2 only")
+	}
+	n, err := c.nonce(ctx)
+	if err != nil {
+		return "", err
+	}
+	kid := strings.TrimSuffix(c.dir["newAccount"], "/") + "/conformance-unknown"
+	res, err := c.post(ctx, c.dir["newOrder"], n, kid, "")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	p, err := c.readProblem(res)
+	if err != nil {
+		return "", err
+	}
+	return p.Type, nil
+}
+
+// problemNS returns the namespace of problem types of the directory.
+func (c *conformance) problemNS() string {
+	if c.v2 {
+		return "urn:ietf:params:acme:error:"
+	}
+	return "urn:acme:error:"
+}
+
+// nonce fetches a fresh nonce with a HEAD request of the directory,
+// or of the newNonce endpoint of an RFC 8555 directory.
+func (c *conformance) nonce(ctx context.Context) (string, error) {
+	u := c.url
+	if c.v2 {
+		u = c.dir["newNonce"]
+	}
+	res, err := c.do(ctx, "HEAD", u, nil)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	n := res.Header.Get("Replay-Nonce")
+	if n == "" {
+		return "", fmt.Errorf("HEAD %s: no Replay-Nonce", u)
+	}
+	return n, nil
+}
+
+// post sends payload signed with c.key and nonce to u.
+// For an RFC 8555 directory, the JWS also includes u and,
+// if not empty, kid instead of the public key.
+func (c *conformance) post(ctx context.Context, u, nonce, kid, payload string) (*http.Response, error) {
+	var hu string
+	if c.v2 {
+		hu = u
+	}
+	b, err := jwsSign(c.key, nonce, hu, kid, payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, "POST", u, strings.NewReader(string(b)))
+}
+
+func (c *conformance) do(ctx context.Context, method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/jose+json")
+	}
+	return c.client.Do(req.WithContext(ctx))
+}
+
+// conformanceProblem is a problem document returned by a CA.
+type conformanceProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+// readProblem decodes the body of res as an error response, verifying
+// its status code, Content-Type and required fields.
+func (c *conformance) readProblem(res *http.Response) (*conformanceProblem, error) {
+	if res.StatusCode < 400 || res.StatusCode > 499 {
+		return nil, fmt.Errorf("status %s; want 4xx", res.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mt != "application/problem+json" {
+		return nil, fmt.Errorf("Content-Type %q; want application/problem+json", mt)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	p := &conformanceProblem{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("decode problem: %v", err)
+	}
+	if ns := c.problemNS(); !strings.HasPrefix(p.Type, ns) {
+		return nil, fmt.Errorf("problem type %q is not in %s namespace", p.Type, ns)
+	}
+	if p.Detail == "" {
+		return nil, fmt.Errorf("problem %s has no detail", p.Type)
+	}
+	if p.Status != 0 && p.Status != res.StatusCode {
+		return nil, fmt.Errorf("problem status %d differs from response status %d", p.Status, res.StatusCode)
+	}
+	return p, nil
+}
+
+// jwsSign returns payload signed with key as a flattened JWS,
+// with the nonce and the public key in the protected header,
+// or kid instead of the key if not empty. The url header
+// of RFC 8555 is included if u is not empty.
+func jwsSign(key *ecdsa.PrivateKey, nonce, u, kid, payload string) ([]byte, error) {
+	enc := base64.RawURLEncoding
+	pad := func(b []byte) []byte {
+		return append(make([]byte, 32-len(b)), b...)
+	}
+	hdr := struct {
+		Alg   string          `json:"alg"`
+		JWK   json.RawMessage `json:"jwk,omitempty"`
+		KID   string          `json:"kid,omitempty"`
+		Nonce string          `json:"nonce"`
+		URL   string          `json:"url,omitempty"`
+	}{Alg: "ES256", KID: kid, Nonce: nonce, URL: u}
+	if kid == "" {
+		hdr.JWK = json.RawMessage(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":%q,"y":%q}`,
+			enc.EncodeToString(pad(key.X.Bytes())), enc.EncodeToString(pad(key.Y.Bytes()))))
+	}
+	hb, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	prot := enc.EncodeToString(hb)
+	pl := enc.EncodeToString([]byte(payload))
+	h := sha256.Sum256([]byte(prot + "." + pl))
+	r, s, err := ecdsa.Sign(rand.Reader, key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(pad(r.Bytes()), pad(s.Bytes())...)
+	return json.Marshal(struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{prot, pl, enc.EncodeToString(sig)})
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeV1CA is a minimal ACME v1 server which rejects all POST requests.
+type fakeV1CA struct {
+	url string
+
+	mu     sync.Mutex
+	n      int
+	issued map[string]bool
+}
+
+func (ca *fakeV1CA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	ca.n++
+	nonce := fmt.Sprintf("nonce%d", ca.n)
+	ca.issued[nonce] = true
+	ca.mu.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+
+	if r.Method != "POST" {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke","meta":{"terms-of-service":"%[1]s/tos"}}`, ca.url)
+		return
+	}
+	var jws struct{ Protected string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	b, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var h struct{ Nonce string }
+	json.Unmarshal(b, &h)
+	ca.mu.Lock()
+	ok := ca.issued[h.Nonce]
+	delete(ca.issued, h.Nonce)
+	ca.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"urn:acme:error:badNonce","detail":"JWS has invalid anti-replay nonce","status":400}`)
+		return
+	}
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(w, `{"type":"urn:acme:error:unauthorized","detail":"No registration exists matching provided key","status":403}`)
+}
+
+func TestCheckConformance(t *testing.T) {
+	ca := &fakeV1CA{issued: make(map[string]bool)}
+	ts := httptest.NewServer(ca)
+	defer ts.Close()
+	ca.url = ts.URL
+
+	res, err := checkConformance(context.Background(), http.DefaultClient, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"directory":       "ok",
+		"nonce":           "ok",
+		"nonce-unique":    "ok",
+		"bad-nonce":       "ok",
+		"error-format":    "ok",
+		"post-as-get":     "skip",
+		"order-lifecycle": "skip",
+	}
+	if len(res) != len(want) {
+		t.Errorf("len(res) = %d; want %d", len(res), len(want))
+	}
+	for _, r := range res {
+		if r.status != want[r.name] {
+			t.Errorf("%s: %s (%s); want %s", r.name, r.status, r.detail, want[r.name])
+		}
+	}
+}
+
+// fakeV2CA is a minimal RFC 8555 server which rejects all POST requests
+// as made for an account which does not exist.
+type fakeV2CA struct {
+	url string
+
+	mu     sync.Mutex
+	n      int
+	issued map[string]bool
+}
+
+func (ca *fakeV2CA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	ca.n++
+	nonce := fmt.Sprintf("nonce%d", ca.n)
+	ca.issued[nonce] = true
+	ca.mu.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+
+	switch {
+	case r.URL.Path == "/nonce" && r.Method == "GET":
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case r.URL.Path == "/nonce":
+		return
+	case r.Method != "POST":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"newNonce":"%[1]s/nonce","newAccount":"%[1]s/account","newOrder":"%[1]s/order","revokeCert":"%[1]s/revoke","keyChange":"%[1]s/key","meta":{"termsOfService":"%[1]s/tos"}}`, ca.url)
+		return
+	}
+	var jws struct{ Protected, Payload string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	b, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var h struct {
+		Nonce, URL, KID string
+		JWK             json.RawMessage
+	}
+	json.Unmarshal(b, &h)
+	ca.mu.Lock()
+	ok := ca.issued[h.Nonce]
+	delete(ca.issued, h.Nonce)
+	ca.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	switch {
+	case !ok:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"urn:ietf:params:acme:error:badNonce","detail":"JWS has an invalid anti-replay nonce","status":400}`)
+	case h.URL != ca.url+r.URL.Path || (h.KID == "") == (h.JWK == nil):
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"urn:ietf:params:acme:error:malformed","detail":"bad JWS header","status":400}`)
+	case h.KID != "" && jws.Payload != "":
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"urn:ietf:params:acme:error:malformed","detail":"not POST-as-GET","status":400}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"urn:ietf:params:acme:error:accountDoesNotExist","detail":"no such account","status":400}`)
+	}
+}
+
+func TestCheckConformanceV2(t *testing.T) {
+	ca := &fakeV2CA{issued: make(map[string]bool)}
+	ts := httptest.NewServer(ca)
+	defer ts.Close()
+	ca.url = ts.URL
+
+	res, err := checkConformance(context.Background(), http.DefaultClient, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"directory":       "ok",
+		"nonce":           "ok",
+		"nonce-unique":    "ok",
+		"bad-nonce":       "ok",
+		"error-format":    "ok",
+		"post-as-get":     "ok",
+		"order-lifecycle": "skip",
+	}
+	if len(res) != len(want) {
+		t.Errorf("len(res) = %d; want %d", len(res), len(want))
+	}
+	for _, r := range res {
+		if r.status != want[r.name] {
+			t.Errorf("%s: %s (%s); want %s", r.name, r.status, r.detail, want[r.name])
+		}
+	}
+	if d := res[0].detail; d != "ACME v2 (RFC 8555)" {
+		t.Errorf("directory detail = %q", d)
+	}
+}
+
+func TestCheckConformanceFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Replay-Nonce", "static")
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"new-reg":"/reg","new-authz":"/authz","new-cert":"/cert","revoke-cert":"/revoke"}`)
+			return
+		}
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	res, err := checkConformance(context.Background(), http.DefaultClient, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// relative endpoint URLs fail the directory check; the rest are skipped
+	if res[0].status != "FAIL" {
+		t.Errorf("directory: %s; want FAIL", res[0].status)
+	}
+	for _, r := range res[1:] {
+		if r.status != "skip" {
+			t.Errorf("%s: %s (%s); want skip", r.name, r.status, r.detail)
+		}
+	}
+}
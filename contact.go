@@ -0,0 +1,184 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var cmdContact = &command{
+	run:       runContact,
+	UsageLine: "contact [-c config] [-ca name] set|add|remove [contact [contact ...]]",
+	Short:     "change account contacts",
+	Long: `
+Contact changes the contacts of the account at the CA.
+Set replaces all contacts with the given ones. Add and remove change
+only the given contacts, leaving others as they are. At least one contact
+must remain: an update without contacts leaves them unchanged at the CA.
+
+A contact is a URI such as mailto:admin@example.com or tel:+12025550123.
+An email address without the scheme is taken as mailto: URI.
+Email addresses are verified to be well-formed, without display names
+or URI header fields.
+
+The command prints the account as acknowledged by the CA and
+records the change in {{.AuditFile}} file in the account dir.
+
+See also: acme help account.
+	`,
+}
+
+func runContact(args []string) {
+	if len(args) == 0 {
+		fatalf("contact: set, add or remove required")
+	}
+	op, args := args[0], args[1:]
+	contacts := make([]string, len(args))
+	for i, a := range args {
+		c, err := normalizeContact(a)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		contacts[i] = c
+	}
+
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	client, err := newClient(uc.key, uc.CA)
+	if err != nil {
+		fatalf("%s: %v", uc.CA, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	a, err := client.GetReg(ctx, uc.URI)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	old := a.Contact
+	if a.Contact, err = editContacts(op, old, contacts); err != nil {
+		fatalf("%v", err)
+	}
+	if len(a.Contact) == 0 {
+		fatalf("contact: cannot remove all contacts")
+	}
+	want := a.Contact
+	if a, err = client.UpdateReg(ctx, a); err != nil {
+		fatalf("%v", err)
+	}
+	if !sameContacts(a.Contact, want) {
+		errorf("CA returned contacts %q; requested %q", a.Contact, want)
+	}
+	uc.Account = *a
+	if err := writeConfig(uc); err != nil {
+		errorf("write config: %v", err)
+	}
+	if err := writeAudit("contact %s: %q -> %q", op, old, a.Contact); err != nil {
+		errorf("audit log: %v", err)
+	}
+	printAccount(os.Stdout, &uc.Account, accountKeyName(uc))
+}
+
+// normalizeContact returns contact as a URI, adding mailto: scheme
+// to a bare email address, and verifies email addresses.
+func normalizeContact(contact string) (string, error) {
+	i := strings.IndexByte(contact, ':')
+	if i < 0 {
+		if !strings.Contains(contact, "@") {
+			return "", fmt.Errorf("contact %q: not a URI or email address", contact)
+		}
+		contact, i = "mailto:"+contact, len("mailto")
+	}
+	scheme := strings.ToLower(contact[:i])
+	if scheme != "mailto" {
+		return contact, nil
+	}
+	addr := contact[i+1:]
+	if strings.ContainsAny(addr, "?,") {
+		return "", fmt.Errorf("contact %q: a single email address without header fields required", contact)
+	}
+	a, err := mail.ParseAddress(addr)
+	if err != nil || a.Address != addr {
+		return "", fmt.Errorf("contact %q: malformed email address", contact)
+	}
+	return "mailto:" + addr, nil
+}
+
+// editContacts applies op, one of set, add or remove,
+// with contacts to cur, returning the resulting list.
+func editContacts(op string, cur, contacts []string) ([]string, error) {
+	switch op {
+	case "set":
+		return uniqueContacts(contacts), nil
+	case "add":
+		return uniqueContacts(append(append([]string(nil), cur...), contacts...)), nil
+	case "remove":
+		var res []string
+		remove := make(map[string]bool)
+		for _, c := range contacts {
+			remove[strings.ToLower(c)] = true
+		}
+		for _, c := range cur {
+			if remove[strings.ToLower(c)] {
+				delete(remove, strings.ToLower(c))
+				continue
+			}
+			res = append(res, c)
+		}
+		for _, c := range contacts {
+			if remove[strings.ToLower(c)] {
+				return nil, fmt.Errorf("contact %q not found", c)
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("contact: unknown operation %q; want set, add or remove", op)
+}
+
+// uniqueContacts returns contacts without duplicates, ignoring case,
+// in the original order.
+func uniqueContacts(contacts []string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, c := range contacts {
+		if k := strings.ToLower(c); !seen[k] {
+			seen[k] = true
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// sameContacts reports whether a and b hold the same contacts,
+// in any order.
+func sameContacts(a, b []string) bool {
+	return reflect.DeepEqual(uniqueSet(a), uniqueSet(b))
+}
+
+func uniqueSet(contacts []string) map[string]bool {
+	m := make(map[string]bool)
+	for _, c := range contacts {
+		m[strings.ToLower(c)] = true
+	}
+	return m
+}
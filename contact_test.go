@@ -0,0 +1,98 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeContact(t *testing.T) {
+	tests := []struct {
+		in, out string
+		ok      bool
+	}{
+		{"admin@example.com", "mailto:admin@example.com", true},
+		{"mailto:admin@example.com", "mailto:admin@example.com", true},
+		{"MAILTO:admin@example.com", "mailto:admin@example.com", true},
+		{"tel:+12025550123", "tel:+12025550123", true},
+		{"admin", "", false},
+		{"mailto:admin", "", false},
+		{"mailto:Admin <admin@example.com>", "", false},
+		{"mailto:a@example.com,b@example.com", "", false},
+		{"mailto:admin@example.com?subject=hi", "", false},
+	}
+	for _, test := range tests {
+		out, err := normalizeContact(test.in)
+		if (err == nil) != test.ok || out != test.out {
+			t.Errorf("normalizeContact(%q) = %q, %v; want %q, ok = %v", test.in, out, err, test.out, test.ok)
+		}
+	}
+}
+
+func TestEditContacts(t *testing.T) {
+	cur := []string{"mailto:a@example.com", "mailto:b@example.com"}
+	tests := []struct {
+		op       string
+		contacts []string
+		want     []string
+		ok       bool
+	}{
+		{"set", []string{"mailto:c@example.com", "mailto:C@example.com"}, []string{"mailto:c@example.com"}, true},
+		{"add", []string{"mailto:c@example.com", "mailto:A@example.com"}, []string{"mailto:a@example.com", "mailto:b@example.com", "mailto:c@example.com"}, true},
+		{"remove", []string{"mailto:A@example.com"}, []string{"mailto:b@example.com"}, true},
+		{"remove", []string{"mailto:c@example.com"}, nil, false},
+		{"replace", nil, nil, false},
+	}
+	for _, test := range tests {
+		v, err := editContacts(test.op, cur, test.contacts)
+		if (err == nil) != test.ok || !reflect.DeepEqual(v, test.want) {
+			t.Errorf("editContacts(%s, %q) = %q, %v; want %q, ok = %v", test.op, test.contacts, v, err, test.want, test.ok)
+		}
+	}
+	if !sameContacts([]string{"mailto:B@example.com", "mailto:a@example.com"}, cur) {
+		t.Error("sameContacts: false for reordered contacts")
+	}
+}
+
+func TestWriteAudit(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	defer func() { timeNow = time.Now }()
+	var err error
+	if configDir, err = ioutil.TempDir("", "acme-audit"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	timeNow = func() time.Time { return time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC) }
+
+	for _, msg := range []string{"first", "second"} {
+		if err := writeAudit("contact set: %s", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(configDir, auditFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"2016-10-01T00:00:00Z contact set: first",
+		"2016-10-01T00:00:00Z contact set: second",
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); !reflect.DeepEqual(lines, want) {
+		t.Errorf("audit log = %q; want %q", lines, want)
+	}
+}
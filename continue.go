@@ -0,0 +1,243 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var cmdContinue = &command{
+	run:       runContinue,
+	UsageLine: "continue [-c config] [-ca name] [-timeout dur] [-validation-timeout dur] [-poll dur] [-poll-max n] domain [domain ...]",
+	Short:     "validate published challenge responses",
+	Long: `
+Continue asks the CA to validate the challenges left by cert command
+run with -manual-output=json, once their responses are published,
+and waits for the outcome. The challenges are found in {{.AuthzFile}}
+in the account dir.
+
+Domains whose authorization is already valid are skipped.
+After all domains are validated, the certificate is requested and written
+as the original cert command would, with the arguments kept
+in {{.PendingFile}}. This may happen days after the cert command run,
+as long as the CA keeps the authorizations pending.
+
+The -timeout, -validation-timeout, -poll and -poll-max arguments
+are the same as those of cert command.
+
+See also: acme help cert.
+	`,
+}
+
+func init() {
+	cmdContinue.flag.DurationVar(&certTimeout, "timeout", certTimeout, "")
+	cmdContinue.flag.DurationVar(&certValidationTimeout, "validation-timeout", 0, "")
+	cmdContinue.flag.DurationVar(&certPoll, "poll", certPoll, "")
+	cmdContinue.flag.IntVar(&certPollMax, "poll-max", certPollMax, "")
+}
+
+func runContinue(args []string) {
+	if len(args) == 0 {
+		fatalf("no domain specified")
+	}
+	for i, a := range args {
+		d, err := normalizeDomain(a)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		args[i] = d
+	}
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	state, err := readAuthzState(accountDir())
+	if err != nil {
+		fatalf("read authz state: %v", err)
+	}
+	pp, err := readPendingCerts(accountDir())
+	if err != nil {
+		fatalf("%s: %v", pendingFile, err)
+	}
+	p, ok := pp[pendingKey(args)]
+	disco := uc.CA
+	if ok && p.Flags["d"] != "" {
+		disco = p.Flags["d"]
+	}
+	client, err := newClient(uc.key, disco)
+	if err != nil {
+		fatalf("%s: %v", disco, err)
+	}
+	ctx, cancel := certContext()
+	defer cancel()
+
+	valid := true
+	for _, domain := range uniqueSorted(args) {
+		if err := continueAuthz(ctx, client, domain, state); err != nil {
+			errorf("%s: %v", domain, err)
+			valid = false
+			continue
+		}
+		logf("%s: authorization is valid", domain)
+	}
+	if err := writeAuthzState(accountDir(), state); err != nil {
+		errorf("write authz state: %v", err)
+	}
+	if !valid {
+		exit()
+	}
+
+	if !ok {
+		logf("no pending cert command for %s; run acme cert to obtain the certificate", strings.Join(args, " "))
+		return
+	}
+	// replay the cert command with the recorded arguments;
+	// it removes the pending entry when done
+	cancel()
+	addFlags(&cmdCert.flag)
+	for name, value := range p.Flags {
+		if err := setCertFlag(name, value); err != nil {
+			fatalf("%s: -%s: %v", pendingFile, name, err)
+		}
+	}
+	certManualOutput = "text"
+	runCert(append([]string(nil), p.Domains...))
+}
+
+// pendingCert is a cert command run awaiting continue command.
+type pendingCert struct {
+	Domains []string          `json:"domains"` // cert command arguments, in order
+	Flags   map[string]string `json:"flags"`   // explicitly set cert command flags
+	Created time.Time         `json:"created"`
+}
+
+// pendingKey returns the key of domains in pendingFile entries.
+func pendingKey(domains []string) string {
+	return strings.Join(uniqueSorted(domains), " ")
+}
+
+// readPendingCerts reads the pendingFile in account dir.
+// A missing file results in no entries and no error.
+func readPendingCerts(dir string) (map[string]*pendingCert, error) {
+	pp := make(map[string]*pendingCert)
+	b, err := ioutil.ReadFile(filepath.Join(dir, pendingFile))
+	if os.IsNotExist(err) {
+		return pp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pp, json.Unmarshal(b, &pp)
+}
+
+func writePendingCerts(dir string, pp map[string]*pendingCert) error {
+	path := filepath.Join(dir, pendingFile)
+	if len(pp) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(pp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// savePendingCert records the current cert command run for domains
+// in pendingFile in account dir, replacing a previous one for the same
+// domains. The run is to be continued with the CA directory disco.
+func savePendingCert(dir string, domains []string, disco string) error {
+	pp, err := readPendingCerts(dir)
+	if err != nil {
+		return err
+	}
+	flags := explicitCertFlags()
+	delete(flags, "manual-output")
+	flags["d"] = disco // the CA of the pending authorizations
+	pp[pendingKey(domains)] = &pendingCert{
+		Domains: domains,
+		Flags:   flags,
+		Created: timeNow(),
+	}
+	return writePendingCerts(dir, pp)
+}
+
+// removePendingCert removes the pendingFile entry for domains
+// in account dir, if any.
+func removePendingCert(dir string, domains []string) error {
+	pp, err := readPendingCerts(dir)
+	if err != nil {
+		return err
+	}
+	k := pendingKey(domains)
+	if _, ok := pp[k]; !ok {
+		return nil
+	}
+	delete(pp, k)
+	return writePendingCerts(dir, pp)
+}
+
+// continueAuthz accepts the challenge of domain recorded in state
+// and waits for the authorization to become valid.
+// The challenge is removed from state once it is no longer pending.
+func continueAuthz(ctx context.Context, client *acme.Client, domain string, state authzState) error {
+	e, ok := state[domain]
+	if !ok || e.expired() {
+		return errors.New("no authorization found; run acme cert -manual-output=json")
+	}
+	z, err := client.GetAuthorization(ctx, e.URI)
+	if err != nil {
+		return err
+	}
+	switch {
+	case z.Status == acme.StatusValid:
+		e.Challenge = ""
+		state[domain] = e
+		return nil
+	case z.Status != acme.StatusPending:
+		delete(state, domain)
+		return fmt.Errorf("authorization is %s; run acme cert again", z.Status)
+	case e.Challenge == "":
+		return errors.New("no challenge awaits validation; run acme cert -manual-output=json")
+	}
+	chal, err := client.GetChallenge(ctx, e.Challenge)
+	if err != nil {
+		return err
+	}
+	st := &challengeStats{Domain: domain, Type: chal.Type}
+	st.begin()
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept challenge: %w", err)
+	}
+	err = waitValidation(ctx, client, e.URI)
+	st.report(err)
+	if err == nil || err == acme.ErrAuthorizationFailed {
+		e.Challenge = ""
+		state[domain] = e
+	}
+	return err
+}
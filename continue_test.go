@@ -0,0 +1,176 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestPrintManualChallenge(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key}
+	var buf bytes.Buffer
+	chal := &acme.Challenge{Type: "http-01", Token: "tok"}
+	if err := printManualChallenge(&buf, client, "example.com", chal); err != nil {
+		t.Fatal(err)
+	}
+	var mc manualChallenge
+	if err := json.Unmarshal(buf.Bytes(), &mc); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := client.HTTP01ChallengeResponse("tok")
+	want := manualChallenge{
+		Domain:  "example.com",
+		Type:    "http-01",
+		URL:     "http://example.com/.well-known/acme-challenge/tok",
+		Content: content,
+	}
+	if mc != want {
+		t.Errorf("http-01: %+v; want %+v", mc, want)
+	}
+
+	buf.Reset()
+	chal.Type = "dns-01"
+	if err := printManualChallenge(&buf, client, "example.com", chal); err != nil {
+		t.Fatal(err)
+	}
+	mc = manualChallenge{}
+	if err := json.Unmarshal(buf.Bytes(), &mc); err != nil {
+		t.Fatal(err)
+	}
+	txt, _ := client.DNS01ChallengeRecord("tok")
+	if mc.FQDN != "_acme-challenge.example.com" || mc.TXT != txt || mc.URL != "" {
+		t.Errorf("dns-01: %+v", mc)
+	}
+}
+
+func TestContinueAuthz(t *testing.T) {
+	defer func(d time.Duration) { certPoll = d }(certPoll)
+	certPoll = time.Millisecond
+	status := "pending"
+	var accepted int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch {
+		case r.Method == "HEAD":
+		case r.URL.Path == "/authz":
+			fmt.Fprintf(w, `{"status":%q}`, status)
+		case r.URL.Path == "/chal" && r.Method == "POST":
+			accepted++
+			status = "valid"
+			fmt.Fprint(w, `{"type":"dns-01","token":"tok","status":"pending"}`)
+		case r.URL.Path == "/chal":
+			fmt.Fprint(w, `{"type":"dns-01","token":"tok","status":"pending"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key}
+	ctx := context.Background()
+
+	state := authzState{
+		"example.com": {URI: ts.URL + "/authz", Challenge: ts.URL + "/chal"},
+	}
+	if err := continueAuthz(ctx, client, "other.example.com", state); err == nil {
+		t.Error("continueAuthz(other.example.com): no error")
+	}
+	if err := continueAuthz(ctx, client, "example.com", state); err != nil {
+		t.Fatalf("continueAuthz: %v", err)
+	}
+	if accepted != 1 {
+		t.Errorf("accepted %d times; want 1", accepted)
+	}
+	if e := state["example.com"]; e.Challenge != "" || e.URI != ts.URL+"/authz" {
+		t.Errorf("state entry = %+v", e)
+	}
+
+	// already valid
+	if err := continueAuthz(ctx, client, "example.com", state); err != nil || accepted != 1 {
+		t.Errorf("continueAuthz: %v; accepted %d times", err, accepted)
+	}
+
+	status = "invalid"
+	if err := continueAuthz(ctx, client, "example.com", state); err == nil {
+		t.Error("invalid authorization: no error")
+	}
+	if _, ok := state["example.com"]; ok {
+		t.Error("invalid authorization kept in state")
+	}
+}
+
+func TestPendingCert(t *testing.T) {
+	defer func(dir string, fs *flag.FlagSet) { configDir, certFlags = dir, fs }(configDir, certFlags)
+	var err error
+	if configDir, err = ioutil.TempDir("", "acme-pending"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	fs := flag.NewFlagSet("cert", flag.ContinueOnError)
+	fs.String("k", "", "")
+	fs.String("manual-output", "text", "")
+	if err := fs.Parse([]string{"-k", "/etc/ssl/example.key", "-manual-output", "json"}); err != nil {
+		t.Fatal(err)
+	}
+	certFlags = fs
+
+	domains := []string{"www.example.com", "example.com"}
+	if err := savePendingCert(configDir, domains, "https://ca/directory"); err != nil {
+		t.Fatal(err)
+	}
+	pp, err := readPendingCerts(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := pp[pendingKey([]string{"example.com", "www.example.com"})]
+	if !ok {
+		t.Fatalf("no entry for %q in %v", domains, pp)
+	}
+	want := map[string]string{"k": "/etc/ssl/example.key", "d": "https://ca/directory"}
+	if !reflect.DeepEqual(p.Domains, domains) || !reflect.DeepEqual(p.Flags, want) {
+		t.Errorf("pending = %+v; want domains %q, flags %v", p, domains, want)
+	}
+
+	if err := removePendingCert(configDir, domains); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, pendingFile)); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", pendingFile, err)
+	}
+	if err := removePendingCert(configDir, domains); err != nil {
+		t.Errorf("removePendingCert of missing entry: %v", err)
+	}
+}
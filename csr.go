@@ -0,0 +1,192 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// noCN is a -cn flag value which omits the Common Name from the CSR.
+const noCN = "none"
+
+var (
+	// oidExtKeyUsage is the extended key usage extension identifier.
+	oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	// extKeyUsages maps -eku flag values to their object identifiers.
+	extKeyUsages = map[string]asn1.ObjectIdentifier{
+		"serverAuth":      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+		"clientAuth":      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+		"codeSigning":     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+		"emailProtection": {1, 3, 6, 1, 5, 5, 7, 3, 4},
+		"timeStamping":    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+		"OCSPSigning":     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	}
+)
+
+// newCSR creates a DER encoded certificate request for the given SANs
+// signed with key. The cn argument, unless empty, is used as the subject
+// Common Name and must be one of the SANs.
+// The exts are added to the request as is.
+func newCSR(key crypto.Signer, cn string, sans []string, exts []pkix.Extension) ([]byte, error) {
+	if cn != "" && !contains(sans, cn) {
+		return nil, fmt.Errorf("common name %q is not one of the domains", cn)
+	}
+	req := &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: cn},
+		DNSNames:        sans,
+		ExtraExtensions: exts,
+	}
+	return x509.CreateCertificateRequest(rand.Reader, req, key)
+}
+
+// reuseCSR returns the certificate request stored in PEM format at path
+// if it has the same content as csr, which differs only in its signature.
+// Otherwise, including when the file does not exist, csr is returned.
+//
+// Since a CSR content depends solely on the key, domains and extensions,
+// this makes repeated requests with the same parameters byte-identical.
+func reuseCSR(path string, csr []byte) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return csr
+	}
+	d, _ := pem.Decode(b)
+	if d == nil || d.Type != "CERTIFICATE REQUEST" {
+		return csr
+	}
+	stored, err := x509.ParseCertificateRequest(d.Bytes)
+	if err != nil || stored.CheckSignature() != nil {
+		return csr
+	}
+	req, err := x509.ParseCertificateRequest(csr)
+	if err != nil || !bytes.Equal(stored.RawTBSCertificateRequest, req.RawTBSCertificateRequest) {
+		return csr
+	}
+	return stored.Raw
+}
+
+// writeCSR stores the DER encoded csr to path in PEM format.
+func writeCSR(path string, csr []byte) error {
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// csrHash returns hex encoded SHA-256 digest of the DER encoded csr.
+func csrHash(csr []byte) string {
+	h := sha256.Sum256(csr)
+	return hex.EncodeToString(h[:])
+}
+
+// ekuExtension creates an extended key usage extension from a comma separated
+// list of extKeyUsages names.
+func ekuExtension(list string) (pkix.Extension, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, name := range strings.Split(list, ",") {
+		oid, ok := extKeyUsages[strings.TrimSpace(name)]
+		if !ok {
+			return pkix.Extension{}, fmt.Errorf("unknown extended key usage %q", name)
+		}
+		oids = append(oids, oid)
+	}
+	b, err := asn1.Marshal(oids)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtKeyUsage, Value: b}, nil
+}
+
+// extFlag is a repeatable flag which collects custom CSR extensions.
+// Each value is in "oid=hex" form, where hex is the DER encoded
+// extension value.
+type extFlag []pkix.Extension
+
+func (f *extFlag) String() string {
+	var s []string
+	for _, e := range *f {
+		s = append(s, e.Id.String()+"="+hex.EncodeToString(e.Value))
+	}
+	return strings.Join(s, " ")
+}
+
+func (f *extFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i < 0 {
+		return fmt.Errorf("%q: want oid=hex", v)
+	}
+	var oid asn1.ObjectIdentifier
+	for _, p := range strings.Split(v[:i], ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q: invalid object identifier", v[:i])
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return fmt.Errorf("%q: invalid object identifier", v[:i])
+	}
+	b, err := hex.DecodeString(v[i+1:])
+	if err != nil {
+		return fmt.Errorf("%q: %v", v[i+1:], err)
+	}
+	*f = append(*f, pkix.Extension{Id: oid, Value: b})
+	return nil
+}
+
+// uniqueSorted returns a sorted copy of names with duplicates removed.
+func uniqueSorted(names []string) []string {
+	var res []string
+	for _, n := range names {
+		if !contains(res, n) {
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// checkCertNames verifies that the DNS names of cert are exactly sans.
+// The sans argument must be sorted and contain no duplicates.
+func checkCertNames(cert *x509.Certificate, sans []string) error {
+	got := make([]string, len(cert.DNSNames))
+	for i, n := range cert.DNSNames {
+		got[i] = strings.ToLower(n)
+	}
+	got = uniqueSorted(got)
+	if strings.Join(got, " ") != strings.Join(sans, " ") {
+		return fmt.Errorf("certificate names %v do not match requested %v", got, sans)
+	}
+	return nil
+}
+
+// contains reports whether s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
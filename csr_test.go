@@ -0,0 +1,174 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sans := uniqueSorted([]string{"b.example.com", "a.example.com", "b.example.com"})
+	if want := []string{"a.example.com", "b.example.com"}; !reflect.DeepEqual(sans, want) {
+		t.Errorf("sans = %v; want %v", sans, want)
+	}
+
+	der, err := newCSR(key, "b.example.com", sans, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Subject.CommonName != "b.example.com" {
+		t.Errorf("CommonName = %q; want b.example.com", req.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(req.DNSNames, sans) {
+		t.Errorf("DNSNames = %v; want %v", req.DNSNames, sans)
+	}
+
+	if der, err = newCSR(key, "", sans, nil); err != nil {
+		t.Fatal(err)
+	}
+	if req, err = x509.ParseCertificateRequest(der); err != nil {
+		t.Fatal(err)
+	}
+	if req.Subject.CommonName != "" {
+		t.Errorf("CommonName = %q; want empty", req.Subject.CommonName)
+	}
+
+	if _, err := newCSR(key, "c.example.com", sans, nil); err == nil {
+		t.Error("newCSR: CN not in SANs: no error")
+	}
+}
+
+func TestCheckCertNames(t *testing.T) {
+	sans := []string{"a.example.com", "b.example.com"}
+	cert := &x509.Certificate{DNSNames: []string{"B.example.com", "a.example.com"}}
+	if err := checkCertNames(cert, sans); err != nil {
+		t.Errorf("checkCertNames: %v", err)
+	}
+	cert.DNSNames = cert.DNSNames[:1]
+	if err := checkCertNames(cert, sans); err == nil {
+		t.Error("checkCertNames: missing name: no error")
+	}
+}
+
+func TestCSRExtensions(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exts extFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&exts, "ext", "")
+	if err := fs.Parse([]string{"-ext", "1.2.3.4=0500"}); err != nil {
+		t.Fatal(err)
+	}
+	eku, err := ekuExtension("serverAuth,clientAuth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := newCSR(key, "", []string{"example.com"}, append(exts, eku))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string][]byte)
+	for _, e := range req.Extensions {
+		found[e.Id.String()] = e.Value
+	}
+	if v := found["1.2.3.4"]; !reflect.DeepEqual(v, []byte{5, 0}) {
+		t.Errorf("1.2.3.4 value = %x; want 0500", v)
+	}
+	if v := found[oidExtKeyUsage.String()]; !reflect.DeepEqual(v, eku.Value) {
+		t.Errorf("eku value = %x; want %x", v, eku.Value)
+	}
+}
+
+func TestExtFlagError(t *testing.T) {
+	for _, v := range []string{"1.2.3", "x.y=05", "1=05", "1.2.3=zz"} {
+		var f extFlag
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q): no error", v)
+		}
+	}
+	if _, err := ekuExtension("serverAuth,bogus"); err == nil {
+		t.Error("ekuExtension: bogus usage: no error")
+	}
+}
+
+func TestReuseCSR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "example.com.csr")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sans := []string{"example.com", "www.example.com"}
+	csr1, err := newCSR(key, "example.com", sans, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := reuseCSR(path, csr1); !bytes.Equal(b, csr1) {
+		t.Error("reuseCSR without a stored file returned a different CSR")
+	}
+	if err := writeCSR(path, csr1); err != nil {
+		t.Fatal(err)
+	}
+
+	// ECDSA signatures are randomized; the stored request is reused
+	csr2, err := newCSR(key, "example.com", sans, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(csr1, csr2) {
+		t.Fatal("newCSR produced identical signatures")
+	}
+	if b := reuseCSR(path, csr2); !bytes.Equal(b, csr1) {
+		t.Error("reuseCSR did not return the stored CSR")
+	}
+
+	// different domains make a new request
+	csr3, err := newCSR(key, "example.com", sans[:1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := reuseCSR(path, csr3); !bytes.Equal(b, csr3) {
+		t.Error("reuseCSR returned the stored CSR for different domains")
+	}
+	if len(csrHash(csr1)) != 64 {
+		t.Errorf("csrHash(csr1) = %q; want 64 hex digits", csrHash(csr1))
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var cmdDoctor = &command{
+	run:       runDoctor,
+	UsageLine: "doctor [-c config] [-ca name]",
+	Short:     "diagnose configuration and connectivity",
+	Long: `
+Doctor checks the setup of the account and the host for common problems
+and prints its findings, with hints on how to fix them:
+
+	config-dir     the account dir exists and is not writable by others
+	account-key    the key file is readable only by the owner
+	config         {{.AccountFile}} and the account key can be read
+	ca             the CA directory can be fetched
+	clock          the local clock agrees with the CA's
+	account        the CA knows the account and its terms are accepted
+	listen :80     the port can be listened on for http-01 challenges
+	listen :443    the port can be listened on, e.g. by a web server
+	dns-provider   DNS provider credentials
+
+The command exits with non-zero status if any check fails.
+Warnings do not affect the exit status.
+
+Default location of the config dir is {{.ConfigDir}}.
+	`,
+}
+
+var (
+	// doctorPorts are the addresses checked for being available to listen on.
+	doctorPorts = []string{":80", ":443"}
+
+	// doctorSkew is the clock difference with the CA reported as a warning.
+	// Five times as much is reported as a failure.
+	doctorSkew = time.Minute
+)
+
+func runDoctor(args []string) {
+	if len(args) != 0 {
+		fatalf("doctor: unexpected arguments %q", args)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx, cancel = interruptContext(ctx)
+	defer cancel()
+	printChecks(os.Stdout, diagnose(ctx))
+}
+
+// diagnose runs the doctor checks of the account selected with -c and -ca.
+func diagnose(ctx context.Context) []checkResult {
+	var res []checkResult
+	add := func(name, status, format string, args ...interface{}) {
+		res = append(res, checkResult{name, status, fmt.Sprintf(format, args...)})
+	}
+	skip := func(reason string, names ...string) {
+		for _, n := range names {
+			add(n, "skip", "%s", reason)
+		}
+	}
+
+	dir := accountDir()
+	switch fi, err := os.Stat(dir); {
+	case err != nil:
+		add("config-dir", "FAIL", "%v; run acme init or acme reg", err)
+	case runtime.GOOS != "windows" && fi.Mode().Perm()&0022 != 0:
+		add("config-dir", "WARN", "%s is writable by group or others (%#o); run chmod go-w %s", dir, fi.Mode().Perm(), dir)
+	default:
+		add("config-dir", "ok", "%s", dir)
+	}
+	kp := accountKeyPath()
+	switch fi, err := os.Stat(kp); {
+	case err != nil:
+		add("account-key", "skip", "%s not found", kp)
+	case runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0:
+		add("account-key", "WARN", "%s is accessible by group or others (%#o); run chmod 600 %s", kp, fi.Mode().Perm(), kp)
+	default:
+		add("account-key", "ok", "%s", kp)
+	}
+
+	uc, err := readConfig()
+	if err == nil && uc.key == nil {
+		err = fmt.Errorf("no key found for %s", uc.URI)
+	}
+	if err != nil {
+		add("config", "FAIL", "%v", err)
+		skip("no config", "ca", "clock", "account")
+	} else {
+		add("config", "ok", "account %s", uc.URI)
+		diagnoseCA(ctx, uc, add)
+	}
+
+	for _, addr := range doctorPorts {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			add("listen "+addr, "WARN", "%v; use cert -s with a forwarded port, -dns or -manual", err)
+			continue
+		}
+		l.Close()
+		add("listen "+addr, "ok", "")
+	}
+	add("dns-provider", "skip", "no DNS provider integration; cert -dns prints the records to create")
+	return res
+}
+
+// diagnoseCA checks connectivity to the CA of uc, clock skew and
+// the account status, reporting results with add.
+func diagnoseCA(ctx context.Context, uc *userConfig, add func(name, status, format string, args ...interface{})) {
+	client, err := newClient(uc.key, uc.CA)
+	if err != nil {
+		add("ca", "FAIL", "%s: %v", uc.CA, err)
+		add("clock", "skip", "no CA connection")
+		add("account", "skip", "no CA connection")
+		return
+	}
+	start := timeNow()
+	req, err := http.NewRequest("GET", client.DirectoryURL, nil)
+	var r *http.Response
+	if err == nil {
+		r, err = client.HTTPClient.Do(req.WithContext(ctx))
+	}
+	if err != nil {
+		add("ca", "FAIL", "%v; check network, firewall and -proxy settings", err)
+		add("clock", "skip", "no CA connection")
+		add("account", "skip", "no CA connection")
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		add("ca", "FAIL", "%s: %s", client.DirectoryURL, r.Status)
+	} else if _, err := discover(ctx, client); err != nil {
+		add("ca", "FAIL", "%v", err)
+	} else {
+		add("ca", "ok", "%s in %v", client.DirectoryURL, timeNow().Sub(start).Round(time.Millisecond))
+	}
+
+	if date, err := http.ParseTime(r.Header.Get("Date")); err != nil {
+		add("clock", "skip", "no Date header in the CA response")
+	} else {
+		skew := timeNow().Sub(date)
+		if skew < 0 {
+			skew = -skew
+		}
+		skew = skew.Round(time.Second)
+		switch {
+		case skew >= 5*doctorSkew:
+			add("clock", "FAIL", "off by %v from the CA; synchronize the system clock, e.g. with NTP", skew)
+		case skew >= doctorSkew:
+			add("clock", "WARN", "off by %v from the CA; consider synchronizing it with NTP", skew)
+		default:
+			add("clock", "ok", "")
+		}
+	}
+
+	a, err := client.GetReg(ctx, uc.URI)
+	switch {
+	case err != nil:
+		var ae *acme.Error
+		if errors.As(err, &ae) && ae.StatusCode == http.StatusNotFound {
+			add("account", "FAIL", "%v; the account may be deactivated, run acme reg", err)
+		} else {
+			add("account", "FAIL", "%v", err)
+		}
+	case a.CurrentTerms != "" && a.AgreedTerms != a.CurrentTerms:
+		add("account", "WARN", "terms %s not accepted; run acme update -accept", a.CurrentTerms)
+	default:
+		add("account", "ok", "")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestDiagnose(t *testing.T) {
+	defer func(dir, ca string, ports []string) {
+		configDir, flagCA, doctorPorts = dir, ca, ports
+	}(configDir, flagCA, doctorPorts)
+	defer func() { timeNow = time.Now }()
+	var err error
+	if configDir, err = ioutil.TempDir("", "acme-doctor"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	flagCA, doctorPorts = "", nil
+
+	status := func(res []checkResult) map[string]string {
+		m := make(map[string]string)
+		for _, r := range res {
+			m[r.name] = r.status
+		}
+		return m
+	}
+	res := diagnose(context.Background())
+	if s := status(res); s["config"] != "FAIL" || s["account"] != "skip" {
+		t.Errorf("no config: %v", res)
+	}
+
+	var terms string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		if r.Method == "POST" {
+			if terms != "" {
+				w.Header().Set("Link", fmt.Sprintf("<%s>;rel=\"terms-of-service\"", terms))
+			}
+			fmt.Fprint(w, `{"contact":["mailto:admin@example.com"]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke"}`, "http://"+r.Host)
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(configDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKey(filepath.Join(configDir, accountKey), key); err != nil {
+		t.Fatal(err)
+	}
+	uc := &userConfig{Account: acme.Account{URI: ts.URL + "/reg/1"}, CA: ts.URL}
+	if err := writeConfig(uc); err != nil {
+		t.Fatal(err)
+	}
+
+	res = diagnose(context.Background())
+	want := map[string]string{
+		"config-dir":   "ok",
+		"account-key":  "ok",
+		"config":       "ok",
+		"ca":           "ok",
+		"clock":        "ok",
+		"account":      "ok",
+		"dns-provider": "skip",
+	}
+	if s := status(res); fmt.Sprint(s) != fmt.Sprint(want) {
+		t.Errorf("diagnose = %v; want %v", res, want)
+	}
+
+	terms = ts.URL + "/terms"
+	timeNow = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	s := status(diagnose(context.Background()))
+	if s["clock"] != "FAIL" || s["account"] != "WARN" {
+		t.Errorf("clock = %s, account = %s; want FAIL, WARN", s["clock"], s["account"])
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// normalizeDomain validates domain name d and returns its canonical form:
+// lower case and without the trailing dot.
+// The returned error describes what is wrong with d in a way that is
+// suitable for displaying to the user as is.
+func normalizeDomain(d string) (string, error) {
+	if strings.Contains(d, "://") {
+		msg := fmt.Sprintf("%q looks like a URL; specify a domain name only", d)
+		if u, err := url.Parse(d); err == nil && u.Hostname() != "" {
+			msg += fmt.Sprintf(", e.g. %q", u.Hostname())
+		}
+		return "", fmt.Errorf("%s", msg)
+	}
+	if strings.Contains(d, "/") {
+		return "", fmt.Errorf("%q: domain name must not contain a path", d)
+	}
+	if _, _, err := net.SplitHostPort(d); err == nil {
+		return "", fmt.Errorf("%q: domain name must not contain a port", d)
+	}
+	name := strings.ToLower(strings.TrimSuffix(d, "."))
+	if name == "" {
+		return "", fmt.Errorf("%q: empty domain name", d)
+	}
+	if net.ParseIP(name) != nil {
+		return "", fmt.Errorf("%q: IP addresses are not supported", d)
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("%q: domain name is longer than 253 characters", d)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if err := checkLabel(label); err != nil {
+			return "", fmt.Errorf("%q: %v", d, err)
+		}
+	}
+	return name, nil
+}
+
+// checkLabel reports whether label is a valid lower case DNS label.
+func checkLabel(label string) error {
+	switch {
+	case label == "":
+		return fmt.Errorf("empty label")
+	case label == "*":
+		return fmt.Errorf("wildcard names are not supported")
+	case len(label) > 63:
+		return fmt.Errorf("label %q is longer than 63 characters", label)
+	case label[0] == '-' || label[len(label)-1] == '-':
+		return fmt.Errorf("label %q must not start or end with a hyphen", label)
+	}
+	for _, r := range label {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '-':
+			// ok
+		case r > 127:
+			return fmt.Errorf("label %q contains non-ASCII characters; use its punycode (xn--) form", label)
+		default:
+			return fmt.Errorf("label %q contains invalid character %q", label, r)
+		}
+	}
+	return nil
+}
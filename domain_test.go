@@ -0,0 +1,68 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "example.com"},
+		{"example.com.", "example.com"},
+		{"a-b.example.org", "a-b.example.org"},
+		{"xn--80ak6aa92e.com", "xn--80ak6aa92e.com"},
+		{strings.Repeat("a", 63) + ".com", strings.Repeat("a", 63) + ".com"},
+	}
+	for _, test := range tests {
+		v, err := normalizeDomain(test.in)
+		if err != nil {
+			t.Errorf("normalizeDomain(%q): %v", test.in, err)
+			continue
+		}
+		if v != test.want {
+			t.Errorf("normalizeDomain(%q) = %q; want %q", test.in, v, test.want)
+		}
+	}
+}
+
+func TestNormalizeDomainError(t *testing.T) {
+	tests := []struct {
+		in  string
+		err string // error substring
+	}{
+		{"https://example.com", `e.g. "example.com"`},
+		{"example.com/path", "path"},
+		{"example.com:443", "port"},
+		{"", "empty"},
+		{".", "empty"},
+		{"a..com", "empty label"},
+		{"*.example.com", "wildcard"},
+		{"127.0.0.1", "IP"},
+		{"-a.com", "hyphen"},
+		{"a_b.com", "invalid character"},
+		{"пример.com", "punycode"},
+		{strings.Repeat("a", 64) + ".com", "63"},
+		{strings.Repeat("a.", 127) + "com", "253"},
+	}
+	for _, test := range tests {
+		_, err := normalizeDomain(test.in)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("normalizeDomain(%q): %v; want error containing %q", test.in, err, test.err)
+		}
+	}
+}
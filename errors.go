@@ -0,0 +1,111 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Error scopes, as reported by errorScope.
+const (
+	scopeAccount   = "account"       // the account needs attention, e.g. agreement
+	scopeAuthz     = "authorization" // the CA refuses to authorize an identifier
+	scopeChallenge = "challenge"     // domain validation failed
+	scopeCert      = "certificate"   // the CA refuses to issue the certificate
+)
+
+// problemScopes maps ACME problem types, without the urn:acme:error: prefix,
+// to the scope they relate to.
+var problemScopes = map[string]string{
+	"agreementRequired":     scopeAccount,
+	"invalidEmail":          scopeAccount,
+	"invalidContact":        scopeAccount,
+	"unauthorized":          scopeAccount,
+	"rejectedIdentifier":    scopeAuthz,
+	"unsupportedIdentifier": scopeAuthz,
+	"caa":                   scopeAuthz,
+	"connection":            scopeChallenge,
+	"dns":                   scopeChallenge,
+	"dnssec":                scopeChallenge,
+	"incorrectResponse":     scopeChallenge,
+	"tls":                   scopeChallenge,
+	"unknownHost":           scopeChallenge,
+	"badCSR":                scopeCert,
+}
+
+// problemType returns the ACME problem type of err without the namespace
+// prefix, or an empty string if err is not and does not wrap an *acme.Error.
+func problemType(err error) string {
+	var e *acme.Error
+	if !errors.As(err, &e) {
+		return ""
+	}
+	t := e.ProblemType
+	if i := strings.LastIndexByte(t, ':'); i >= 0 {
+		t = t[i+1:]
+	}
+	return t
+}
+
+// isRetryable reports whether err is a transient failure, such as a network
+// error, a CA internal error, a rate limit or a stale nonce, and the same
+// request may succeed when retried later, unchanged.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var e *acme.Error
+	if errors.As(err, &e) {
+		switch problemType(err) {
+		case "rateLimited", "badNonce", "serverInternal":
+			return true
+		}
+		return e.StatusCode >= 500 || e.StatusCode == 429
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// isClientError reports whether err is a terminal failure caused by
+// the request or the account, which needs to be fixed before retrying.
+func isClientError(err error) bool {
+	if err == nil || isRetryable(err) {
+		return false
+	}
+	var e *acme.Error
+	var ce *challengeError
+	switch {
+	case errors.As(err, &e):
+		return e.StatusCode >= 400 && e.StatusCode < 500
+	case errors.As(err, &ce), errors.Is(err, acme.ErrAuthorizationFailed):
+		return true
+	}
+	return false
+}
+
+// errorScope returns which of the account, authorization, challenge
+// or certificate err relates to, or an empty string if unknown.
+func errorScope(err error) string {
+	var ce *challengeError
+	if errors.As(err, &ce) || errors.Is(err, acme.ErrAuthorizationFailed) {
+		return scopeChallenge
+	}
+	return problemScopes[problemType(err)]
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+		client    bool
+		scope     string
+	}{
+		{&acme.Error{StatusCode: 500, ProblemType: "urn:acme:error:serverInternal"}, true, false, ""},
+		{&acme.Error{StatusCode: 429, ProblemType: "urn:acme:error:rateLimited"}, true, false, ""},
+		{&acme.Error{StatusCode: 400, ProblemType: "urn:acme:error:badNonce"}, true, false, ""},
+		{fmt.Errorf("example.com: %w", &acme.Error{StatusCode: 503}), true, false, ""},
+		{&acme.Error{StatusCode: 403, ProblemType: "urn:acme:error:unauthorized"}, false, true, scopeAccount},
+		{&acme.Error{StatusCode: 400, ProblemType: "urn:acme:error:badCSR"}, false, true, scopeCert},
+		{&acme.Error{StatusCode: 400, ProblemType: "urn:acme:error:rejectedIdentifier"}, false, true, scopeAuthz},
+		{&acme.Error{StatusCode: 400, ProblemType: "urn:ietf:params:acme:error:caa"}, false, true, scopeAuthz},
+		{acme.ErrAuthorizationFailed, false, true, scopeChallenge},
+		{fmt.Errorf("a.example: %w", &challengeError{}), false, true, scopeChallenge},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, true, false, ""},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded), true, false, ""},
+		{errors.New("bad key"), false, false, ""},
+		{nil, false, false, ""},
+	}
+	for i, test := range tests {
+		if v := isRetryable(test.err); v != test.retryable {
+			t.Errorf("%d: isRetryable(%v) = %v; want %v", i, test.err, v, test.retryable)
+		}
+		if v := isClientError(test.err); v != test.client {
+			t.Errorf("%d: isClientError(%v) = %v; want %v", i, test.err, v, test.client)
+		}
+		if v := errorScope(test.err); v != test.scope {
+			t.Errorf("%d: errorScope(%v) = %q; want %q", i, test.err, v, test.scope)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// fipsMode restricts keys and signature algorithms to a FIPS 140
+// approved set: RSA 2048 or 3072 bit, ECDSA P-256 or P-384, with SHA-256
+// or SHA-384 digests.
+//
+// It is set with -fips flag, common to all subcommands, and is enabled
+// by default in binaries built with "fips" build tag.
+var fipsMode bool
+
+// fipsRequired is set in binaries built with "fips" build tag,
+// where -fips=false is not allowed.
+var fipsRequired bool
+
+// checkFIPSKey returns an error if pub is not an approved key
+// and fipsMode is enabled.
+func checkFIPSKey(pub crypto.PublicKey) error {
+	if !fipsMode {
+		return nil
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if n := pub.N.BitLen(); n != 2048 && n != 3072 {
+			return fmt.Errorf("RSA %d key is not allowed in FIPS mode", n)
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return fmt.Errorf("ECDSA %s key is not allowed in FIPS mode", pub.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("%T key is not allowed in FIPS mode", pub)
+	}
+	return nil
+}
+
+// checkFIPSSignature returns an error if alg is not an approved
+// signature algorithm and fipsMode is enabled.
+func checkFIPSSignature(alg x509.SignatureAlgorithm) error {
+	if !fipsMode {
+		return nil
+	}
+	switch alg {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384:
+		return nil
+	}
+	return fmt.Errorf("%v signature is not allowed in FIPS mode", alg)
+}
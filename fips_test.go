@@ -0,0 +1,86 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+)
+
+func TestCheckFIPSKey(t *testing.T) {
+	defer func(m bool) { fipsMode = m }(fipsMode)
+
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey := func(c elliptic.Curve) crypto.PublicKey {
+		k, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.Public()
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pub  crypto.PublicKey
+		ok   bool
+	}{
+		{"rsa1024", rsa1024.Public(), false},
+		{"rsa2048", rsa2048.Public(), true},
+		{"p224", ecKey(elliptic.P224()), false},
+		{"p256", ecKey(elliptic.P256()), true},
+		{"p384", ecKey(elliptic.P384()), true},
+		{"p521", ecKey(elliptic.P521()), false},
+		{"ed25519", edPub, false},
+	}
+	for _, test := range tests {
+		fipsMode = false
+		if err := checkFIPSKey(test.pub); err != nil {
+			t.Errorf("%s: checkFIPSKey without FIPS mode: %v", test.name, err)
+		}
+		fipsMode = true
+		err := checkFIPSKey(test.pub)
+		if test.ok && err != nil {
+			t.Errorf("%s: checkFIPSKey: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: checkFIPSKey: no error", test.name)
+		}
+	}
+}
+
+func TestCheckFIPSSignature(t *testing.T) {
+	defer func(m bool) { fipsMode = m }(fipsMode)
+	fipsMode = true
+	if err := checkFIPSSignature(x509.ECDSAWithSHA256); err != nil {
+		t.Errorf("ECDSAWithSHA256: %v", err)
+	}
+	if err := checkFIPSSignature(x509.SHA1WithRSA); err == nil {
+		t.Error("SHA1WithRSA: no error")
+	}
+}
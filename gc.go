@@ -0,0 +1,298 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	cmdGC = &command{
+		run:       runGC,
+		UsageLine: "gc [-c config] [-ca name] [-keep n] [-n] [-json] [dir ...]",
+		Short:     "remove stale state",
+		Long: `
+Gc removes state which is no longer needed:
+
+	- expired authorizations recorded in {{.AuthzFile}} of the account
+	- temporary files ending with .tmp, such as those left
+	  by an interrupted -link update, in the config dir
+	  and the dir arguments, older than {{.GCAge}}
+	- -manual challenge response files in the system temporary dir
+	  older than {{.GCAge}}
+	- superseded certificates in each of the dir arguments
+
+A dir argument is a directory where -out of cert command keeps every issued
+certificate, for example /etc/ssl/example.com with -out
+/etc/ssl/{{"{{.Domain}}/{{.Serial}}"}}.crt. The certificates in it are grouped
+by the names they are issued for, and all but the -keep most recent ones
+of each group, 3 by default, are removed. Files holding the same certificate,
+such as -out and -fullchain ones, count as one and are removed together.
+Certificates which a symbolic link in the dir points to, such as one
+created with -link, are always kept.
+
+Every removed item is logged, followed by the number of items and bytes
+reclaimed. With -json, they are written to the standard output
+as a JSON object instead. The -n argument reports what would be removed
+without removing anything.
+
+Default location of the config dir is {{.ConfigDir}}.
+		`,
+	}
+
+	gcKeep   = 3
+	gcDryRun bool
+)
+
+// gcAge is the age of leftover temporary files removed by gc command.
+const gcAge = 24 * time.Hour
+
+// challengeFilePrefix starts the names of -manual challenge response files,
+// for gc command to recognize leftovers.
+const challengeFilePrefix = "acme-challenge-"
+
+func init() {
+	cmdGC.flag.IntVar(&gcKeep, "keep", gcKeep, "")
+	cmdGC.flag.BoolVar(&gcDryRun, "n", gcDryRun, "")
+}
+
+// gcItem is an item removed by gc command.
+type gcItem struct {
+	Path   string `json:"path"` // file path or authorization URI
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+// gcReport is the outcome of gc command, written in -json mode.
+type gcReport struct {
+	Items  []gcItem `json:"items"`
+	Bytes  int64    `json:"bytes"`
+	DryRun bool     `json:"dryRun,omitempty"`
+}
+
+// remove removes the file at path, unless in -n mode, recording it in r.
+func (r *gcReport) remove(path, reason string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !r.DryRun {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	r.add(gcItem{Path: path, Reason: reason, Size: fi.Size()})
+	return nil
+}
+
+func (r *gcReport) add(it gcItem) {
+	r.Items = append(r.Items, it)
+	r.Bytes += it.Size
+	if flagJSON {
+		return
+	}
+	verb := "removed"
+	if r.DryRun {
+		verb = "would remove"
+	}
+	logf("%s %s: %s", verb, it.Path, it.Reason)
+}
+
+func runGC(args []string) {
+	if gcKeep < 1 {
+		fatalf("-keep must be at least 1")
+	}
+	r := &gcReport{Items: []gcItem{}, DryRun: gcDryRun}
+	if err := gcAuthz(r); err != nil {
+		errorf("authz state: %v", err)
+	}
+	if err := gcTemp(r, configDir, os.TempDir()); err != nil {
+		errorf("temporary files: %v", err)
+	}
+	for _, dir := range args {
+		if err := gcCerts(r, dir, gcKeep); err != nil {
+			errorf("%s: %v", dir, err)
+		}
+	}
+	if flagJSON {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Printf("%s\n", b)
+		return
+	}
+	verb := "reclaimed"
+	if r.DryRun {
+		verb = "would reclaim"
+	}
+	logf("%s %d bytes in %d items", verb, r.Bytes, len(r.Items))
+}
+
+// gcAuthz removes expired authorizations from the authz state of the account.
+func gcAuthz(r *gcReport) error {
+	state, err := readAuthzState(accountDir())
+	if err != nil {
+		return err
+	}
+	var domains []string
+	for domain, e := range state {
+		if e.expired() {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		r.add(gcItem{Path: state[domain].URI, Reason: "expired authorization of " + domain})
+		delete(state, domain)
+	}
+	if r.DryRun {
+		return nil
+	}
+	return writeAuthzState(accountDir(), state)
+}
+
+// gcTemp removes temporary files older than gcAge: those in the config dir
+// and -manual challenge response files in tmpDir.
+func gcTemp(r *gcReport, configDir, tmpDir string) error {
+	cutoff := timeNow().Add(-gcAge)
+	err := filepath.Walk(configDir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !strings.HasSuffix(path, ".tmp") || fi.ModTime().After(cutoff) {
+			return nil
+		}
+		return r.remove(path, "stale temporary file")
+	})
+	if err != nil {
+		return err
+	}
+	ff, err := filepath.Glob(filepath.Join(tmpDir, challengeFilePrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range ff {
+		fi, err := os.Lstat(path)
+		if err != nil || !fi.Mode().IsRegular() || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := r.remove(path, "stale challenge response file"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcCerts removes certificates in dir superseded by the keep most recent
+// ones issued for the same names, except those a symbolic link
+// in dir points to, and temporary files older than gcAge.
+// Files holding the same certificate count as one version of it.
+func gcCerts(r *gcReport, dir string, keep int) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	ff, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	// a version is a certificate and the files holding it,
+	// e.g. -out and -fullchain ones
+	type version struct {
+		paths     []string
+		notBefore time.Time
+	}
+	cutoff := timeNow().Add(-gcAge)
+	linked := make(map[string]bool)
+	groups := make(map[string][]*version)
+	versions := make(map[string]*version) // by certificate DER
+	for _, fi := range ff {
+		path := filepath.Join(dir, fi.Name())
+		if strings.HasSuffix(path, ".tmp") && !fi.IsDir() && fi.ModTime().Before(cutoff) {
+			if err := r.remove(path, "stale temporary file"); err != nil {
+				return err
+			}
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Readlink(path); err == nil {
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				linked[filepath.Clean(target)] = true
+			}
+			continue
+		}
+		ext := filepath.Ext(fi.Name())
+		if !fi.Mode().IsRegular() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+		leaf, err := readLeaf(path)
+		if err != nil {
+			continue // not a certificate, e.g. a key in .pem file
+		}
+		names := leaf.DNSNames
+		if cn := leaf.Subject.CommonName; cn != "" {
+			names = append([]string{cn}, names...)
+		}
+		if v := versions[string(leaf.Raw)]; v != nil {
+			v.paths = append(v.paths, path)
+			continue
+		}
+		v := &version{paths: []string{path}, notBefore: leaf.NotBefore}
+		versions[string(leaf.Raw)] = v
+		k := strings.Join(uniqueSorted(names), " ")
+		groups[k] = append(groups[k], v)
+	}
+	var keys []string
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vv := groups[k]
+		sort.SliceStable(vv, func(i, j int) bool { return vv[i].notBefore.After(vv[j].notBefore) })
+		for i := keep; i < len(vv); i++ {
+			isLinked := false
+			for _, path := range vv[i].paths {
+				isLinked = isLinked || linked[path]
+			}
+			if isLinked {
+				continue
+			}
+			for _, path := range vv[i].paths {
+				if err := r.remove(path, "superseded certificate for "+k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGCCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	create := func(name string, age int, dnsNames ...string) {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(age)),
+			DNSNames:     dnsNames,
+			NotBefore:    now.Add(-time.Duration(age) * time.Hour),
+			NotAfter:     now.Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pemCerts([][]byte{der}), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create("1.crt", 1, "example.com", "www.example.com")
+	create("2.crt", 2, "www.example.com", "example.com")
+	create("3.crt", 3, "example.com", "www.example.com")
+	create("4.crt", 4, "example.com", "www.example.com")
+	create("5.pem", 5, "example.com", "www.example.com") // linked
+	create("6.crt", 6, "example.org")
+	if err := os.Symlink("5.pem", filepath.Join(dir, "current.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKey(filepath.Join(dir, "key.pem"), key); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "out.crt.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := now.Add(-2 * gcAge)
+	if err := os.Chtimes(tmp, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &gcReport{DryRun: true}
+	if err := gcCerts(r, dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Items) != 3 {
+		t.Errorf("dry run: %d items; want 3", len(r.Items))
+	}
+	r = &gcReport{}
+	if err := gcCerts(r, dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, f := range ff {
+		left = append(left, filepath.Base(f))
+	}
+	sort.Strings(left)
+	if want := []string{"1.crt", "2.crt", "5.pem", "6.crt", "current.pem", "key.pem"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("left %q; want %q", left, want)
+	}
+	if r.Bytes == 0 {
+		t.Error("r.Bytes = 0")
+	}
+}
+
+func TestGCCertsCopies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	var certs [][]byte
+	for age := 1; age <= 3; age++ {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(age)),
+			DNSNames:     []string{"example.com"},
+			NotBefore:    now.Add(-time.Duration(age) * time.Hour),
+			NotAfter:     now.Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, der)
+	}
+	files := map[string][][]byte{
+		"1.crt":         {certs[0]},
+		"2.crt":         {certs[1]},
+		"3.crt":         {certs[2]},
+		"fullchain.pem": {certs[0], certs[2]}, // the newest one with a chain
+		"leaf.pem":      {certs[0]},
+	}
+	for name, chain := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pemCerts(chain), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &gcReport{}
+	if err := gcCerts(r, dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, f := range ff {
+		left = append(left, filepath.Base(f))
+	}
+	sort.Strings(left)
+	if want := []string{"1.crt", "fullchain.pem", "leaf.pem"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("left %q; want %q", left, want)
+	}
+}
+
+func TestGCTemp(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	dir, err := ioutil.TempDir("", "acme-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = filepath.Join(dir, "config")
+	tmpDir := filepath.Join(dir, "tmp")
+	old := time.Now().Add(-2 * gcAge)
+	files := map[string]bool{ // path: whether it should be removed
+		filepath.Join(configDir, "ca", "staging", "link.tmp"):        true,
+		filepath.Join(configDir, "fresh.tmp"):                        false,
+		filepath.Join(configDir, "example.com.crt"):                  false,
+		filepath.Join(tmpDir, challengeFilePrefix+"example.com-123"): true,
+		filepath.Join(tmpDir, "other"):                               false,
+	}
+	for path, stale := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if stale || filepath.Ext(path) == ".crt" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	now := time.Now()
+	state := authzState{
+		"a.example.com": {URI: "https://ca/authz/a", Expires: now.Add(-time.Minute)},
+		"b.example.com": {URI: "https://ca/authz/b", Expires: now.Add(time.Hour)},
+		"c.example.com": {URI: "https://ca/authz/c"}, // pending
+	}
+	if err := writeAuthzState(configDir, state); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &gcReport{}
+	if err := gcTemp(r, configDir, tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := gcAuthz(r); err != nil {
+		t.Fatal(err)
+	}
+	for path, stale := range files {
+		if _, err := os.Stat(path); os.IsNotExist(err) != stale {
+			t.Errorf("%s: exists = %v", path, err == nil)
+		}
+	}
+	if state, err = readAuthzState(configDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state["a.example.com"]; ok || len(state) != 2 {
+		t.Errorf("authz state = %v", state)
+	}
+	if len(r.Items) != 3 || r.Bytes != 2 {
+		t.Errorf("%d items of %d bytes; want 3 of 2", len(r.Items), r.Bytes)
+	}
+}
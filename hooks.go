@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	certSuccessHook string // -success-hook
+	certFailureHook string // -failure-hook
+
+	// hookTimeout limits the run time of a hook; zero means no limit.
+	hookTimeout = 5 * time.Minute // -hook-timeout
+	// hookUser is the user name or ID to run hooks as, if not empty.
+	hookUser string // -hook-user
+)
+
+// maxHookOutput limits the hook output recorded in the audit log.
+const maxHookOutput = 16 << 10
+
+// hookShell is the command a hook is run with, followed by the hook.
+var hookShell = []string{"/bin/sh", "-c"}
+
+// successEnv returns the environment of -success-hook for a certificate
+// of domains sans written to certPath, with the key in keyPath,
+// obtained after the given number of issuance attempts.
+// The names follow certbot deploy hooks, with additional ACME_ ones.
+func successEnv(sans []string, certPath, keyPath string, attempts int) []string {
+	return []string{
+		"RENEWED_DOMAINS=" + strings.Join(sans, " "),
+		"RENEWED_LINEAGE=" + filepath.Dir(certPath),
+		"ACME_CERT_FILE=" + certPath,
+		"ACME_KEY_FILE=" + keyPath,
+		"ACME_ATTEMPTS=" + strconv.Itoa(attempts),
+	}
+}
+
+// failureEnv returns the environment of -failure-hook for a certificate
+// of domains sans which could not be obtained in the given number
+// of issuance attempts, the last of which failed with err.
+func failureEnv(sans []string, attempts int, err error) []string {
+	return []string{
+		"FAILED_DOMAINS=" + strings.Join(sans, " "),
+		"ACME_ATTEMPTS=" + strconv.Itoa(attempts),
+		"ACME_ERROR=" + err.Error(),
+	}
+}
+
+// runHook runs hook with hookShell, adding env to the environment,
+// for up to hookTimeout and as hookUser, if set. On timeout, the processes
+// the hook started are killed with it. The hook output is copied
+// to the standard error and recorded in the audit log with the outcome.
+func runHook(hook string, env []string) error {
+	ctx := context.Background()
+	if hookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hookTimeout)
+		defer cancel()
+	}
+	args := append(append([]string(nil), hookShell[1:]...), hook)
+	cmd := exec.CommandContext(ctx, hookShell[0], args...)
+	cmd.Env = append(os.Environ(), env...)
+	// kill the processes started by the hook with it;
+	// those leaving its group may keep its output open
+	killHookGroup(cmd)
+	cmd.WaitDelay = time.Second
+	out := &hookOutput{}
+	cmd.Stdout = io.MultiWriter(os.Stderr, out)
+	cmd.Stderr = cmd.Stdout
+	if hookUser != "" {
+		if err := setHookUser(cmd, hookUser); err != nil {
+			return fmt.Errorf("-hook-user: %v", err)
+		}
+	}
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("-hook-timeout of %v exceeded", hookTimeout)
+	}
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	if aerr := writeAudit("hook %q: %s; output %q", hook, status, out.buf.String()); aerr != nil {
+		logf("%s: %v", auditFile, aerr)
+	}
+	if err != nil {
+		return fmt.Errorf("%q: %v", hook, err)
+	}
+	return nil
+}
+
+// hookOutput is a writer which keeps up to maxHookOutput bytes
+// and discards the rest.
+type hookOutput struct {
+	buf bytes.Buffer
+}
+
+func (o *hookOutput) Write(b []byte) (int, error) {
+	if n := maxHookOutput - o.buf.Len(); n > 0 {
+		if len(b) > n {
+			o.buf.Write(b[:n])
+		} else {
+			o.buf.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+// lookupUser resolves a user name or ID into user and primary group IDs.
+func lookupUser(name string) (uid, gid int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, err
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// certIssued runs -success-hook, if any, for a certificate written
+// to certPath, with the key in keyPath, if any.
+// A hook failure is reported but does not stop the command.
+func certIssued(sans []string, certPath, keyPath string, attempts int) {
+	if certSuccessHook == "" {
+		return
+	}
+	if err := runHook(certSuccessHook, successEnv(sans, certPath, keyPath, attempts)); err != nil {
+		errorf("-success-hook: %v", err)
+	}
+}
+
+// certFailed runs -failure-hook, if any, and returns err.
+func certFailed(sans []string, attempts int, err error) error {
+	if certFailureHook != "" {
+		if herr := runHook(certFailureHook, failureEnv(sans, attempts, err)); herr != nil {
+			errorf("-failure-hook: %v", herr)
+		}
+	}
+	return err
+}
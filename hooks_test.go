@@ -0,0 +1,111 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook test uses sh")
+	}
+	dir, err := ioutil.TempDir("", "acme-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { configDir = dir }(configDir)
+	configDir = dir
+	out := filepath.Join(dir, "out")
+	certPath := filepath.Join(dir, "example.com.crt")
+
+	env := successEnv([]string{"example.com", "www.example.com"}, certPath, "/k.key", 2)
+	hook := `echo "$RENEWED_DOMAINS|$RENEWED_LINEAGE|$ACME_CERT_FILE|$ACME_KEY_FILE|$ACME_ATTEMPTS" > ` + out
+	if err := runHook(hook, env); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "example.com www.example.com|" + dir + "|" + certPath + "|/k.key|2\n"
+	if string(b) != want {
+		t.Errorf("success hook env: %q; want %q", b, want)
+	}
+
+	env = failureEnv([]string{"example.com"}, 1, errors.New("rate limited"))
+	hook = `echo "$FAILED_DOMAINS|$ACME_ATTEMPTS|$ACME_ERROR" > ` + out
+	if err := runHook(hook, env); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "example.com|1|rate limited\n"; string(b) != want {
+		t.Errorf("failure hook env: %q; want %q", b, want)
+	}
+
+	if err := runHook("echo failing; exit 3", nil); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("runHook(exit 3): %v", err)
+	}
+	defer func(d time.Duration) { hookTimeout = d }(hookTimeout)
+	hookTimeout = 100 * time.Millisecond
+	pidFile := filepath.Join(dir, "pid")
+	if err := runHook("sleep 10 & echo $! > "+pidFile+"; wait", nil); err == nil || !strings.Contains(err.Error(), "-hook-timeout") {
+		t.Errorf("runHook(sleep 10): %v", err)
+	}
+	if b, err = ioutil.ReadFile(pidFile); err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the killed child may take a moment to exit,
+	// and be left a zombie until reaped by init
+	for i := 0; ; i++ {
+		b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(b), ") Z ") {
+			break
+		}
+		if i == 100 {
+			t.Errorf("process %d started by the hook is still running", pid)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, auditFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("audit log has %d lines; want 4:\n%s", len(lines), b)
+	}
+	if want := `"echo failing; exit 3": exit status 3; output "failing\n"`; !strings.Contains(lines[2], want) {
+		t.Errorf("audit line %q does not contain %q", lines[2], want)
+	}
+	if !strings.Contains(lines[3], "-hook-timeout of 100ms exceeded") {
+		t.Errorf("audit line %q does not report the timeout", lines[3])
+	}
+}
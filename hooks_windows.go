@@ -0,0 +1,31 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func init() {
+	hookShell = []string{"cmd", "/C"}
+}
+
+// setHookUser makes cmd run as user name, which is not supported on Windows.
+func setHookUser(cmd *exec.Cmd, name string) error {
+	return fmt.Errorf("hooks cannot run as another user on %s", runtime.GOOS)
+}
+
+// killHookGroup does nothing on Windows, where only the hook process
+// itself is killed when the context of cmd is done.
+func killHookGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,281 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	cmdImportKey = &command{
+		run:       runImportKey,
+		UsageLine: "import-key [-c config] [-ca name] [-d url] [-uri url] -format certbot|lego|acmesh path",
+		Short:     "import account from another ACME client",
+		Long: `
+Import-key converts an account key of another ACME client, specified
+with -format argument, and stores it as {{.AccountKey}} in the config dir,
+or the account dir of -ca account. The path argument is the key file:
+
+	certbot: accounts/<server>/directory/<id>/private_key.json (JWK)
+	lego:    accounts/<server>/<email>/keys/<email>.key
+	acmesh:  ca/<server>/account.key in acme.sh home dir
+
+The account URI is read from the client's own metadata next to the key:
+regr.json for certbot, account.json for lego and ca.conf for acme.sh.
+The -uri argument specifies it explicitly.
+
+The account is then fetched from the CA, specified with -d argument
+as for the reg command, to verify that the key belongs to it,
+and {{.AccountFile}} is written. Existing account files are not overwritten.
+
+Default location of the config dir is {{.ConfigDir}}.
+		`,
+	}
+
+	importDisco  discoAliasFlag // defaults as for reg command
+	importURI    string
+	importFormat string
+)
+
+func init() {
+	cmdImportKey.flag.Var(&importDisco, "d", "")
+	cmdImportKey.flag.StringVar(&importURI, "uri", "", "")
+	cmdImportKey.flag.StringVar(&importFormat, "format", "", "")
+}
+
+func runImportKey(args []string) {
+	if len(args) != 1 {
+		fatalf("import-key requires exactly one key file argument")
+	}
+	if importDisco == "" {
+		importDisco = defaultDiscoFlag
+		if a, ok := discoAliases[flagCA]; ok {
+			importDisco = discoAliasFlag(a)
+		}
+	}
+	path := args[0]
+	var (
+		key crypto.Signer
+		uri string
+		err error
+	)
+	switch importFormat {
+	case "certbot":
+		key, uri, err = importCertbot(path)
+	case "lego":
+		key, uri, err = importLego(path)
+	case "acmesh":
+		key, uri, err = importAcmesh(path)
+	case "":
+		fatalf("-format is required")
+	default:
+		fatalf("-format: unknown format %q", importFormat)
+	}
+	if err != nil {
+		fatalf("%s: %v", path, err)
+	}
+	if importURI != "" {
+		uri = importURI
+	}
+	if uri == "" {
+		fatalf("%s: account URI not found; specify -uri", path)
+	}
+	if err := checkFIPSKey(key.Public()); err != nil {
+		fatalf("account key: %v", err)
+	}
+	if err := checkAccountKey(key.Public()); err != nil {
+		fatalf("account key: %v", err)
+	}
+	if err := checkKeyStrength(key.Public()); err != nil {
+		fatalf("account key: %v", err)
+	}
+
+	kp := filepath.Join(accountDir(), accountKey)
+	for _, p := range []string{kp, filepath.Join(accountDir(), accountFile)} {
+		if _, err := os.Stat(p); err == nil {
+			fatalf("%s already exists", p)
+		}
+	}
+
+	client, err := newClient(key, string(importDisco))
+	if err != nil {
+		fatalf("-d: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	fp, err := discoverCA(ctx, client)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	a, err := client.GetReg(ctx, uri)
+	if err != nil {
+		fatalf("%s: %v", uri, err)
+	}
+
+	if err := os.MkdirAll(accountDir(), 0700); err != nil {
+		fatalf("%v", err)
+	}
+	if err := writeKey(kp, key); err != nil {
+		fatalf("account key: %v", err)
+	}
+	uc := &userConfig{Account: *a, CA: string(importDisco), CAFingerprint: fp, key: key}
+	if err := writeConfig(uc); err != nil {
+		fatalf("write config: %v", err)
+	}
+	printAccount(os.Stdout, &uc.Account, kp)
+}
+
+// importCertbot reads a certbot account key in JWK format
+// and the account URI from regr.json in the same dir.
+func importCertbot(path string) (crypto.Signer, string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := parseJWK(b)
+	if err != nil {
+		return nil, "", err
+	}
+	var regr struct {
+		URI string `json:"uri"`
+	}
+	if b, err := ioutil.ReadFile(sameDir(path, "regr.json")); err == nil {
+		if err := json.Unmarshal(b, &regr); err != nil {
+			return nil, "", fmt.Errorf("regr.json: %v", err)
+		}
+	}
+	return key, regr.URI, nil
+}
+
+// importLego reads a lego account key in PEM format
+// and the account URI from account.json in the parent dir.
+func importLego(path string) (crypto.Signer, string, error) {
+	key, err := readKey(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var account struct {
+		Registration struct {
+			URI string `json:"uri"`
+		} `json:"registration"`
+	}
+	p := filepath.Join(filepath.Dir(filepath.Dir(path)), "account.json")
+	if b, err := ioutil.ReadFile(p); err == nil {
+		if err := json.Unmarshal(b, &account); err != nil {
+			return nil, "", fmt.Errorf("account.json: %v", err)
+		}
+	}
+	return key, account.Registration.URI, nil
+}
+
+// importAcmesh reads an acme.sh account key in PEM format
+// and the account URI from ACCOUNT_URL in ca.conf in the same dir.
+func importAcmesh(path string) (crypto.Signer, string, error) {
+	key, err := readKey(path)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := os.Open(sameDir(path, "ca.conf"))
+	if os.IsNotExist(err) {
+		return key, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	var uri string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "ACCOUNT_URL=") {
+			uri = strings.Trim(strings.TrimPrefix(line, "ACCOUNT_URL="), `'"`)
+		}
+	}
+	return key, uri, s.Err()
+}
+
+// parseJWK parses a private RSA or EC key in JSON Web Key format, RFC 7517.
+func parseJWK(b []byte) (crypto.Signer, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		// RSA
+		N, E, D, P, Q string
+		// EC
+		Crv, X, Y string
+	}
+	if err := json.Unmarshal(b, &jwk); err != nil {
+		return nil, err
+	}
+	var bad bool
+	num := func(s string) *big.Int {
+		v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(v) == 0 {
+			bad = true
+		}
+		return new(big.Int).SetBytes(v)
+	}
+	switch jwk.Kty {
+	case "RSA":
+		k := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: num(jwk.N), E: int(num(jwk.E).Int64())},
+			D:         num(jwk.D),
+			Primes:    []*big.Int{num(jwk.P), num(jwk.Q)},
+		}
+		if bad {
+			return nil, errors.New("invalid RSA JWK")
+		}
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+		k.Precompute()
+		return k, nil
+	case "EC":
+		var c elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			c = elliptic.P256()
+		case "P-384":
+			c = elliptic.P384()
+		case "P-521":
+			c = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		k := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: c, X: num(jwk.X), Y: num(jwk.Y)},
+			D:         num(jwk.D),
+		}
+		if bad {
+			return nil, errors.New("invalid EC JWK")
+		}
+		if x, y := c.ScalarBaseMult(k.D.Bytes()); x.Cmp(k.X) != 0 || y.Cmp(k.Y) != 0 {
+			return nil, errors.New("EC JWK public and private parts do not match")
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
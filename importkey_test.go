@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseJWK(t *testing.T) {
+	b64 := func(n *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(n.Bytes())
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK := fmt.Sprintf(`{"kty":"RSA","n":%q,"e":"AQAB","d":%q,"p":%q,"q":%q}`,
+		b64(rsaKey.N), b64(rsaKey.D), b64(rsaKey.Primes[0]), b64(rsaKey.Primes[1]))
+	k, err := parseJWK([]byte(rsaJWK))
+	if err != nil {
+		t.Fatalf("RSA: %v", err)
+	}
+	if !reflect.DeepEqual(k.Public(), rsaKey.Public()) {
+		t.Error("RSA: public keys differ")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK := fmt.Sprintf(`{"kty":"EC","crv":"P-256","x":%q,"y":%q,"d":%q}`,
+		b64(ecKey.X), b64(ecKey.Y), b64(ecKey.D))
+	k, err = parseJWK([]byte(ecJWK))
+	if err != nil {
+		t.Fatalf("EC: %v", err)
+	}
+	if !reflect.DeepEqual(k.Public(), ecKey.Public()) {
+		t.Error("EC: public keys differ")
+	}
+
+	bad := fmt.Sprintf(`{"kty":"EC","crv":"P-256","x":%q,"y":%q,"d":"AQ"}`, b64(ecKey.X), b64(ecKey.Y))
+	if _, err := parseJWK([]byte(bad)); err == nil {
+		t.Error("mismatched EC JWK: no error")
+	}
+	if _, err := parseJWK([]byte(`{"kty":"oct","k":"AQ"}`)); err == nil {
+		t.Error("symmetric JWK: no error")
+	}
+}
+
+func TestImportAcmesh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// acme.sh with OpenSSL 3 writes PKCS#8 keys
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "account.key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := "ACCOUNT_KEY_HASH='abc'\nACCOUNT_URL='https://ca/acme/reg/1'\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.conf"), []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, uri, err := importAcmesh(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != "https://ca/acme/reg/1" {
+		t.Errorf("uri = %q", uri)
+	}
+	if !reflect.DeepEqual(k.Public(), key.Public()) {
+		t.Error("public keys differ")
+	}
+
+	// converted key is stored in the traditional RSA format
+	out := filepath.Join(dir, "converted.key")
+	if err := writeKey(out, k); err != nil {
+		t.Fatal(err)
+	}
+	k2, err := readKey(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(k2.Public(), key.Public()) {
+		t.Error("written key differs")
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var cmdInit = &command{
+	run:       runInit,
+	UsageLine: "init [-c config] [-ca name]",
+	Short:     "set up an account interactively",
+	Long: `
+Init walks through the first-time setup of an account:
+choosing a CA, generating or importing an account key, providing
+a contact email, accepting the CA's Terms of Service and selecting
+the default challenge method of the cert command.
+
+The connection to the CA is tested before anything is written.
+At the end, the account is registered and only then is the config dir
+written, including {{.AccountFile}}, {{.AccountKey}} and {{.SettingsFile}}
+with the chosen challenge method. If the registration fails, or the
+Terms of Service are declined, nothing is written and init can be run
+again.
+
+Init refuses to overwrite an existing account. Use update and contact
+commands to change one.
+
+Default location of the config dir is {{.ConfigDir}}.
+See also: acme help account.
+	`,
+}
+
+// initMethods maps challenge methods offered by init to cert settings.
+var initMethods = map[string]map[string]interface{}{
+	"http-01": nil, // the default, served by cert command
+	"dns-01":  {"dns": true},
+	"manual":  {"manual": true},
+}
+
+// initAnswers are the choices made in the init dialog.
+type initAnswers struct {
+	disco   string // CA directory URL
+	keyPath string // account key file to import; empty to generate one
+	contact string // mailto: URI, or empty
+	method  string // one of initMethods keys
+}
+
+func runInit(args []string) {
+	if len(args) != 0 {
+		fatalf("init: unexpected arguments %q", args)
+	}
+	dir := accountDir()
+	for _, name := range []string{accountFile, accountKey} {
+		if p := filepath.Join(dir, name); fileExists(p) {
+			fatalf("%s already exists", p)
+		}
+	}
+	in := bufio.NewReader(os.Stdin)
+	ans, err := askInit(in, os.Stdout)
+	if err != nil {
+		fatalf("init: %v", err)
+	}
+
+	var key crypto.Signer
+	if ans.keyPath != "" {
+		if key, err = readAccountKey(ans.keyPath); err != nil {
+			fatalf("account key: %v", err)
+		}
+	} else if key, err = generateKey(""); err != nil {
+		fatalf("account key: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	client, err := newClient(key, ans.disco)
+	if err != nil {
+		fatalf("%s: %v", ans.disco, err)
+	}
+	fmt.Printf("Connecting to %s...\n", ans.disco)
+	fp, err := discoverCA(ctx, client)
+	if err != nil {
+		fatalf("%s: %v", ans.disco, err)
+	}
+
+	uc := &userConfig{CA: ans.disco, CAFingerprint: fp, key: key}
+	if ans.contact != "" {
+		uc.Contact = []string{ans.contact}
+	}
+	acceptTOS := func(tos string) bool {
+		a, err := ask(in, os.Stdout, "Do you accept the CA Terms of Service at "+tos+"?", "n", yesNo)
+		return err == nil && strings.HasPrefix(strings.ToLower(a), "y")
+	}
+	if err := registerInit(ctx, client, uc, acceptTOS); err != nil {
+		fatalf("init: %v", err)
+	}
+	kp := filepath.Join(dir, accountKey)
+	if err := writeInit(uc, kp, ans.method); err != nil {
+		fatalf("init: %v", err)
+	}
+	printAccount(os.Stdout, &uc.Account, kp)
+	fmt.Println("\nDone. Request a certificate with: acme cert <domain>")
+}
+
+// errTOSDeclined is returned by registerInit
+// if the CA Terms of Service are not accepted.
+var errTOSDeclined = errors.New("the CA Terms of Service were not accepted; nothing was written")
+
+// registerInit registers the account of uc with the CA of client,
+// recording the registration in uc. Unlike client.Register, which
+// registers the account whether or not the Terms of Service are
+// accepted, it asks acceptTOS about the terms of the CA directory first
+// and returns errTOSDeclined without registering if they are declined.
+// Terms the CA returns only at registration are asked about too;
+// declining them leaves the account registered but unused.
+func registerInit(ctx context.Context, client *acme.Client, uc *userConfig, acceptTOS func(string) bool) error {
+	dir, err := client.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if dir.Terms != "" && !acceptTOS(dir.Terms) {
+		return errTOSDeclined
+	}
+	var declined bool
+	prompt := func(tos string) bool {
+		if tos == dir.Terms || acceptTOS(tos) {
+			return true
+		}
+		declined = true
+		return false
+	}
+	a, err := client.Register(ctx, &uc.Account, prompt)
+	if err != nil {
+		return err
+	}
+	if declined {
+		return errTOSDeclined
+	}
+	uc.Account = *a
+	return nil
+}
+
+// writeInit writes the account key of uc to kp, its config and
+// the cert settings of the challenge method. It removes the key and
+// the config if any of them cannot be written, so that init can be
+// run again.
+func writeInit(uc *userConfig, kp, method string) (err error) {
+	if err := os.MkdirAll(uc.accountDir(), 0700); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(kp)
+			os.Remove(filepath.Join(uc.accountDir(), accountFile))
+		}
+	}()
+	if err := writeKey(kp, uc.key); err != nil {
+		return fmt.Errorf("account key: %v", err)
+	}
+	if err := writeConfig(uc); err != nil {
+		return fmt.Errorf("write config: %v", err)
+	}
+	if err := writeInitSettings(filepath.Join(uc.accountDir(), settingsFile), method); err != nil {
+		return fmt.Errorf("%s: %v", settingsFile, err)
+	}
+	return nil
+}
+
+// readAccountKey reads the key to import as the account key from path,
+// checking it can be used as one.
+func readAccountKey(path string) (crypto.Signer, error) {
+	key, err := readKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFIPSKey(key.Public()); err != nil {
+		return nil, err
+	}
+	if err := checkAccountKey(key.Public()); err != nil {
+		return nil, err
+	}
+	if err := checkKeyStrength(key.Public()); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// askInit conducts the init dialog, reading answers from r
+// and writing questions to w.
+func askInit(r *bufio.Reader, w io.Writer) (*initAnswers, error) {
+	ans := &initAnswers{}
+	var aliases []string
+	for a := range discoAliases {
+		aliases = append(aliases, a)
+	}
+	sort.Strings(aliases)
+	def := defaultDisco
+	if _, ok := discoAliases[flagCA]; ok {
+		def = flagCA
+	}
+	q := "CA directory URL, or one of " + strings.Join(aliases, ", ")
+	v, err := ask(r, w, q, def, func(v string) error {
+		if _, ok := discoAliases[v]; ok {
+			return nil
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return errors.New("not a known alias or an http(s) URL")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ans.disco = v
+	if a, ok := discoAliases[v]; ok {
+		ans.disco = a
+	}
+
+	v, err = ask(r, w, "Account key file to import, or empty to generate a new key", "", func(v string) error {
+		if v == "" {
+			return nil
+		}
+		_, err := readAccountKey(v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ans.keyPath = v
+
+	v, err = ask(r, w, "Contact email, or empty for none", "", func(v string) error {
+		if v == "" {
+			return nil
+		}
+		_, err := normalizeContact(v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		ans.contact, _ = normalizeContact(v)
+	}
+
+	var methods []string
+	for m := range initMethods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	v, err = ask(r, w, "Default challenge method, one of "+strings.Join(methods, ", "), "http-01", func(v string) error {
+		if _, ok := initMethods[v]; !ok {
+			return fmt.Errorf("unknown method %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ans.method = v
+	return ans, nil
+}
+
+// ask writes question q with default answer def to w and reads
+// an answer line from r, repeating the question until valid accepts it.
+// An empty answer is taken as def.
+func ask(r *bufio.Reader, w io.Writer, q, def string, valid func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(w, "%s [%s]: ", q, def)
+		} else {
+			fmt.Fprintf(w, "%s: ", q)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		v := strings.TrimSpace(line)
+		if v == "" {
+			v = def
+		}
+		if err := valid(v); err != nil {
+			fmt.Fprintf(w, "%v\n", err)
+			continue
+		}
+		return v, nil
+	}
+}
+
+// yesNo accepts y, yes, n and no answers.
+func yesNo(v string) error {
+	switch strings.ToLower(v) {
+	case "y", "yes", "n", "no":
+		return nil
+	}
+	return errors.New("answer y or n")
+}
+
+// writeInitSettings stores cert settings of the challenge method
+// in the settings file at path, keeping other settings.
+func writeInitSettings(path, method string) error {
+	set := initMethods[method]
+	if len(set) == 0 {
+		return nil
+	}
+	s, err := readSettings(path)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		s = make(commandSettings)
+	}
+	if s["cert"] == nil {
+		s["cert"] = make(map[string]interface{})
+	}
+	for k, v := range set {
+		s["cert"][k] = v
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
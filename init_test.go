@@ -0,0 +1,192 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAskInit(t *testing.T) {
+	input := strings.Join([]string{
+		"ftp://ca", // invalid, asked again
+		"letsencrypt-staging",
+		"",         // generate a key
+		"not-mail", // invalid, asked again
+		"admin@example.com",
+		"dns-01",
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	ans, err := askInit(bufio.NewReader(strings.NewReader(input)), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &initAnswers{
+		disco:   discoAliases["letsencrypt-staging"],
+		contact: "mailto:admin@example.com",
+		method:  "dns-01",
+	}
+	if !reflect.DeepEqual(ans, want) {
+		t.Errorf("askInit = %+v; want %+v", ans, want)
+	}
+	if n := strings.Count(out.String(), "CA directory URL"); n != 2 {
+		t.Errorf("CA question asked %d times; want 2", n)
+	}
+
+	// defaults only
+	ans, err = askInit(bufio.NewReader(strings.NewReader("\n\n\n\n")), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &initAnswers{disco: discoAliases[defaultDisco], method: "http-01"}
+	if !reflect.DeepEqual(ans, want) {
+		t.Errorf("askInit = %+v; want %+v", ans, want)
+	}
+
+	if _, err := askInit(bufio.NewReader(strings.NewReader("")), &out); err == nil {
+		t.Error("askInit with no input: no error")
+	}
+}
+
+func TestWriteInitSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, settingsFile)
+	if err := writeInitSettings(path, "http-01"); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(path) {
+		t.Errorf("%s written for the default method", path)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"cert":{"renew-before":"720h"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInitSettings(path, "dns-01"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := readSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := commandSettings{"cert": {"renew-before": "720h", "dns": true}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("settings = %v; want %v", s, want)
+	}
+}
+
+func TestRegisterInit(t *testing.T) {
+	var regs int
+	var fail bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		base := "http://" + r.Host
+		switch {
+		case r.Method == "HEAD":
+		case r.Method != "POST":
+			fmt.Fprintf(w, `{"new-reg":"%[1]s/reg","new-authz":"%[1]s/authz","new-cert":"%[1]s/cert","revoke-cert":"%[1]s/revoke","meta":{"terms-of-service":"%[1]s/tos"}}`, base)
+		case fail:
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"type":"urn:acme:error:serverInternal","detail":"down"}`)
+		default:
+			regs++
+			w.Header().Set("Location", base+"/reg/1")
+			w.Header().Add("Link", fmt.Sprintf("<%s/tos>;rel=\"terms-of-service\"", base))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer ts.Close()
+	key, err := generateKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newClient(key, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	uc := &userConfig{key: key}
+	decline := func(string) bool { return false }
+	if err := registerInit(ctx, client, uc, decline); err != errTOSDeclined || regs != 0 {
+		t.Errorf("declined: registerInit: %v, %d registrations; want %v, none", err, regs, errTOSDeclined)
+	}
+
+	fail = true
+	var asked int
+	accept := func(tos string) bool {
+		asked++
+		return tos == ts.URL+"/tos"
+	}
+	if err := registerInit(ctx, client, uc, accept); err == nil {
+		t.Error("failed registration: no error")
+	}
+
+	fail = false
+	asked = 0
+	if err := registerInit(ctx, client, uc, accept); err != nil {
+		t.Fatal(err)
+	}
+	if uc.URI != ts.URL+"/reg/1" || asked != 1 {
+		t.Errorf("uc.URI = %q, terms asked %d times; want %q, once", uc.URI, asked, ts.URL+"/reg/1")
+	}
+}
+
+func TestWriteInitCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := generateKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unreadable settings file fails the last step
+	if err := ioutil.WriteFile(filepath.Join(dir, settingsFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	uc := &userConfig{key: key, dir: dir}
+	kp := filepath.Join(dir, accountKey)
+	if err := writeInit(uc, kp, "dns-01"); err == nil {
+		t.Fatal("writeInit: no error")
+	}
+	for _, p := range []string{kp, filepath.Join(dir, accountFile)} {
+		if fileExists(p) {
+			t.Errorf("%s left after a failed init", p)
+		}
+	}
+
+	os.Remove(filepath.Join(dir, settingsFile))
+	if err := writeInit(uc, kp, "dns-01"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{kp, filepath.Join(dir, accountFile), filepath.Join(dir, settingsFile)} {
+		if !fileExists(p) {
+			t.Errorf("%s not written", p)
+		}
+	}
+}
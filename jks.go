@@ -0,0 +1,147 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Java KeyStore (JKS) format constants, as in sun.security.provider.JavaKeyStore.
+const (
+	jksMagic      = 0xfeedfeed
+	jksVersion    = 2
+	jksPrivateKey = 1
+	jksCertType   = "X.509"
+	// jksWhitener is mixed into the keystore integrity digest.
+	jksWhitener = "Mighty Aphrodite"
+)
+
+// oidJKSKeyProtector identifies Sun's proprietary key protection algorithm.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// encodeJKS creates a Java KeyStore containing a single private key entry
+// named alias, with key and its DER encoded certificate chain,
+// protected with password.
+func encodeJKS(alias string, key crypto.Signer, chain [][]byte, password string, date time.Time) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("jks: empty certificate chain")
+	}
+	pkey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pass := jksPassword(password)
+	protected, err := jksProtectKey(pkey, pass)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v) // never fails on bytes.Buffer
+	}
+	w(uint32(jksMagic))
+	w(uint32(jksVersion))
+	w(uint32(1)) // number of entries
+	w(uint32(jksPrivateKey))
+	jksWriteUTF(&buf, alias)
+	w(date.UnixNano() / int64(time.Millisecond))
+	w(uint32(len(protected)))
+	buf.Write(protected)
+	w(uint32(len(chain)))
+	for _, c := range chain {
+		jksWriteUTF(&buf, jksCertType)
+		w(uint32(len(c)))
+		buf.Write(c)
+	}
+
+	md := sha1.New()
+	md.Write(pass)
+	md.Write([]byte(jksWhitener))
+	md.Write(buf.Bytes())
+	buf.Write(md.Sum(nil))
+	return buf.Bytes(), nil
+}
+
+// jksProtectKey encrypts PKCS#8 encoded key with Sun's key protector
+// and returns it as DER encoded EncryptedPrivateKeyInfo.
+func jksProtectKey(key, pass []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// the key is XOR-ed with a chain of SHA-1 digests seeded with salt
+	enc := make([]byte, len(key))
+	digest := salt
+	for off := 0; off < len(key); off += sha1.Size {
+		md := sha1.New()
+		md.Write(pass)
+		md.Write(digest)
+		digest = md.Sum(nil)
+		for i := 0; i < sha1.Size && off+i < len(key); i++ {
+			enc[off+i] = key[off+i] ^ digest[i]
+		}
+	}
+	md := sha1.New()
+	md.Write(pass)
+	md.Write(key)
+
+	var data []byte
+	data = append(data, salt...)
+	data = append(data, enc...)
+	data = md.Sum(data)
+	return asn1.Marshal(struct {
+		Algo pkix.AlgorithmIdentifier
+		Data []byte
+	}{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  oidJKSKeyProtector,
+			Parameters: asn1.NullRawValue,
+		},
+		Data: data,
+	})
+}
+
+// jksPassword converts password to bytes the way JKS does:
+// each UTF-16 code unit as two big-endian bytes.
+func jksPassword(password string) []byte {
+	var b []byte
+	for _, r := range password {
+		if r >= 0x10000 {
+			r -= 0x10000
+			hi, lo := 0xd800+(r>>10), 0xdc00+(r&0x3ff)
+			b = append(b, byte(hi>>8), byte(hi), byte(lo>>8), byte(lo))
+			continue
+		}
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+// jksWriteUTF writes s in Java DataOutput.writeUTF format:
+// two bytes length followed by the string bytes.
+// Only strings without NUL and supplementary characters are encoded
+// identically to Java's modified UTF-8, which is the case for aliases
+// and type names used here.
+func jksWriteUTF(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestEncodeJKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := [][]byte{[]byte("leaf"), []byte("ca")}
+	date := time.Unix(1476576000, 0)
+	b, err := encodeJKS("example.com", key, chain, "changeit", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// integrity digest
+	pass := jksPassword("changeit")
+	data, sum := b[:len(b)-sha1.Size], b[len(b)-sha1.Size:]
+	md := sha1.New()
+	md.Write(pass)
+	md.Write([]byte(jksWhitener))
+	md.Write(data)
+	if !bytes.Equal(md.Sum(nil), sum) {
+		t.Fatal("integrity digest mismatch")
+	}
+
+	r := bytes.NewReader(data)
+	var hdr struct{ Magic, Version, Count, Tag uint32 }
+	binary.Read(r, binary.BigEndian, &hdr)
+	if hdr.Magic != jksMagic || hdr.Version != jksVersion || hdr.Count != 1 || hdr.Tag != jksPrivateKey {
+		t.Fatalf("header = %+v", hdr)
+	}
+	if alias := readUTF(r); alias != "example.com" {
+		t.Errorf("alias = %q", alias)
+	}
+	var ms int64
+	binary.Read(r, binary.BigEndian, &ms)
+	if ms != date.Unix()*1000 {
+		t.Errorf("date = %d; want %d", ms, date.Unix()*1000)
+	}
+	protected := make([]byte, readUint32(r))
+	r.Read(protected)
+
+	var info struct {
+		Algo pkix.AlgorithmIdentifier
+		Data []byte
+	}
+	if _, err := asn1.Unmarshal(protected, &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Algo.Algorithm.Equal(oidJKSKeyProtector) {
+		t.Errorf("algorithm = %v", info.Algo.Algorithm)
+	}
+	salt := info.Data[:sha1.Size]
+	enc := info.Data[sha1.Size : len(info.Data)-sha1.Size]
+	plain := make([]byte, len(enc))
+	digest := salt
+	for off := 0; off < len(enc); off += sha1.Size {
+		md := sha1.New()
+		md.Write(pass)
+		md.Write(digest)
+		digest = md.Sum(nil)
+		for i := 0; i < sha1.Size && off+i < len(enc); i++ {
+			plain[off+i] = enc[off+i] ^ digest[i]
+		}
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		t.Fatalf("decrypted key: %v", err)
+	}
+	if !key.Equal(pk) {
+		t.Error("decrypted key does not match")
+	}
+
+	if n := readUint32(r); n != uint32(len(chain)) {
+		t.Fatalf("chain length = %d", n)
+	}
+	for i, c := range chain {
+		if typ := readUTF(r); typ != jksCertType {
+			t.Errorf("%d: type = %q", i, typ)
+		}
+		v := make([]byte, readUint32(r))
+		r.Read(v)
+		if !bytes.Equal(v, c) {
+			t.Errorf("%d: cert = %q; want %q", i, v, c)
+		}
+	}
+}
+
+func readUint32(r *bytes.Reader) uint32 {
+	var n uint32
+	binary.Read(r, binary.BigEndian, &n)
+	return n
+}
+
+func readUTF(r *bytes.Reader) string {
+	var n uint16
+	binary.Read(r, binary.BigEndian, &n)
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b)
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// keyringService is the service name account keys are stored under
+// in the OS keyring.
+const keyringService = "acme"
+
+var (
+	cmdKeyring = &command{
+		run:       runKeyring,
+		UsageLine: "keyring [-c config] [-ca name] [-restore]",
+		Short:     "move account key to OS keyring",
+		Long: `
+Keyring moves the account private key from {{.AccountKey}} file
+into the OS keyring: Secret Service on Linux, accessed with secret-tool,
+Keychain on macOS, and a DPAPI protected file on Windows.
+Subsequent commands retrieve the key from the keyring transparently.
+
+The key file is removed once the key is stored, unless it is the
+{{.AccountKey}} in the config dir shared by accounts selected with -ca.
+
+The -restore flag does the opposite: the key is written back
+to {{.AccountKey}} file in the account dir and removed from the keyring.
+
+Default location of the config dir is {{.ConfigDir}}.
+		`,
+	}
+
+	keyringRestore bool
+)
+
+func init() {
+	cmdKeyring.flag.BoolVar(&keyringRestore, "restore", keyringRestore, "")
+}
+
+func runKeyring([]string) {
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if keyringRestore {
+		restoreKeyring(uc)
+		return
+	}
+	if uc.Keyring {
+		fatalf("account key of %s is already in the keyring", uc.URI)
+	}
+	kp := accountKeyPath()
+	b, err := ioutil.ReadFile(kp)
+	if err != nil {
+		fatalf("account key: %v", err)
+	}
+	if _, err := parseKey(b); err != nil {
+		fatalf("%s: %v", kp, err)
+	}
+	account := keyringAccount(accountDir())
+	if err := keyringSet(account, b); err != nil {
+		fatalf("keyring: %v", err)
+	}
+	// some tools do not report all failures in their exit status
+	if stored, err := keyringGet(account); err != nil || !bytes.Equal(bytes.TrimSpace(stored), bytes.TrimSpace(b)) {
+		fatalf("keyring: the stored key cannot be read back: %v", err)
+	}
+	uc.Keyring = true
+	if err := writeConfig(uc); err != nil {
+		// leave the key file in place; the config still points to it
+		keyringDelete(account)
+		fatalf("write config: %v", err)
+	}
+	if kp != filepath.Join(accountDir(), accountKey) {
+		logf("%s is shared with other accounts; not removing", kp)
+		return
+	}
+	if err := os.Remove(kp); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func restoreKeyring(uc *userConfig) {
+	if !uc.Keyring {
+		fatalf("account key of %s is not in the keyring", uc.URI)
+	}
+	b, err := keyringGet(keyringAccount(accountDir()))
+	if err != nil {
+		fatalf("keyring: %v", err)
+	}
+	kp := filepath.Join(accountDir(), accountKey)
+	if err := ioutil.WriteFile(kp, b, 0600); err != nil {
+		fatalf("%v", err)
+	}
+	uc.Keyring = false
+	if err := writeConfig(uc); err != nil {
+		fatalf("write config: %v", err)
+	}
+	if err := keyringDelete(keyringAccount(accountDir())); err != nil {
+		errorf("keyring: %v", err)
+	}
+}
+
+// readKeyring retrieves the account key of the account stored in dir
+// from the OS keyring.
+func readKeyring(dir string) (crypto.Signer, error) {
+	b, err := keyringGet(keyringAccount(dir))
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %v", err)
+	}
+	return parseKey(b)
+}
+
+// keyringAccount returns the account name the key of the account in dir
+// is stored under: the absolute path of dir, so that it depends neither
+// on the working directory nor on how -c was spelled.
+func keyringAccount(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// runKeyringTool runs cmd, including its stderr output in the error.
+// It is replaced in tests.
+var runKeyringTool = func(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	return nil
+}
+
+// keyringLabel returns a human readable description of the key
+// stored for the account in dir.
+func keyringLabel(dir string) string {
+	return fmt.Sprintf("ACME account key (%s)", dir)
+}
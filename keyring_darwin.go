@@ -0,0 +1,63 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+)
+
+// The Keychain is accessed with the security tool.
+// The key is stored base64 encoded because the tool prints
+// secrets containing newlines in hex.
+// The secret is not passed as an argument, where other users could see it
+// in the process list: the whole command is read by security -i
+// from its standard input instead.
+
+func keyringSet(account string, data []byte) error {
+	line := securityCommand("add-generic-password", "-U",
+		"-s", keyringService, "-a", account, "-l", keyringLabel(account),
+		"-w", base64.StdEncoding.EncodeToString(data))
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(line)
+	return runKeyringTool(cmd)
+}
+
+// securityCommand returns a command line for security -i,
+// with args quoted for its parser.
+func securityCommand(args ...string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		a = strings.Replace(a, `\`, `\\`, -1)
+		q[i] = `"` + strings.Replace(a, `"`, `\"`, -1) + `"`
+	}
+	return strings.Join(q, " ") + "\n"
+}
+
+func keyringGet(account string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password",
+		"-s", keyringService, "-a", account, "-w")
+	cmd.Stdout = &out
+	if err := runKeyringTool(cmd); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(out.String()))
+}
+
+func keyringDelete(account string) error {
+	cmd := exec.Command("security", "delete-generic-password",
+		"-s", keyringService, "-a", account)
+	return runKeyringTool(cmd)
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// The Secret Service is accessed with secret-tool from libsecret,
+// which talks to GNOME Keyring, KWallet or any other provider over D-Bus.
+
+func keyringSet(account string, data []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label="+keyringLabel(account),
+		"service", keyringService, "account", account)
+	cmd.Stdin = bytes.NewReader(data)
+	return runKeyringTool(cmd)
+}
+
+func keyringGet(account string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	cmd.Stdout = &out
+	if err := runKeyringTool(cmd); err != nil {
+		return nil, err
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("no key found for %s", account)
+	}
+	return out.Bytes(), nil
+}
+
+func keyringDelete(account string) error {
+	cmd := exec.Command("secret-tool", "clear", "service", keyringService, "account", account)
+	return runKeyringTool(cmd)
+}
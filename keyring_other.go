@@ -0,0 +1,31 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func keyringSet(account string, data []byte) error {
+	return fmt.Errorf("OS keyring is not supported on %s", runtime.GOOS)
+}
+
+func keyringGet(account string) ([]byte, error) {
+	return nil, fmt.Errorf("OS keyring is not supported on %s", runtime.GOOS)
+}
+
+func keyringDelete(account string) error {
+	return fmt.Errorf("OS keyring is not supported on %s", runtime.GOOS)
+}
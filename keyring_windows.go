@@ -0,0 +1,82 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// On Windows the key is encrypted with DPAPI, tying it to the current
+// user's logon credentials, and stored next to the account config.
+
+// keyringFile is the DPAPI protected account key file name.
+const keyringFile = "account.key.dpapi"
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = syscall.NewLazyDLL("kernel32.dll").NewProc("LocalFree")
+)
+
+// cryptprotectUIForbidden makes DPAPI fail rather than prompt the user.
+const cryptprotectUIForbidden = 0x1
+
+// dataBlob is DATA_BLOB structure of the Windows API.
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+func keyringSet(account string, data []byte) error {
+	b, err := dpapi(procCryptProtectData, data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(account, keyringFile), b, 0600)
+}
+
+func keyringGet(account string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(account, keyringFile))
+	if err != nil {
+		return nil, err
+	}
+	return dpapi(procCryptUnprotectData, b)
+}
+
+func keyringDelete(account string) error {
+	return os.Remove(filepath.Join(account, keyringFile))
+}
+
+// dpapi calls either CryptProtectData or CryptUnprotectData,
+// which share the same signature, with data as the input.
+func dpapi(proc *syscall.LazyProc, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("dpapi: empty input")
+	}
+	in := dataBlob{cbData: uint32(len(data)), pbData: &data[0]}
+	var out dataBlob
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0,
+		cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+	b := make([]byte, out.cbData)
+	copy(b, (*[1 << 30]byte)(unsafe.Pointer(out.pbData))[:out.cbData:out.cbData])
+	return b, nil
+}
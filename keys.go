@@ -0,0 +1,201 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultKeyType is the type of generated keys unless -key-type is set.
+const defaultKeyType = "ec256"
+
+// keyType is the type of newly generated account and certificate keys,
+// one of keyTypes. It is set with -key-type flag of reg and cert commands.
+// If empty, defaultKeyType is used.
+var keyType string
+
+// keySpec describes a key type: either the size of an RSA key,
+// the curve of an ECDSA key, or an Ed25519 key.
+type keySpec struct {
+	rsaBits int
+	curve   elliptic.Curve
+	ed25519 bool
+}
+
+// keyTypes are the key types which can be generated.
+var keyTypes = map[string]keySpec{
+	"ec256":   {curve: elliptic.P256()},
+	"ec384":   {curve: elliptic.P384()},
+	"rsa2048": {rsaBits: 2048},
+	"rsa3072": {rsaBits: 3072},
+	"rsa4096": {rsaBits: 4096},
+	// certificate keys only; the acme package signs requests
+	// with RSA, ECDSA P-256 and P-384 keys
+	"ec521":   {curve: elliptic.P521()},
+	"ed25519": {ed25519: true},
+}
+
+// checkKeyType returns an error if kt is neither empty nor one of keyTypes,
+// or is not allowed in FIPS mode.
+func checkKeyType(kt string) error {
+	if kt == "" {
+		return nil
+	}
+	s, ok := keyTypes[kt]
+	if !ok {
+		var names []string
+		for name := range keyTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown key type %q; known types are %s", kt, strings.Join(names, ", "))
+	}
+	if fipsMode && (s.rsaBits == 4096 || s.curve == elliptic.P521() || s.ed25519) {
+		return fmt.Errorf("%s key is not allowed in FIPS mode", kt)
+	}
+	return nil
+}
+
+// generateKey generates a new key of type kt, or defaultKeyType if empty.
+func generateKey(kt string) (crypto.Signer, error) {
+	if kt == "" {
+		kt = defaultKeyType
+	}
+	s, ok := keyTypes[kt]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %q", kt)
+	}
+	switch {
+	case s.rsaBits > 0:
+		return rsa.GenerateKey(rand.Reader, s.rsaBits)
+	case s.ed25519:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		return k, err
+	}
+	return ecdsa.GenerateKey(s.curve, rand.Reader)
+}
+
+// keyStrength returns the security strength of pub in bits,
+// as estimated by NIST SP 800-57, or 0 for unsupported keys.
+func keyStrength(pub crypto.PublicKey) int {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsaStrength(pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return pub.Curve.Params().BitSize / 2
+	case ed25519.PublicKey:
+		return 128
+	}
+	return 0
+}
+
+func rsaStrength(bits int) int {
+	switch {
+	case bits >= 15360:
+		return 256
+	case bits >= 7680:
+		return 192
+	case bits >= 3072:
+		return 128
+	case bits >= 2048:
+		return 112
+	case bits >= 1024:
+		return 80
+	}
+	return 0
+}
+
+// strength returns the security strength of keys of type s in bits.
+func (s keySpec) strength() int {
+	switch {
+	case s.rsaBits > 0:
+		return rsaStrength(s.rsaBits)
+	case s.ed25519:
+		return 128
+	}
+	return s.curve.Params().BitSize / 2
+}
+
+// keyName describes pub for messages, e.g. "RSA 2048" or "ECDSA P-256".
+func keyName(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// checkKeyStrength returns an error if pub is smaller than RSA 2048
+// or ECDSA P-256, the smallest keys of keyTypes, or is of unknown type.
+func checkKeyStrength(pub crypto.PublicKey) error {
+	var ok bool
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		ok = pub.N.BitLen() >= 2048
+	case *ecdsa.PublicKey:
+		ok = pub.Curve.Params().BitSize >= 256
+	case ed25519.PublicKey:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("%s key is below the minimum of RSA 2048 or ECDSA P-256", keyName(pub))
+	}
+	return nil
+}
+
+// checkAccountKey returns an error if pub cannot sign ACME requests:
+// the acme package supports RSA and ECDSA P-256 and P-384 keys only.
+func checkAccountKey(pub crypto.PublicKey) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return nil
+	case *ecdsa.PublicKey:
+		if c := pub.Curve; c == elliptic.P256() || c == elliptic.P384() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s keys cannot be used as account keys", keyName(pub))
+}
+
+// checkAccountKeyType returns an error if keys of type kt,
+// or defaultKeyType if empty, cannot be used as account keys.
+// It assumes kt has been checked with checkKeyType.
+func checkAccountKeyType(kt string) error {
+	if kt == "" {
+		kt = defaultKeyType
+	}
+	s := keyTypes[kt]
+	if s.ed25519 || s.curve == elliptic.P521() {
+		return fmt.Errorf("%s keys cannot be used as account keys", kt)
+	}
+	return nil
+}
+
+// warnWeakKey logs a warning if the existing key in path is weaker
+// than the type specified with -key-type, with hint on how to replace it.
+func warnWeakKey(path string, pub crypto.PublicKey, hint string) {
+	if s, ok := keyTypes[keyType]; ok && keyStrength(pub) < s.strength() {
+		logf("warning: %s: %s key is weaker than -key-type %s; %s", path, keyName(pub), keyType, hint)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckKeyType(t *testing.T) {
+	defer func(v bool) { fipsMode = v }(fipsMode)
+	fipsMode = false
+	for _, kt := range []string{"", "ec256", "ec521", "rsa4096"} {
+		if err := checkKeyType(kt); err != nil {
+			t.Errorf("checkKeyType(%q): %v", kt, err)
+		}
+	}
+	if err := checkKeyType("rsa1024"); err == nil {
+		t.Error("checkKeyType(rsa1024): no error")
+	}
+	fipsMode = true
+	if err := checkKeyType("ec384"); err != nil {
+		t.Errorf("FIPS checkKeyType(ec384): %v", err)
+	}
+	for _, kt := range []string{"ec521", "rsa4096", "ed25519"} {
+		if err := checkKeyType(kt); err == nil {
+			t.Errorf("FIPS checkKeyType(%q): no error", kt)
+		}
+	}
+}
+
+func TestCheckAccountKey(t *testing.T) {
+	for _, kt := range []string{"", "ec256", "ec384", "rsa2048"} {
+		if err := checkAccountKeyType(kt); err != nil {
+			t.Errorf("checkAccountKeyType(%q): %v", kt, err)
+		}
+	}
+	// reg -gen -key-type ec521 would write a key the acme package
+	// cannot sign with
+	for _, kt := range []string{"ec521", "ed25519"} {
+		if err := checkAccountKeyType(kt); err == nil {
+			t.Errorf("checkAccountKeyType(%q): no error", kt)
+		}
+	}
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		k, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkAccountKey(k.Public())
+		if want := c != elliptic.P521(); (err == nil) != want {
+			t.Errorf("checkAccountKey(%s): %v; want ok = %v", c.Params().Name, err, want)
+		}
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, kt := range []string{"", "ec256", "ec384", "ec521", "rsa2048", "ed25519"} {
+		k, err := generateKey(kt)
+		if err != nil {
+			t.Errorf("generateKey(%q): %v", kt, err)
+			continue
+		}
+		want := kt
+		if want == "" {
+			want = defaultKeyType
+		}
+		if s := keyStrength(k.Public()); s != keyTypes[want].strength() {
+			t.Errorf("generateKey(%q): %s key of strength %d; want %d", kt, keyName(k.Public()), s, keyTypes[want].strength())
+		}
+	}
+}
+
+func TestCheckKeyStrength(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkKeyStrength(weak.Public()); err == nil {
+		t.Error("RSA 1024: no error")
+	}
+	ec224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkKeyStrength(ec224.Public()); err == nil {
+		t.Error("ECDSA P-224: no error")
+	}
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkKeyStrength(ec256.Public()); err != nil {
+		t.Errorf("ECDSA P-256: %v", err)
+	}
+	for bits, want := range map[int]int{1024: 80, 2048: 112, 3072: 128, 4096: 128, 7680: 192, 15360: 256} {
+		if s := rsaStrength(bits); s != want {
+			t.Errorf("rsaStrength(%d) = %d; want %d", bits, s, want)
+		}
+	}
+}
+
+func TestEd25519Key(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k, err := generateKey("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "example.com.key")
+	if err := writeKey(path, k); err != nil {
+		t.Fatal(err)
+	}
+	rk, err := readKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rk.Public(), k.Public()) {
+		t.Error("read key does not match the written one")
+	}
+	der, err := newCSR(rk, "example.com", []string{"example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil || csr.PublicKeyAlgorithm != x509.Ed25519 {
+		t.Errorf("CSR: %v, %v", csr.PublicKeyAlgorithm, err)
+	}
+	if err := checkAccountKey(k.Public()); err == nil {
+		t.Error("checkAccountKey(Ed25519): no error")
+	}
+}
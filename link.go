@@ -0,0 +1,202 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A webLink is a link of an HTTP Link header field, RFC 8288.
+type webLink struct {
+	target string            // URI reference, as is
+	rel    []string          // relation types, lower case
+	params map[string]string // other parameters by lower case name
+}
+
+// hasRel reports whether l has relation type rel, compared case-insensitively.
+func (l *webLink) hasRel(rel string) bool {
+	for _, r := range l.rel {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkHeader returns the targets of Link header fields in h
+// with relation type rel. Malformed field values are ignored
+// from the first error on.
+func linkHeader(h http.Header, rel string) []string {
+	links, _ := parseLinks(h.Values("Link"))
+	var res []string
+	for _, l := range links {
+		if l.hasRel(rel) {
+			res = append(res, l.target)
+		}
+	}
+	return res
+}
+
+// parseLinks parses Link header field values vs, each a comma separated
+// list of links of the form:
+//
+//	<URI-Reference> *( ";" token [ "=" ( token / quoted-string ) ] )
+//
+// The rel parameter is split into relation types. As required
+// by the RFC, occurrences of rel after the first one are ignored, as are
+// those of other parameters. The parameter values are not decoded
+// further, e.g. title* is left in its RFC 8187 encoding.
+// On error, the links parsed before it are returned.
+func parseLinks(vs []string) ([]webLink, error) {
+	var links []webLink
+	for _, v := range vs {
+		p := &linkParser{s: v}
+		for {
+			p.skipSpace()
+			if p.eof() {
+				break
+			}
+			if p.consume(',') {
+				continue // empty list element
+			}
+			l, err := p.link()
+			if err != nil {
+				return links, fmt.Errorf("link %q: %v", v, err)
+			}
+			links = append(links, l)
+			p.skipSpace()
+			if !p.eof() && !p.consume(',') {
+				return links, fmt.Errorf("link %q: unexpected %q at %d", v, p.s[p.i], p.i)
+			}
+		}
+	}
+	return links, nil
+}
+
+// linkParser is a scanner of a single Link header field value.
+type linkParser struct {
+	s string
+	i int // position of the next byte
+}
+
+func (p *linkParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *linkParser) skipSpace() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// consume skips c if it is the next byte and reports whether it was.
+func (p *linkParser) consume(c byte) bool {
+	if p.eof() || p.s[p.i] != c {
+		return false
+	}
+	p.i++
+	return true
+}
+
+// link parses a single link and its parameters.
+func (p *linkParser) link() (webLink, error) {
+	var l webLink
+	if !p.consume('<') {
+		return l, errors.New("missing <URI-Reference>")
+	}
+	end := strings.IndexByte(p.s[p.i:], '>')
+	if end < 0 {
+		return l, errors.New("unterminated <URI-Reference>")
+	}
+	l.target = strings.TrimSpace(p.s[p.i : p.i+end])
+	p.i += end + 1
+	var hasRel bool
+	for {
+		p.skipSpace()
+		if !p.consume(';') {
+			return l, nil
+		}
+		p.skipSpace()
+		if p.eof() || p.s[p.i] == ',' || p.s[p.i] == ';' {
+			continue // empty parameter
+		}
+		name := strings.ToLower(p.token())
+		if name == "" {
+			return l, fmt.Errorf("invalid parameter name at %d", p.i)
+		}
+		p.skipSpace()
+		var val string
+		if p.consume('=') {
+			p.skipSpace()
+			var err error
+			if val, err = p.value(); err != nil {
+				return l, err
+			}
+		}
+		switch {
+		case name == "rel":
+			if !hasRel {
+				hasRel = true
+				l.rel = strings.Fields(strings.ToLower(val))
+			}
+		default:
+			if l.params == nil {
+				l.params = make(map[string]string)
+			}
+			if _, ok := l.params[name]; !ok {
+				l.params[name] = val
+			}
+		}
+	}
+}
+
+// token parses an RFC 7230 token, which may be empty.
+func (p *linkParser) token() string {
+	start := p.i
+	for !p.eof() && isTokenChar(p.s[p.i]) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// value parses a parameter value, a token or a quoted string.
+func (p *linkParser) value() (string, error) {
+	if !p.consume('"') {
+		return p.token(), nil
+	}
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", errors.New("unterminated quoted string")
+			}
+			c = p.s[p.i]
+			p.i++
+		}
+		b.WriteByte(c)
+	}
+	return "", errors.New("unterminated quoted string")
+}
+
+// isTokenChar reports whether c is an RFC 7230 tchar.
+func isTokenChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []webLink
+	}{
+		// RFC 8288, section 3.5
+		{
+			`<http://example.com/TheBook/chapter2>; rel="previous"; title="previous chapter"`,
+			[]webLink{{"http://example.com/TheBook/chapter2", []string{"previous"}, map[string]string{"title": "previous chapter"}}},
+		},
+		{
+			`</>; rel="http://example.net/foo"`,
+			[]webLink{{"/", []string{"http://example.net/foo"}, nil}},
+		},
+		{
+			`</terms>; rel="copyright"; anchor="#foo"`,
+			[]webLink{{"/terms", []string{"copyright"}, map[string]string{"anchor": "#foo"}}},
+		},
+		{
+			`</TheBook/chapter2>; rel="previous"; title*=UTF-8'de'letztes%20Kapitel, </TheBook/chapter4>; rel="next"; title*=UTF-8'de'n%c3%a4chstes%20Kapitel`,
+			[]webLink{
+				{"/TheBook/chapter2", []string{"previous"}, map[string]string{"title*": "UTF-8'de'letztes%20Kapitel"}},
+				{"/TheBook/chapter4", []string{"next"}, map[string]string{"title*": "UTF-8'de'n%c3%a4chstes%20Kapitel"}},
+			},
+		},
+		{
+			`<http://example.org/>; rel="start http://example.net/relation/other"`,
+			[]webLink{{"http://example.org/", []string{"start", "http://example.net/relation/other"}, nil}},
+		},
+		// parameter order, quoting and case
+		{
+			`<https://ca/a>; title="a, b; \"c\""; REL=Next`,
+			[]webLink{{"https://ca/a", []string{"next"}, map[string]string{"title": `a, b; "c"`}}},
+		},
+		// only the first rel counts; empty elements and parameters
+		{
+			`, <a>;; rel=up; rel=next ,,<b>`,
+			[]webLink{{"a", []string{"up"}, nil}, {"b", nil, nil}},
+		},
+		{``, nil},
+	}
+	for _, test := range tests {
+		links, err := parseLinks([]string{test.in})
+		if err != nil {
+			t.Errorf("parseLinks(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(links, test.want) {
+			t.Errorf("parseLinks(%q) = %+v; want %+v", test.in, links, test.want)
+		}
+	}
+
+	for _, in := range []string{
+		`https://ca/a; rel=next`,
+		`<https://ca/a; rel=next`,
+		`<a>; title="unterminated`,
+		`<a>; =b`,
+		`<a> <b>`,
+	} {
+		if links, err := parseLinks([]string{in}); err == nil {
+			t.Errorf("parseLinks(%q) = %+v; want error", in, links)
+		}
+	}
+}
+
+func TestLinkHeader(t *testing.T) {
+	h := http.Header{"Link": {
+		`<https://ca/terms>;rel="terms-of-service"`,
+		`<https://ca/issuer>;rel="up", <https://ca/page2>; title="a,b"; rel="next prev"`,
+		`<https://ca/bad`,
+	}}
+	if got := linkHeader(h, "next"); !reflect.DeepEqual(got, []string{"https://ca/page2"}) {
+		t.Errorf("linkHeader(next) = %q", got)
+	}
+	if got := linkHeader(h, "UP"); !reflect.DeepEqual(got, []string{"https://ca/issuer"}) {
+		t.Errorf("linkHeader(UP) = %q", got)
+	}
+}
@@ -12,11 +12,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
 )
 
 // defaultDisco is the default CA directory endpoint.
@@ -37,10 +48,24 @@ var (
 	// commands lists all available commands and help topics.
 	// The order here is the order in which they are printed by 'acme help'.
 	commands = []*command{
+		cmdInit,
 		cmdReg,
 		cmdWho,
 		cmdUpdate,
+		cmdContact,
+		cmdImportKey,
 		cmdCert,
+		cmdContinue,
+		cmdRevoke,
+		cmdPromote,
+		cmdSnippet,
+		cmdConformance,
+		cmdKeyring,
+		cmdDoctor,
+		cmdMigrate,
+		cmdGC,
+		cmdBackup,
+		cmdRestore,
 		// help commands, non-executable
 		helpAccount,
 		helpDisco,
@@ -48,15 +73,126 @@ var (
 
 	exitMu     sync.Mutex // guards exitStatus
 	exitStatus = 0
+
+	// flagJSON makes errors reported as JSON objects.
+	// It is set with -json flag, common to all subcommands.
+	flagJSON bool
+
+	// flagLogID is a caller provided correlation ID prefixed to log messages
+	// and included in JSON errors. It is set with -log-id flag, common to all
+	// subcommands, and defaults to ACME_LOG_ID environment variable.
+	flagLogID = os.Getenv("ACME_LOG_ID")
+
+	// requestIDHeaders are response headers in which CAs return
+	// their request identifiers, in order of preference.
+	requestIDHeaders = []string{"X-Request-Id", "Request-Id", "X-Correlation-Id"}
 )
 
 var logf = log.Printf
 
+// timeNow is useful for testing for fixed current time.
+var timeNow = time.Now
+
+// timeSleep pauses for d, or until ctx is done, in which case it returns
+// ctx.Err(). It is replaced in tests to avoid real waits.
+var timeSleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func errorf(format string, args ...interface{}) {
-	logf(format, args...)
+	if flagJSON {
+		printJSONError(os.Stderr, fmt.Sprintf(format, args...), args)
+	} else if id := errRequestID(args); id != "" {
+		logf(format+" (CA request ID %s)", append(args, id)...)
+	} else {
+		logf(format, args...)
+	}
 	setExitStatus(1)
 }
 
+// errRequestID returns the CA request identifier of the first error in args
+// which is or wraps an *acme.Error, or an empty string if there is none.
+func errRequestID(args []interface{}) string {
+	for _, a := range args {
+		var e *acme.Error
+		if err, ok := a.(error); ok && errors.As(err, &e) {
+			return requestID(e.Header)
+		}
+	}
+	return ""
+}
+
+// requestID returns the request identifier found in CA response headers h.
+func requestID(h http.Header) string {
+	for _, k := range requestIDHeaders {
+		if v := h.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// jsonError is an error representation written in -json mode.
+type jsonError struct {
+	Message   string       `json:"message"`
+	LogID     string       `json:"logId,omitempty"` // -log-id value
+	Retryable bool         `json:"retryable,omitempty"`
+	Terminal  bool         `json:"terminal,omitempty"`
+	Scope     string       `json:"scope,omitempty"` // see errorScope
+	Problem   *jsonProblem `json:"problem,omitempty"`
+}
+
+// jsonProblem is an ACME problem document, as reported by the CA.
+type jsonProblem struct {
+	Status     int    `json:"status"`
+	Type       string `json:"type"`
+	Detail     string `json:"detail"`
+	RetryAfter string `json:"retryAfter,omitempty"`
+	RequestID  string `json:"requestId,omitempty"` // CA request identifier
+}
+
+// printJSONError writes msg to w as a jsonError.
+// The error is classified by the first error in args, and the problem
+// document is populated from the first one which is or wraps an *acme.Error.
+func printJSONError(w io.Writer, msg string, args []interface{}) {
+	v := jsonError{Message: msg, LogID: flagLogID}
+	for _, a := range args {
+		if err, ok := a.(error); ok {
+			v.Retryable = isRetryable(err)
+			v.Terminal = isClientError(err)
+			v.Scope = errorScope(err)
+			break
+		}
+	}
+	for _, a := range args {
+		var e *acme.Error
+		if err, ok := a.(error); ok && errors.As(err, &e) {
+			v.Problem = &jsonProblem{
+				Status:     e.StatusCode,
+				Type:       e.ProblemType,
+				Detail:     e.Detail,
+				RetryAfter: e.Header.Get("Retry-After"),
+				RequestID:  requestID(e.Header),
+			}
+			break
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		// should never happen
+		logf("%s", msg)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", b)
+}
+
 func fatalf(format string, args ...interface{}) {
 	errorf(format, args...)
 	exit()
@@ -74,6 +210,25 @@ func exit() {
 	os.Exit(exitStatus)
 }
 
+// interruptContext returns a copy of parent which is cancelled
+// when the process receives SIGINT or SIGTERM.
+// A second signal terminates the process immediately.
+func interruptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-c:
+			logf("%v: cleaning up", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(c)
+	}()
+	return ctx, cancel
+}
+
 func main() {
 	flag.Usage = usage
 	flag.Parse() // catch -h argument
@@ -93,6 +248,21 @@ func main() {
 			addFlags(&cmd.flag)
 			cmd.flag.Usage = func() { cmd.Usage() }
 			cmd.flag.Parse(args[1:])
+			if flagLogID != "" {
+				log.SetPrefix("[" + flagLogID + "] ")
+			}
+			if err := useTenant(); err != nil {
+				fatalf("%v", err)
+			}
+			if fipsRequired && !fipsMode {
+				fatalf("-fips=false: this binary is built with fips tag and always runs in FIPS mode")
+			}
+			if flagCA != "" && !validDirName(flagCA) {
+				fatalf("-ca: invalid name %q", flagCA)
+			}
+			if err := applySettings(cmd); err != nil {
+				fatalf("%v", err)
+			}
 			cmd.run(cmd.flag.Args())
 			exit()
 			return
@@ -106,6 +276,16 @@ func main() {
 // Common flag var names are of flagXxx form.
 func addFlags(f *flag.FlagSet) {
 	f.StringVar(&configDir, "c", configDir, "")
+	f.StringVar(&flagCA, "ca", flagCA, "")
+	f.StringVar(&flagTenant, "tenant", flagTenant, "")
+	f.BoolVar(&flagJSON, "json", flagJSON, "")
+	f.BoolVar(&fipsMode, "fips", fipsMode, "")
+	f.StringVar(&flagLogID, "log-id", flagLogID, "")
+	f.Float64Var(&flagQPS, "qps", flagQPS, "")
+	f.StringVar(&flagUserAgent, "ua", flagUserAgent, "")
+	f.StringVar(&flagProxy, "proxy", flagProxy, "")
+	f.BoolVar(&flagTrace, "trace", flagTrace, "")
+	f.Var(flagHeader, "header", "")
 }
 
 // A command is an implementation of a acme command
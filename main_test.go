@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"testing"
+
+	"golang.org/x/crypto/acme"
 )
 
 func TestDefaultDisco(t *testing.T) {
@@ -34,3 +40,44 @@ func TestDiscoAliasFlag(t *testing.T) {
 		}
 	}
 }
+
+func TestPrintJSONError(t *testing.T) {
+	e := &acme.Error{
+		StatusCode:  429,
+		ProblemType: "urn:acme:error:rateLimited",
+		Detail:      "too many",
+		Header:      http.Header{"Retry-After": {"120"}, "X-Request-Id": {"abc"}},
+	}
+	var buf bytes.Buffer
+	printJSONError(&buf, "cert: error", []interface{}{e})
+	want := `{"message":"cert: error","retryable":true,"problem":{"status":429,"type":"urn:acme:error:rateLimited","detail":"too many","retryAfter":"120","requestId":"abc"}}` + "\n"
+	if buf.String() != want {
+		t.Errorf("buf = %s; want %s", buf.String(), want)
+	}
+
+	buf.Reset()
+	e = &acme.Error{StatusCode: 403, ProblemType: "urn:acme:error:unauthorized", Detail: "no"}
+	printJSONError(&buf, "cert: error", []interface{}{e})
+	want = `{"message":"cert: error","terminal":true,"scope":"account","problem":{"status":403,"type":"urn:acme:error:unauthorized","detail":"no"}}` + "\n"
+	if buf.String() != want {
+		t.Errorf("buf = %s; want %s", buf.String(), want)
+	}
+
+	buf.Reset()
+	printJSONError(&buf, "no key", nil)
+	want = `{"message":"no key"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("buf = %s; want %s", buf.String(), want)
+	}
+}
+
+func TestErrRequestID(t *testing.T) {
+	e := &acme.Error{Header: http.Header{"Request-Id": {"r1"}}}
+	args := []interface{}{"example.com", fmt.Errorf("cert: %w", e)}
+	if id := errRequestID(args); id != "r1" {
+		t.Errorf("errRequestID = %q; want r1", id)
+	}
+	if id := errRequestID([]interface{}{errors.New("no CA")}); id != "" {
+		t.Errorf("errRequestID = %q; want empty", id)
+	}
+}
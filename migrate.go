@@ -0,0 +1,139 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var cmdMigrate = &command{
+	run:       runMigrate,
+	UsageLine: "migrate [-c config]",
+	Short:     "upgrade config dir format",
+	Long: `
+Migrate upgrades {{.AccountFile}} and related files of all accounts
+in the config dir, including those of -ca accounts, to the current format,
+version {{.SchemaVersion}}. Before a file is modified, a copy is saved
+with the version and current time appended to its name.
+
+Other commands work with files of older versions, as far as possible,
+and refuse to use files of newer versions.
+
+Default location of the config dir is {{.ConfigDir}}.
+	`,
+}
+
+// configMigrations upgrade the files of an account dir,
+// from version i to i+1 by the i'th element.
+// The raw account config may be modified in place.
+var configMigrations = []func(dir string, raw map[string]json.RawMessage) error{
+	// version 1: authz state entries became objects with expiration time
+	func(dir string, raw map[string]json.RawMessage) error {
+		path := filepath.Join(dir, authzFile)
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var s authzState // accepts both formats
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		if b, err = json.MarshalIndent(s, "", "  "); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, b, 0600)
+	},
+}
+
+func runMigrate([]string) {
+	dirs := []string{configDir}
+	ca, err := filepath.Glob(filepath.Join(configDir, "ca", "*"))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	dirs = append(dirs, ca...)
+	for _, dir := range dirs {
+		if err := migrateDir(dir); err != nil {
+			errorf("%s: %v", dir, err)
+		}
+	}
+}
+
+// migrateDir upgrades the account files in dir to configSchemaVersion.
+func migrateDir(dir string) error {
+	path := filepath.Join(dir, accountFile)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("%s: %v", accountFile, err)
+	}
+	var v int
+	if r, ok := raw["schemaVersion"]; ok {
+		if err := json.Unmarshal(r, &v); err != nil {
+			return fmt.Errorf("%s: schemaVersion: %v", accountFile, err)
+		}
+	}
+	switch {
+	case v > configSchemaVersion:
+		return fmt.Errorf("%s: schema version %d is newer than supported %d; upgrade acme",
+			accountFile, v, configSchemaVersion)
+	case v == configSchemaVersion:
+		return nil
+	}
+
+	suffix := fmt.Sprintf(".v%d-%s", v, timeNow().Format("20060102150405"))
+	for _, name := range []string{accountFile, authzFile} {
+		if err := backupFile(filepath.Join(dir, name), suffix); err != nil {
+			return err
+		}
+	}
+	for ; v < configSchemaVersion; v++ {
+		if err := configMigrations[v](dir, raw); err != nil {
+			return fmt.Errorf("migrate to version %d: %v", v+1, err)
+		}
+	}
+	raw["schemaVersion"], _ = json.Marshal(configSchemaVersion)
+	if b, err = json.MarshalIndent(raw, "", "  "); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	logf("%s: migrated to version %d", dir, configSchemaVersion)
+	return nil
+}
+
+// backupFile copies the file at path to path+suffix.
+// A missing file is not an error.
+func backupFile(path, suffix string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+suffix, b, 0600)
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(accountFile, `{"uri": "https://ca/reg/1", "ca": "https://ca/directory", "unknown": 1}`)
+	write(authzFile, `{"example.com": "https://ca/authz/1"}`)
+
+	if err := migrateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, accountFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if v := raw["schemaVersion"]; v != float64(configSchemaVersion) {
+		t.Errorf("schemaVersion = %v; want %d", v, configSchemaVersion)
+	}
+	if raw["unknown"] != float64(1) || raw["uri"] != "https://ca/reg/1" {
+		t.Errorf("fields not preserved: %s", b)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, authzFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"uri": "https://ca/authz/1"`) {
+		t.Errorf("authz state not migrated: %s", b)
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, "*.v0-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("backups = %v; want 2 files", backups)
+	}
+
+	// up to date: nothing to do
+	if err := migrateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	write(accountFile, `{"schemaVersion": 1000}`)
+	if err := migrateDir(dir); err == nil {
+		t.Error("migrateDir of a newer version: no error")
+	}
+}
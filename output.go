@@ -0,0 +1,236 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"text/template"
+)
+
+var (
+	// outCertMode and outKeyMode are permissions of written certificate
+	// and key files, set with -cert-mode and -key-mode cert arguments.
+	outCertMode = modeFlag(0644)
+	outKeyMode  = modeFlag(0600)
+
+	// outUID and outGID are the owner and group of written files,
+	// resolved from -owner and -group cert arguments.
+	// A value of -1 leaves it unchanged.
+	outUID = -1
+	outGID = -1
+
+	// outSELinux is SELinux security context applied to written files,
+	// set with -selinux cert argument.
+	outSELinux string
+)
+
+// modeFlag is an octal file permissions flag value.
+type modeFlag os.FileMode
+
+func (m *modeFlag) String() string {
+	return fmt.Sprintf("%#o", *m)
+}
+
+func (m *modeFlag) Set(v string) error {
+	n, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || n&^0777 != 0 {
+		return fmt.Errorf("%q: invalid permissions, want octal number such as 0640", v)
+	}
+	*m = modeFlag(n)
+	return nil
+}
+
+// setOwner resolves user and group names or IDs into outUID and outGID.
+// Empty values leave the corresponding ID unchanged.
+func setOwner(owner, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("file ownership cannot be changed on %s", runtime.GOOS)
+	}
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			if u, err = user.LookupId(owner); err != nil {
+				return err
+			}
+		}
+		if outUID, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return err
+			}
+		}
+		if outGID, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPerm applies mode, the configured ownership and SELinux context
+// to an output file.
+func setPerm(path string, mode modeFlag) error {
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		return err
+	}
+	if outSELinux != "" {
+		if err := setSELinuxContext(path, outSELinux); err != nil {
+			return err
+		}
+	}
+	if outUID == -1 && outGID == -1 {
+		return nil
+	}
+	return os.Chown(path, outUID, outGID)
+}
+
+// verifyOutput re-reads the file at path and verifies it has the expected
+// content and permissions. It catches file systems and umask settings
+// which silently alter written files.
+func verifyOutput(path string, content []byte, mode modeFlag) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(b) != sha256.Sum256(content) {
+		return fmt.Errorf("%s: content differs from what was written", path)
+	}
+	return verifyPerm(path, mode)
+}
+
+// verifyPerm checks that the file at path has mode permissions,
+// except on Windows.
+func verifyPerm(path string, mode modeFlag) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm() != os.FileMode(mode) {
+		return fmt.Errorf("%s: permissions are %#o; want %#o", path, fi.Mode().Perm(), mode)
+	}
+	return nil
+}
+
+// mkdirOutput creates dir and missing parents for files with the given mode.
+// The directories are searchable by those who can read the files,
+// and are owned by the configured owner and group.
+func mkdirOutput(dir string, mode modeFlag) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := mkdirOutput(filepath.Dir(dir), mode); err != nil {
+		return err
+	}
+	// add x bit wherever r is set
+	dmode := mode | (mode&0444)>>2
+	if err := os.Mkdir(dir, os.FileMode(dmode)); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return setPerm(dir, dmode)
+}
+
+// writeOutputKey reads the key from path or generates a new one, like anyKey.
+// A newly generated key file gets outKeyMode permissions.
+func writeOutputKey(path string) (crypto.Signer, error) {
+	_, err := os.Stat(path)
+	exists := err == nil
+	key, err := anyKey(path, true)
+	if err != nil || exists {
+		return key, err
+	}
+	if err := setPerm(path, outKeyMode); err != nil {
+		return nil, err
+	}
+	if err := verifyPerm(path, outKeyMode); err != nil {
+		return nil, err
+	}
+	k, err := readKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: written key cannot be read back: %v", path, err)
+	}
+	if !reflect.DeepEqual(k.Public(), key.Public()) {
+		return nil, fmt.Errorf("%s: written key does not match the generated one", path)
+	}
+	return key, nil
+}
+
+// warnKeyPerm logs a warning if the key file at path is accessible
+// by group or others.
+func warnKeyPerm(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		logf("warning: key file %s is accessible by group or others (%#o)", path, fi.Mode().Perm())
+	}
+}
+
+// outputData is the template context of -out and -link cert arguments.
+type outputData struct {
+	Domain string // the first domain argument
+	Serial string // hex encoded certificate serial number; empty for -link
+	Date   string // certificate issuance date in YYYYMMDD form; empty for -link
+}
+
+// expandPath executes path template tmpl with data.
+func expandPath(tmpl string, data outputData) (string, error) {
+	t, err := template.New("path").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("%q expands to empty path", tmpl)
+	}
+	return buf.String(), nil
+}
+
+// updateLink atomically replaces link with a symbolic link to target.
+// The link is relative if target is in the same directory tree.
+func updateLink(link, target string) error {
+	if rel, err := filepath.Rel(filepath.Dir(link), target); err == nil {
+		target = rel
+	}
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
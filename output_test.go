@@ -0,0 +1,91 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandPath(t *testing.T) {
+	data := outputData{Domain: "example.com", Serial: "fa", Date: "20161016"}
+	v, err := expandPath("/etc/ssl/{{.Domain}}/{{.Date}}-{{.Serial}}.crt", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/etc/ssl/example.com/20161016-fa.crt"; v != want {
+		t.Errorf("v = %q; want %q", v, want)
+	}
+	if _, err := expandPath("{{.Bogus}}", data); err == nil {
+		t.Error("unknown field: no error")
+	}
+}
+
+func TestUpdateLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	link := filepath.Join(dir, "current.crt")
+	for _, name := range []string{"1.crt", "2.crt"} {
+		target := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(target, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateLink(link, target); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != name {
+			t.Errorf("link content = %q; want %q", b, name)
+		}
+		if v, _ := os.Readlink(link); v != name {
+			t.Errorf("link target = %q; want relative %q", v, name)
+		}
+	}
+}
+
+func TestMkdirOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-mkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "a", "b")
+	if err := mkdirOutput(sub, 0640); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{filepath.Join(dir, "a"), sub} {
+		fi, err := os.Stat(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != 0750 {
+			t.Errorf("%s: mode = %#o; want 0750", d, fi.Mode().Perm())
+		}
+	}
+
+	var m modeFlag
+	if err := m.Set("0640"); err != nil || m != 0640 {
+		t.Errorf("Set(0640): %#o, %v", m, err)
+	}
+	if err := m.Set("1777"); err == nil {
+		t.Error("Set(1777): no error")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+var (
+	certPack     string // -pack file
+	certPackSize = 100  // -pack-size
+)
+
+// packDomains distributes domains into groups of at most size names,
+// each to be requested as a single certificate.
+//
+// The previous grouping, prev, is preserved as much as possible:
+// domains stay in their groups and new ones are added to groups
+// with free space before new groups are created. Domains not in the list
+// are removed, and so are groups left empty. The first domain of a group,
+// which names the certificate, changes only if it is removed.
+func packDomains(prev [][]string, domains []string, size int) [][]string {
+	want := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		want[d] = true
+	}
+	var groups [][]string
+	for _, g := range prev {
+		var keep []string
+		for _, d := range g {
+			if want[d] && len(keep) < size {
+				keep = append(keep, d)
+				delete(want, d)
+			}
+		}
+		if len(keep) > 0 {
+			groups = append(groups, keep)
+		}
+	}
+	var rest []string
+	for d := range want {
+		rest = append(rest, d)
+	}
+	sort.Strings(rest)
+	for i := range groups {
+		for len(rest) > 0 && len(groups[i]) < size {
+			groups[i] = append(groups[i], rest[0])
+			rest = rest[1:]
+		}
+	}
+	for len(rest) > 0 {
+		n := size
+		if n > len(rest) {
+			n = len(rest)
+		}
+		groups = append(groups, rest[:n:n])
+		rest = rest[n:]
+	}
+	return groups
+}
+
+// readPack reads a grouping stored by writePack.
+// A missing file results in no groups and no error.
+func readPack(path string) ([][]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var groups [][]string
+	return groups, json.Unmarshal(b, &groups)
+}
+
+// writePack stores groups at path.
+func writePack(path string, groups [][]string) error {
+	b, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// runCertPack packs domains into groups stored in certPack file
+// and requests a certificate for each group with obtainCert.
+// A failed group does not stop the others; the status of each group
+// is printed at the end and any failure makes the exit status 1.
+// Once ctx is cancelled, as on interrupt, the remaining groups
+// are not attempted.
+func runCertPack(ctx context.Context, domains []string) {
+	if certKeypath != "" {
+		fatalf("-k cannot be used with -pack")
+	}
+	if certCN != "" && certCN != noCN {
+		fatalf("-cn cannot be used with -pack, except for -cn=%s", noCN)
+	}
+	if certPackSize < 1 {
+		fatalf("-pack-size must be positive")
+	}
+	path := certPack
+	prev, err := readPack(path)
+	if err != nil {
+		fatalf("read %s: %v", path, err)
+	}
+	groups := packDomains(prev, uniqueSorted(domains), certPackSize)
+	if err := writePack(path, groups); err != nil {
+		fatalf("write %s: %v", path, err)
+	}
+
+	// each group is a separate cert run with the same arguments
+	certPack = ""
+	ca, disco := flagCA, certDisco
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	defer tw.Flush()
+	for i, g := range groups {
+		if err := ctx.Err(); err != nil {
+			errorf("%v: %d groups not attempted", err, len(groups)-i)
+			for _, g := range groups[i:] {
+				fmt.Fprintf(tw, "%s\tnot attempted\n", g[0])
+			}
+			return
+		}
+		logf("%s: %d domains", g[0], len(g))
+		flagCA, certDisco = ca, disco
+		certKeypath = ""
+		if err := obtainCert(ctx, append([]string(nil), g...)); err != nil {
+			errorf("%s: %v", g[0], err)
+			fmt.Fprintf(tw, "%s\tfailed\n", g[0])
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tok\n", g[0])
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackDomains(t *testing.T) {
+	tests := []struct {
+		prev    [][]string
+		domains []string
+		size    int
+		want    [][]string
+	}{
+		{
+			domains: []string{"a", "b", "c", "d", "e"},
+			size:    2,
+			want:    [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			// new domains fill free space first
+			prev:    [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			domains: []string{"a", "b", "c", "d", "e", "f", "g"},
+			size:    2,
+			want:    [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}, {"g"}},
+		},
+		{
+			// removal does not reshuffle other groups
+			prev:    [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}},
+			domains: []string{"a", "d", "e", "f", "x"},
+			size:    2,
+			want:    [][]string{{"a", "x"}, {"d"}, {"e", "f"}},
+		},
+		{
+			// empty groups are dropped
+			prev:    [][]string{{"a"}, {"b"}},
+			domains: []string{"b"},
+			size:    2,
+			want:    [][]string{{"b"}},
+		},
+		{
+			// smaller size moves the overflow
+			prev:    [][]string{{"a", "b", "c"}},
+			domains: []string{"a", "b", "c"},
+			size:    2,
+			want:    [][]string{{"a", "b"}, {"c"}},
+		},
+	}
+	for i, test := range tests {
+		got := packDomains(test.prev, test.domains, test.size)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: packDomains = %v; want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCertPackFailedGroup(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	defer func(p string, n int) { certPack, certPackSize = p, n }(certPack, certPackSize)
+	defer func(k string) { certKeypath = k }(certKeypath)
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	defer func() { exitStatus = 0 }()
+	var logs []string
+	logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	dir, err := ioutil.TempDir("", "acme-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = dir
+
+	// no account config: every group fails, but all are attempted
+	certPack, certPackSize = filepath.Join(dir, "pack.json"), 1
+	runCertPack(context.Background(), []string{"a.example.com", "b.example.com"})
+	if exitStatus != 1 {
+		t.Errorf("exitStatus = %d; want 1", exitStatus)
+	}
+	var failed []string
+	for _, l := range logs {
+		if strings.Contains(l, "read config") {
+			failed = append(failed, l)
+		}
+	}
+	if len(failed) != 2 {
+		t.Errorf("failed groups: %q; want 2", failed)
+	}
+}
+
+func TestCertPackInterrupted(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	defer func(p string, n int) { certPack, certPackSize = p, n }(certPack, certPackSize)
+	defer func(k string) { certKeypath = k }(certKeypath)
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	defer func() { exitStatus = 0 }()
+	var logs []string
+	logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	dir, err := ioutil.TempDir("", "acme-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = dir
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	certPack, certPackSize = filepath.Join(dir, "pack.json"), 1
+	runCertPack(ctx, []string{"a.example.com", "b.example.com"})
+	if exitStatus != 1 {
+		t.Errorf("exitStatus = %d; want 1", exitStatus)
+	}
+	want := []string{"context canceled: 2 groups not attempted"}
+	if !reflect.DeepEqual(logs, want) {
+		t.Errorf("logs = %q; want %q", logs, want)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckFrom lists the vantage points from which a challenge response
+// is checked before it is accepted. It is set with cert -check-from.
+var certCheckFrom checkFromFlag
+
+// publicResolvers are open DNS resolvers of different operators,
+// queried when -check-from is "dns".
+var publicResolvers = []string{
+	"8.8.8.8:53",
+	"1.1.1.1:53",
+	"9.9.9.9:53",
+	"208.67.222.222:53",
+}
+
+// perspectiveTimeout limits a check from a single vantage point.
+const perspectiveTimeout = 30 * time.Second
+
+// checkFromFlag is a repeatable flag which collects vantage points:
+// http:// or https:// URLs of HTTP proxies to fetch http-01 responses
+// through, dns://host[:port] addresses of resolvers to look dns-01
+// records up with, or "dns" for publicResolvers.
+type checkFromFlag []string
+
+func (f *checkFromFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *checkFromFlag) Set(v string) error {
+	if v != "dns" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return err
+		}
+		switch {
+		case u.Host == "":
+			return fmt.Errorf("%q: want a URL with a host", v)
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "dns":
+			return fmt.Errorf("%q: want http, https or dns URL", v)
+		}
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// proxies returns the HTTP proxy URLs listed in f.
+func (f checkFromFlag) proxies() []*url.URL {
+	var p []*url.URL
+	for _, v := range f {
+		if u, err := url.Parse(v); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			p = append(p, u)
+		}
+	}
+	return p
+}
+
+// resolvers returns the host:port addresses of DNS resolvers listed in f.
+func (f checkFromFlag) resolvers() []string {
+	var r []string
+	for _, v := range f {
+		if v == "dns" {
+			r = append(r, publicResolvers...)
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme != "dns" {
+			continue
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "53")
+		}
+		r = append(r, addr)
+	}
+	return r
+}
+
+// checkPerspectives fetches the http-01 challenge response for domain
+// at path through each -check-from proxy, as the CA would request it
+// from its vantage points, and verifies it is want.
+// It does nothing if no proxies are specified.
+func checkPerspectives(ctx context.Context, domain, path, want string) error {
+	proxies := certCheckFrom.proxies()
+	return checkEach(len(proxies), func(i int) (string, error) {
+		hc := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxies[i])}}
+		req, err := http.NewRequest("GET", "http://"+domain+path, nil)
+		if err != nil {
+			return proxies[i].Host, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, perspectiveTimeout)
+		defer cancel()
+		return proxies[i].Host, checkChallenge(ctx, hc, req, want)
+	})
+}
+
+// checkDNSPerspectives looks up the dns-01 TXT record of domain
+// with each -check-from resolver and verifies it includes want.
+// It does nothing if no resolvers are specified.
+func checkDNSPerspectives(ctx context.Context, domain, want string) error {
+	resolvers := certCheckFrom.resolvers()
+	return checkEach(len(resolvers), func(i int) (string, error) {
+		addr := resolvers[i]
+		r := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+		ctx, cancel := context.WithTimeout(ctx, perspectiveTimeout)
+		defer cancel()
+		name := "_acme-challenge." + domain
+		txt, err := r.LookupTXT(ctx, name)
+		if err != nil {
+			return addr, err
+		}
+		if !contains(txt, want) {
+			return addr, fmt.Errorf("%s TXT record is %q", name, txt)
+		}
+		return addr, nil
+	})
+}
+
+// checkEach runs n checks concurrently. Each returns the vantage point
+// it checked from and the outcome. The returned error lists all failures.
+func checkEach(n int, check func(i int) (string, error)) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from, err := check(i)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("from %s: %v", from, err))
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return fmt.Errorf("perspective check failed %d of %d: %s", len(errs), n, strings.Join(errs, "; "))
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckFromFlag(t *testing.T) {
+	var f checkFromFlag
+	for _, v := range []string{"http://proxy1:3128", "dns", "dns://192.0.2.53", "dns://[2001:db8::53]:5353", "https://proxy2"} {
+		if err := f.Set(v); err != nil {
+			t.Errorf("Set(%q): %v", v, err)
+		}
+	}
+	for _, v := range []string{"proxy:3128", "ftp://proxy", "http://", "::"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q): no error", v)
+		}
+	}
+	var proxies []string
+	for _, u := range f.proxies() {
+		proxies = append(proxies, u.String())
+	}
+	if want := []string{"http://proxy1:3128", "https://proxy2"}; !reflect.DeepEqual(proxies, want) {
+		t.Errorf("proxies = %q; want %q", proxies, want)
+	}
+	want := append(append([]string{}, publicResolvers...), "192.0.2.53:53", "[2001:db8::53]:5353")
+	if r := f.resolvers(); !reflect.DeepEqual(r, want) {
+		t.Errorf("resolvers = %q; want %q", r, want)
+	}
+}
+
+func TestCheckPerspectives(t *testing.T) {
+	defer func(f checkFromFlag) { certCheckFrom = f }(certCheckFrom)
+	// proxies answer requests for the absolute challenge URL;
+	// the second one reaches a stale response
+	proxy := func(val string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Host != "example.org" || r.URL.Path != "/.well-known/acme-challenge/tok" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(val))
+		}))
+	}
+	good, stale := proxy("tok.thumb"), proxy("old")
+	defer good.Close()
+	defer stale.Close()
+
+	ctx := context.Background()
+	certCheckFrom = nil
+	if err := checkPerspectives(ctx, "example.org", "/.well-known/acme-challenge/tok", "tok.thumb"); err != nil {
+		t.Errorf("no -check-from: %v", err)
+	}
+	certCheckFrom = checkFromFlag{good.URL, "dns"}
+	if err := checkPerspectives(ctx, "example.org", "/.well-known/acme-challenge/tok", "tok.thumb"); err != nil {
+		t.Errorf("checkPerspectives: %v", err)
+	}
+	certCheckFrom = append(certCheckFrom, stale.URL)
+	err := checkPerspectives(ctx, "example.org", "/.well-known/acme-challenge/tok", "tok.thumb")
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") || !strings.Contains(err.Error(), strings.TrimPrefix(stale.URL, "http://")) {
+		t.Errorf("checkPerspectives with a stale proxy: %v", err)
+	}
+}
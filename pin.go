@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// spkiPin returns base64 encoded SHA-256 digest of the DER encoded
+// SubjectPublicKeyInfo of pub, as used by HPKP and similar pin lists.
+func spkiPin(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// writePins writes SPKI pins of the keys to path,
+// one pin-sha256="..." directive per line.
+// The first key is expected to be the current one, followed by backups.
+func writePins(path string, keys ...crypto.Signer) error {
+	var b []byte
+	for _, k := range keys {
+		pin, err := spkiPin(k.Public())
+		if err != nil {
+			return err
+		}
+		b = append(b, fmt.Sprintf("pin-sha256=%q\n", pin)...)
+	}
+	if err := ioutil.WriteFile(path, b, os.FileMode(outCertMode)); err != nil {
+		return err
+	}
+	if err := setPerm(path, outCertMode); err != nil {
+		return err
+	}
+	return verifyOutput(path, b, outCertMode)
+}
+
+// promoteBackupKey moves the backup key file, if any, to path,
+// where the certificate key is missing, so that the key whose pin
+// was published as a backup becomes the current one.
+// It reports whether a key was moved.
+func promoteBackupKey(path, backup string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := os.Rename(backup, path); err != nil {
+		return false, err
+	}
+	logf("%s: the backup key is now the certificate key", path)
+	return true, nil
+}
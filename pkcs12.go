@@ -0,0 +1,273 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PKCS#12 object identifiers, as in RFC 7292, RFC 8018 and RFC 5652.
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidShroudedKeyBag  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256  = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// pkcs12Iterations is the iteration count of both the key encryption
+// and the MAC key derivation, the OpenSSL default.
+const pkcs12Iterations = 2048
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pfxContentInfo
+	MacData  pfxMacData
+}
+
+type pfxContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT, see pkcs12Explicit
+}
+
+type pfxMacData struct {
+	Mac struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+	MacSalt    []byte
+	Iterations int
+}
+
+type pfxSafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue  // [0] EXPLICIT, see pkcs12Explicit
+	Attributes []pfxAttribute `asn1:"set,optional"`
+}
+
+type pfxAttribute struct {
+	ID     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type pfxCertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"explicit,tag:0"`
+}
+
+type pfxEncryptedKey struct {
+	Algo pkix.AlgorithmIdentifier
+	Data []byte
+}
+
+type pbes2Params struct {
+	KDF    pkix.AlgorithmIdentifier
+	Cipher pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+// encodePKCS12 creates a PKCS#12 (PFX) file holding key and its DER encoded
+// certificate chain, leaf first, under friendly name name,
+// protected with password.
+// The key is encrypted with PBES2, PBKDF2 with HMAC-SHA256 and AES-256-CBC,
+// and the integrity MAC is HMAC-SHA256. Windows imports such files
+// since Windows 10 1709 and Windows Server 2019.
+// The certificates are not encrypted.
+func encodePKCS12(name string, key crypto.Signer, chain [][]byte, password string) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("pkcs12: empty certificate chain")
+	}
+	// the leaf and its key are matched by localKeyID
+	keyID := sha1.Sum(chain[0])
+	id, err := asn1.Marshal(keyID[:])
+	if err != nil {
+		return nil, err
+	}
+	attrs := []pfxAttribute{
+		{ID: oidFriendlyName, Values: []asn1.RawValue{{Tag: asn1.TagBMPString, Bytes: bmpString(name)}}},
+		{ID: oidLocalKeyID, Values: []asn1.RawValue{{FullBytes: id}}},
+	}
+
+	var certs []pfxSafeBag
+	for i, c := range chain {
+		b, err := asn1.Marshal(pfxCertBag{ID: oidX509Certificate, Data: c})
+		if err != nil {
+			return nil, err
+		}
+		bag := pfxSafeBag{ID: oidCertBag, Value: pkcs12Explicit(b)}
+		if i == 0 {
+			bag.Attributes = attrs
+		}
+		certs = append(certs, bag)
+	}
+	pkey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := pkcs12EncryptKey(pkey, password)
+	if err != nil {
+		return nil, err
+	}
+	keys := []pfxSafeBag{{ID: oidShroudedKeyBag, Value: pkcs12Explicit(enc), Attributes: attrs}}
+
+	var safe []pfxContentInfo
+	for _, bags := range [][]pfxSafeBag{certs, keys} {
+		b, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := pkcs12Data(b)
+		if err != nil {
+			return nil, err
+		}
+		safe = append(safe, ci)
+	}
+	authSafe, err := asn1.Marshal(safe)
+	if err != nil {
+		return nil, err
+	}
+	pfx := pfxPDU{Version: 3}
+	if pfx.AuthSafe, err = pkcs12Data(authSafe); err != nil {
+		return nil, err
+	}
+	pfx.MacData.MacSalt = make([]byte, 16)
+	if _, err := rand.Read(pfx.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	pfx.MacData.Iterations = pkcs12Iterations
+	pfx.MacData.Mac.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	mac := hmac.New(sha256.New, pkcs12MACKey(password, pfx.MacData.MacSalt, pkcs12Iterations))
+	mac.Write(authSafe)
+	pfx.MacData.Mac.Digest = mac.Sum(nil)
+	return asn1.Marshal(pfx)
+}
+
+// pkcs12EncryptKey encrypts PKCS#8 encoded key with PBES2
+// and returns it as DER encoded EncryptedPrivateKeyInfo.
+func pkcs12EncryptKey(key []byte, password string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), salt, pkcs12Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(key)%aes.BlockSize
+	enc := append(append([]byte(nil), key...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(enc, enc)
+
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivb, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KDF:    pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		Cipher: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivb}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxEncryptedKey{
+		Algo: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data: enc,
+	})
+}
+
+// pkcs12MACKey derives the HMAC-SHA256 integrity key from password
+// as described in RFC 7292, appendix B.2, with ID 3.
+// A single SHA-256 output is the whole key, so unlike the general
+// algorithm it never needs to update the salt and password block.
+func pkcs12MACKey(password string, salt []byte, iter int) []byte {
+	const v = sha256.BlockSize
+	// fill repeats b up to a multiple of v bytes
+	fill := func(b []byte) []byte {
+		out := make([]byte, (len(b)+v-1)/v*v)
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	h := sha256.New()
+	h.Write(bytes.Repeat([]byte{3}, v))
+	h.Write(fill(salt))
+	// the password is a NUL terminated BMPString
+	h.Write(fill(append(bmpString(password), 0, 0)))
+	a := h.Sum(nil)
+	for i := 1; i < iter; i++ {
+		s := sha256.Sum256(a)
+		a = s[:]
+	}
+	return a
+}
+
+// pkcs12Data returns a ContentInfo of the data type holding content.
+func pkcs12Data(content []byte) (pfxContentInfo, error) {
+	b, err := asn1.Marshal(content)
+	if err != nil {
+		return pfxContentInfo{}, err
+	}
+	return pfxContentInfo{ContentType: oidPKCS7Data, Content: pkcs12Explicit(b)}, nil
+}
+
+// pkcs12Explicit wraps DER encoded der in an explicit [0] tag.
+// The encoding/asn1 package ignores the explicit field tag of a RawValue.
+func pkcs12Explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bmpString encodes s as the content of an ASN.1 BMPString:
+// each UTF-16 code unit as two big-endian bytes.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestPKCS12MACKey(t *testing.T) {
+	// openssl kdf -keylen 32 -kdfopt digest:SHA256 -kdfopt id:3 -kdfopt iter:2048
+	//   -kdfopt hexpass:<UTF-16BE "pässword" and NUL> -kdfopt salt:saltsalt PKCS12KDF
+	const want = "6d929d472af7133bc286385ac3f378e48864c403b09ad8c2927ca0c7f59a4a82"
+	key := pkcs12MACKey("pässword", []byte("saltsalt"), 2048)
+	if v := hex.EncodeToString(key); v != want {
+		t.Errorf("key = %s; want %s", v, want)
+	}
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := [][]byte{[]byte("leaf"), []byte("ca")}
+	b, err := encodePKCS12("example.com", key, chain, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pfx pfxPDU
+	if _, err := asn1.Unmarshal(b, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	if pfx.Version != 3 {
+		t.Errorf("version = %d", pfx.Version)
+	}
+	authSafe := pkcs12TestData(t, pfx.AuthSafe)
+	mac := hmac.New(sha256.New, pkcs12MACKey("changeit", pfx.MacData.MacSalt, pfx.MacData.Iterations))
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Fatal("MAC mismatch")
+	}
+
+	var safe []pfxContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &safe); err != nil {
+		t.Fatal(err)
+	}
+	if len(safe) != 2 {
+		t.Fatalf("len(safe) = %d; want 2", len(safe))
+	}
+	var certs, keys []pfxSafeBag
+	if _, err := asn1.Unmarshal(pkcs12TestData(t, safe[0]), &certs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(pkcs12TestData(t, safe[1]), &keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != len(chain) {
+		t.Fatalf("len(certs) = %d; want %d", len(certs), len(chain))
+	}
+	for i, bag := range certs {
+		var cb pfxCertBag
+		if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !bag.ID.Equal(oidCertBag) || !cb.ID.Equal(oidX509Certificate) {
+			t.Errorf("%d: bag = %v, cert = %v", i, bag.ID, cb.ID)
+		}
+		if !bytes.Equal(cb.Data, chain[i]) {
+			t.Errorf("%d: cert = %q; want %q", i, cb.Data, chain[i])
+		}
+	}
+	keyID := sha1.Sum(chain[0])
+	pkcs12TestAttrs(t, "leaf", certs[0].Attributes, keyID[:])
+	if len(certs[1].Attributes) != 0 {
+		t.Errorf("ca attributes = %v", certs[1].Attributes)
+	}
+
+	if len(keys) != 1 || !keys[0].ID.Equal(oidShroudedKeyBag) {
+		t.Fatalf("key bags = %v", keys)
+	}
+	pkcs12TestAttrs(t, "key", keys[0].Attributes, keyID[:])
+	var ek pfxEncryptedKey
+	if _, err := asn1.Unmarshal(keys[0].Value.Bytes, &ek); err != nil {
+		t.Fatal(err)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(ek.Algo.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatal(err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Cipher.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+	if !ek.Algo.Algorithm.Equal(oidPBES2) || !params.KDF.Algorithm.Equal(oidPBKDF2) ||
+		!kdf.PRF.Algorithm.Equal(oidHMACWithSHA256) || !params.Cipher.Algorithm.Equal(oidAES256CBC) {
+		t.Errorf("algorithms = %v, %v, %v, %v", ek.Algo.Algorithm, params.KDF.Algorithm, kdf.PRF.Algorithm, params.Cipher.Algorithm)
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte("changeit"), kdf.Salt, kdf.Iterations, 32, sha256.New))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(ek.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ek.Data)
+	plain = plain[:len(plain)-int(plain[len(plain)-1])]
+	pk, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		t.Fatalf("decrypted key: %v", err)
+	}
+	if !key.Equal(pk) {
+		t.Error("decrypted key does not match")
+	}
+}
+
+// pkcs12TestData returns the content of data ContentInfo ci.
+func pkcs12TestData(t *testing.T, ci pfxContentInfo) []byte {
+	if !ci.ContentType.Equal(oidPKCS7Data) {
+		t.Fatalf("content type = %v", ci.ContentType)
+	}
+	var b []byte
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// pkcs12TestAttrs checks the friendlyName and localKeyID bag attributes.
+func pkcs12TestAttrs(t *testing.T, bag string, attrs []pfxAttribute, keyID []byte) {
+	if len(attrs) != 2 {
+		t.Fatalf("%s: attributes = %v", bag, attrs)
+	}
+	for _, a := range attrs {
+		switch {
+		case a.ID.Equal(oidFriendlyName):
+			if v := a.Values[0]; v.Tag != asn1.TagBMPString || !bytes.Equal(v.Bytes, bmpString("example.com")) {
+				t.Errorf("%s: friendlyName = %v", bag, v)
+			}
+		case a.ID.Equal(oidLocalKeyID):
+			var id []byte
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &id); err != nil || !bytes.Equal(id, keyID) {
+				t.Errorf("%s: localKeyID = %x, %v; want %x", bag, id, err, keyID)
+			}
+		default:
+			t.Errorf("%s: unexpected attribute %v", bag, a.ID)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	cmdPromote = &command{
+		run:       runPromote,
+		UsageLine: "promote [-c config] [-ca name] [-k key] [-from url] domain",
+		Short:     "repeat a staging issuance with production CA",
+		Long: `
+Promote requests a certificate for the domain from the CA of the account
+selected with -ca argument, using exactly the same cert command arguments
+as a previous successful issuance with a staging CA.
+
+Every successful cert command records its arguments, the issuing CA,
+the certificate names and the key pin in domain.manifest.json file
+next to the key, one entry per CA. The -k argument specifies the key file
+as in the cert command, and thus the manifest location.
+
+The -from argument selects the directory URL or alias of the staging
+issuance to repeat. By default, the only manifest entry with "staging"
+in its directory URL is used.
+
+After the certificate is obtained, the new manifest entry is compared
+to the staging one and any difference is reported as an error.
+
+Default location of the config dir is {{.ConfigDir}}.
+		`,
+	}
+
+	promoteFrom    discoAliasFlag
+	promoteKeypath string
+)
+
+func init() {
+	cmdPromote.flag.Var(&promoteFrom, "from", "")
+	cmdPromote.flag.StringVar(&promoteKeypath, "k", "", "")
+}
+
+// issuanceManifest records a successful cert command run
+// to allow repeating it with another CA.
+type issuanceManifest struct {
+	CA      string            `json:"ca"`      // directory URL
+	Issued  time.Time         `json:"issued"`  // when the certificate was received
+	Domains []string          `json:"domains"` // cert command arguments, in order
+	Flags   map[string]string `json:"flags"`   // explicitly set cert command flags
+	SANs    []string          `json:"sans"`    // names in the issued certificate
+	KeyPin  string            `json:"keyPin"`  // SPKI pin of the certificate key
+	Chain   []string          `json:"chain"`   // hex SHA-256 of CA certificates
+	Changed bool              `json:"changed"` // differs from the previous certificate
+	URL     string            `json:"url"`     // certificate URL at the CA
+	CertID  string            `json:"certID"`  // ACME Renewal Information identifier
+	Files   map[string]string `json:"files"`   // absolute paths of written files
+}
+
+// manifestSkipFlags are cert flags which are not recorded in a manifest
+// because they select the config, account or CA, or do not affect
+// the outcome.
+var manifestSkipFlags = map[string]bool{
+	"c":     true,
+	"ca":    true,
+	"d":     true,
+	"json":  true,
+	"force": true,
+	// selects the config dir, like -c
+	"tenant": true,
+	// CA chains differ between CAs
+	"issuer": true,
+	// diagnostics, like -json
+	"trace": true,
+	// may hold credentials of the CA
+	"header": true,
+	// may hold proxy credentials, and the proxy differs between hosts
+	"proxy": true,
+}
+
+// newManifest creates a manifest of cert obtained by cert command
+// with domain arguments args. The files map names the written outputs:
+// "cert" for the certificate file, or -link if specified,
+// and "leaf", "chain" and "fullchain" for the corresponding flags.
+func newManifest(args []string, cert *certificate, files map[string]string) *issuanceManifest {
+	m := &issuanceManifest{
+		CA:      cert.ca,
+		Issued:  cert.issued,
+		Domains: args,
+		Flags:   explicitCertFlags(),
+		SANs:    append([]string(nil), cert.leaf.DNSNames...),
+		Changed: cert.changed,
+		URL:     cert.url,
+		CertID:  cert.ariCertID(),
+		Files:   make(map[string]string, len(files)),
+	}
+	for name, path := range files {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		m.Files[name] = path
+	}
+	sort.Strings(m.SANs)
+	if pin, err := spkiPin(cert.leaf.PublicKey); err == nil {
+		m.KeyPin = pin
+	}
+	for _, der := range cert.chain[1:] {
+		m.Chain = append(m.Chain, fmt.Sprintf("%x", sha256.Sum256(der)))
+	}
+	return m
+}
+
+// explicitCertFlags returns the values of cert command flags set
+// explicitly, except manifestSkipFlags.
+func explicitCertFlags() map[string]string {
+	flags := make(map[string]string)
+	certFlags.Visit(func(f *flag.Flag) {
+		if !manifestSkipFlags[f.Name] {
+			flags[f.Name] = f.Value.String()
+		}
+	})
+	return flags
+}
+
+// manifestPath returns the manifest file location of domain
+// whose key is stored in keyPath.
+func manifestPath(keyPath, domain string) string {
+	return sameDir(keyPath, domain+".manifest.json")
+}
+
+// readManifests reads all manifest entries from path, keyed by CA
+// directory URL.
+func readManifests(path string) (map[string]*issuanceManifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mm := make(map[string]*issuanceManifest)
+	return mm, json.Unmarshal(b, &mm)
+}
+
+// writeManifest adds m to the manifest file at path,
+// replacing a previous entry for the same CA.
+func writeManifest(path string, m *issuanceManifest) error {
+	mm, err := readManifests(path)
+	if os.IsNotExist(err) {
+		mm, err = make(map[string]*issuanceManifest), nil
+	}
+	if err != nil {
+		return err
+	}
+	mm[m.CA] = m
+	b, err := json.MarshalIndent(mm, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// diff returns a description of differences between m and other,
+// ignoring the CA and issuance time.
+func (m *issuanceManifest) diff(other *issuanceManifest) []string {
+	var d []string
+	if !reflect.DeepEqual(m.Domains, other.Domains) {
+		d = append(d, fmt.Sprintf("domains %v != %v", m.Domains, other.Domains))
+	}
+	if !reflect.DeepEqual(m.SANs, other.SANs) {
+		d = append(d, fmt.Sprintf("certificate names %v != %v", m.SANs, other.SANs))
+	}
+	if m.KeyPin != other.KeyPin {
+		d = append(d, fmt.Sprintf("key %s != %s", m.KeyPin, other.KeyPin))
+	}
+	names := make(map[string]bool)
+	for n := range m.Flags {
+		names[n] = true
+	}
+	for n := range other.Flags {
+		names[n] = true
+	}
+	var sorted []string
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+	for _, n := range sorted {
+		v1, ok1 := m.Flags[n]
+		v2, ok2 := other.Flags[n]
+		if v1 != v2 || ok1 != ok2 {
+			d = append(d, fmt.Sprintf("-%s %q != %q", n, v1, v2))
+		}
+	}
+	return d
+}
+
+// stagingManifest returns the manifest entry of mm to promote.
+// If from is not empty, it is the entry's CA; otherwise the CA URL
+// of the only entry must contain "staging".
+func stagingManifest(mm map[string]*issuanceManifest, from string) (*issuanceManifest, error) {
+	if from != "" {
+		m, ok := mm[from]
+		if !ok {
+			return nil, fmt.Errorf("no issuance with %s recorded", from)
+		}
+		return m, nil
+	}
+	var found []*issuanceManifest
+	for ca, m := range mm {
+		if strings.Contains(ca, "staging") {
+			found = append(found, m)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no staging issuance recorded; use -from")
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("multiple staging issuances recorded; use -from")
+}
+
+func runPromote(args []string) {
+	if len(args) != 1 {
+		fatalf("promote requires exactly one domain argument")
+	}
+	domain, err := normalizeDomain(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	kp := promoteKeypath
+	if kp == "" {
+		kp = filepath.Join(configDir, domain+".key")
+	}
+	mpath := manifestPath(kp, domain)
+	mm, err := readManifests(mpath)
+	if err != nil {
+		fatalf("read manifest: %v", err)
+	}
+	staging, err := stagingManifest(mm, string(promoteFrom))
+	if err != nil {
+		fatalf("%s: %v", mpath, err)
+	}
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	ca := uc.CA
+	if ca == "" {
+		ca = string(defaultDiscoFlag)
+	}
+	if ca == staging.CA {
+		fatalf("account is with the same CA as the recorded issuance, %s; select another one with -ca", ca)
+	}
+
+	// replay the cert command with the recorded arguments
+	addFlags(&cmdCert.flag)
+	for name, value := range staging.Flags {
+		if err := setCertFlag(name, value); err != nil {
+			fatalf("%s: -%s: %v", mpath, name, err)
+		}
+	}
+	certForce = true
+	logf("promoting %s issuance of %s to %s", staging.CA, strings.Join(staging.Domains, ", "), ca)
+	runCert(append([]string(nil), staging.Domains...))
+
+	mm, err = readManifests(mpath)
+	if err != nil {
+		fatalf("read manifest: %v", err)
+	}
+	prod, ok := mm[ca]
+	if !ok {
+		fatalf("%s: no manifest recorded for %s", mpath, ca)
+	}
+	for _, d := range staging.diff(prod) {
+		errorf("differs from staging: %s", d)
+	}
+}
+
+// repeatableFlag is a flag value which may be set multiple times.
+// Its String method joins the values with spaces; the values themselves
+// cannot contain spaces.
+type repeatableFlag interface {
+	flag.Value
+	repeatable()
+}
+
+func (*extFlag) repeatable()       {}
+func (*checkFromFlag) repeatable() {}
+
+// setCertFlag sets cert command flag name to value recorded in a manifest.
+// Repeatable flags are recorded as space separated values.
+func setCertFlag(name, value string) error {
+	f := cmdCert.flag.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown flag -%s", name)
+	}
+	if _, ok := f.Value.(repeatableFlag); !ok {
+		return cmdCert.flag.Set(name, value)
+	}
+	for _, v := range strings.Fields(value) {
+		if err := cmdCert.flag.Set(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -14,6 +14,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -24,7 +25,7 @@ import (
 var (
 	cmdReg = &command{
 		run:       runReg,
-		UsageLine: "reg [-c config] [-gen] [-accept] [-d url] [contact [contact ...]]",
+		UsageLine: "reg [-c config] [-ca name] [-gen [-key-type type]] [-accept] [-d url] [contact [contact ...]]",
 		Short:     "new account registration",
 		Long: `
 Reg creates a new account at a CA using the discovery URL
@@ -38,7 +39,11 @@ If the config dir does not exist, it will be created.
 
 Contact arguments can be anything: email, phone number, etc.
 
-The -gen flag will generate an ECDSA P-256 keypair to use as the account key.
+The -gen flag will generate a keypair to use as the account key,
+of the type specified with -key-type: ec256 or ec384 for ECDSA
+P-256 or P-384, or rsa2048, rsa3072 or rsa4096 for RSA keys
+of that many bits. The default is {{.DefaultKeyType}}.
+Existing keys below RSA 2048 or ECDSA P-256 are rejected.
 
 If -gen flag is not specified, and a file named account.key containing
 a PEM-encoded ECDSA or RSA private key does not exist, the command will exit
@@ -48,11 +53,17 @@ The registration may require the user to agree to the CA Terms of Service (TOS).
 If so, and the -accept argument is not provided, the command prompts the user
 with a TOS URL provided by the CA.
 
+With -ca argument, the account is registered under the given name,
+allowing a single config dir to hold accounts with multiple CAs.
+If the name is one of the directory aliases and -d is not specified,
+the alias URL is used. Without -gen, such an account uses the key
+shared by all accounts in the config dir, unless it has its own.
+
 See also: acme help account.
 		`,
 	}
 
-	regDisco  = defaultDiscoFlag
+	regDisco  discoAliasFlag // defaults to defaultDiscoFlag
 	regGen    bool
 	regAccept bool
 )
@@ -61,15 +72,45 @@ func init() {
 	cmdReg.flag.Var(&regDisco, "d", "")
 	cmdReg.flag.BoolVar(&regGen, "gen", regGen, "")
 	cmdReg.flag.BoolVar(&regAccept, "accept", regAccept, "")
+	cmdReg.flag.StringVar(&keyType, "key-type", "", "")
 }
 
 func runReg(args []string) {
-	key, err := anyKey(filepath.Join(configDir, accountKey), regGen)
+	if regDisco == "" {
+		regDisco = defaultDiscoFlag
+		if a, ok := discoAliases[flagCA]; ok {
+			regDisco = discoAliasFlag(a)
+		}
+	}
+	kp := accountKeyPath()
+	if regGen {
+		kp = filepath.Join(accountDir(), accountKey)
+		if err := os.MkdirAll(accountDir(), 0700); err != nil {
+			fatalf("account key: %v", err)
+		}
+	}
+	if err := checkKeyType(keyType); err != nil {
+		fatalf("-key-type: %v", err)
+	}
+	if err := checkAccountKeyType(keyType); err != nil {
+		fatalf("-key-type: %v", err)
+	}
+	key, err := anyKey(kp, regGen)
 	if err != nil {
 		fatalf("account key: %v", err)
 	}
+	if err := checkFIPSKey(key.Public()); err != nil {
+		fatalf("account key: %v", err)
+	}
+	if err := checkAccountKey(key.Public()); err != nil {
+		fatalf("account key: %v", err)
+	}
+	if err := checkKeyStrength(key.Public()); err != nil {
+		fatalf("account key: %v", err)
+	}
 	uc := &userConfig{
 		Account: acme.Account{Contact: args},
+		CA:      string(regDisco),
 		key:     key,
 	}
 
@@ -77,14 +118,17 @@ func runReg(args []string) {
 	if regAccept {
 		prompt = acme.AcceptTOS
 	}
-	client := &acme.Client{
-		Key:          uc.key,
-		DirectoryURL: string(regDisco),
+	client, err := newClient(uc.key, uc.CA)
+	if err != nil {
+		fatalf("-d: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
+	if uc.CAFingerprint, err = discoverCA(ctx, client); err != nil {
+		fatalf("%v", err)
+	}
 	a, err := client.Register(ctx, &uc.Account, prompt)
 	if err != nil {
 		fatalf("%v", err)
@@ -0,0 +1,278 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdRevoke = &command{
+		run:       runRevoke,
+		UsageLine: "revoke [-c config] [-ca name] [-d url] [-reason name] [-key file | -spki pin] [-all-matching] cert|dir ...",
+		Short:     "revoke certificates",
+		Long: `
+Revoke asks the CA to revoke the certificates in the PEM files
+given as arguments. Only the first certificate of each file, the leaf,
+is revoked; CA certificates bundled with it are ignored.
+
+The -reason argument specifies the revocation reason. Supported reasons are:
+unspecified (default), keyCompromise, affiliationChanged, superseded,
+cessationOfOperation and privilegeWithdrawn.
+
+Requests are signed with the account key, unless -key specifies
+the private key of the certificates. A CA accepts the latter for
+any certificate issued for the key, including by other accounts.
+The CA is the one of the account, unless specified with -d,
+or {{.DefaultDisco}} if the account has not recorded one.
+
+With -all-matching, the arguments are directories, {{.ConfigDir}}
+by default, which are searched for *.crt and *.pem certificate files
+issued for the key specified with -key, or with -spki as a base64 SHA-256
+pin of the public key. All of them are revoked, skipping expired ones
+and duplicates.
+
+With -reason keyCompromise, the key file of each revoked certificate,
+expected next to it with .key extension, is renamed to have .compromised
+appended, so that a fresh key is generated and a new certificate
+is requested by the next cert command run.
+		`,
+	}
+
+	revokeDisco   discoAliasFlag // defaults to account's CA
+	revokeReason  = "unspecified"
+	revokeKey     string
+	revokeSPKI    string
+	revokeMatches bool
+)
+
+// revokeReasons maps -reason values to CRL reason codes
+// which can be requested by subscribers.
+var revokeReasons = map[string]acme.CRLReasonCode{
+	"unspecified":          acme.CRLReasonUnspecified,
+	"keyCompromise":        acme.CRLReasonKeyCompromise,
+	"affiliationChanged":   acme.CRLReasonAffiliationChanged,
+	"superseded":           acme.CRLReasonSuperseded,
+	"cessationOfOperation": acme.CRLReasonCessationOfOperation,
+	"privilegeWithdrawn":   acme.CRLReasonPrivilegeWithdrawn,
+}
+
+func init() {
+	cmdRevoke.flag.Var(&revokeDisco, "d", "")
+	cmdRevoke.flag.StringVar(&revokeReason, "reason", revokeReason, "")
+	cmdRevoke.flag.StringVar(&revokeKey, "key", "", "")
+	cmdRevoke.flag.StringVar(&revokeSPKI, "spki", "", "")
+	cmdRevoke.flag.BoolVar(&revokeMatches, "all-matching", false, "")
+}
+
+func runRevoke(args []string) {
+	reason, ok := revokeReasons[revokeReason]
+	if !ok {
+		fatalf("-reason: unknown reason %q", revokeReason)
+	}
+	if revokeKey != "" && revokeSPKI != "" {
+		fatalf("-key and -spki are mutually exclusive, only one should be specified")
+	}
+	var key crypto.Signer
+	pin := revokeSPKI
+	if revokeKey != "" {
+		var err error
+		if key, err = readKey(revokeKey); err != nil {
+			fatalf("-key: %v", err)
+		}
+		if err := checkFIPSKey(key.Public()); err != nil {
+			fatalf("-key: %v", err)
+		}
+		if pin, err = spkiPin(key.Public()); err != nil {
+			fatalf("-key: %v", err)
+		}
+	}
+
+	var files []string
+	if revokeMatches {
+		if pin == "" {
+			fatalf("-all-matching requires -key or -spki")
+		}
+		if len(args) == 0 {
+			args = []string{configDir}
+		}
+		var err error
+		if files, err = matchingCerts(args, pin); err != nil {
+			fatalf("%v", err)
+		}
+		if len(files) == 0 {
+			fatalf("no certificates found for key %s", pin)
+		}
+	} else {
+		if len(args) == 0 {
+			fatalf("no certificate specified")
+		}
+		files = args
+	}
+
+	disco := string(revokeDisco)
+	if key == nil || disco == "" {
+		uc, err := readConfig()
+		if err != nil {
+			fatalf("read config: %v", err)
+		}
+		if key == nil {
+			if uc.key == nil {
+				fatalf("no key found for %s", uc.URI)
+			}
+			key = uc.key
+		}
+		if disco == "" {
+			disco = uc.CA
+		}
+		if disco == "" {
+			disco = string(defaultDiscoFlag)
+		}
+	}
+	client, err := newClient(key, disco)
+	if err != nil {
+		fatalf("%s: %v", disco, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	ctx, cancel = interruptContext(ctx)
+	defer cancel()
+
+	for _, f := range files {
+		leaf, err := readLeaf(f)
+		if err != nil {
+			errorf("%s: %v", f, err)
+			continue
+		}
+		if pin != "" {
+			if p, err := spkiPin(leaf.PublicKey); err != nil || p != pin {
+				errorf("%s: certificate is not issued for key %s", f, pin)
+				continue
+			}
+		}
+		if err := client.RevokeCert(ctx, key, leaf.Raw, reason); err != nil {
+			errorf("%s: revoke: %v", f, err)
+			continue
+		}
+		logf("%s: revoked certificate %x", f, leaf.SerialNumber)
+		if reason != acme.CRLReasonKeyCompromise {
+			continue
+		}
+		moved, err := retireKey(f, leaf.PublicKey)
+		switch {
+		case err != nil:
+			errorf("%s: %v", f, err)
+		case moved != "":
+			logf("%s: compromised key moved to %s; run cert to replace it", f, moved)
+		}
+	}
+}
+
+// readLeaf reads the first certificate of a PEM or DER encoded file.
+func readLeaf(path string) (*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for rest := b; ; {
+		var p *pem.Block
+		if p, rest = pem.Decode(rest); p == nil {
+			break
+		}
+		if p.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(p.Bytes)
+		}
+	}
+	return x509.ParseCertificate(b)
+}
+
+// matchingCerts returns certificate files in dirs, with .crt or .pem
+// extension, which are issued for the key with SPKI pin and not expired.
+// Of files containing the same certificate only the first one is returned.
+func matchingCerts(dirs []string, pin string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool) // by certificate serial number
+	for _, dir := range dirs {
+		ff, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, fi := range ff {
+			ext := filepath.Ext(fi.Name())
+			if fi.Mode().IsRegular() && (ext == ".crt" || ext == ".pem") {
+				names = append(names, fi.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			leaf, err := readLeaf(path)
+			if err != nil {
+				continue // not a certificate, e.g. a key in .pem file
+			}
+			if p, err := spkiPin(leaf.PublicKey); err != nil || p != pin {
+				continue
+			}
+			if !timeNow().Before(leaf.NotAfter) {
+				logf("%s: skipping expired certificate", path)
+				continue
+			}
+			id := leaf.Issuer.String() + "/" + leaf.SerialNumber.String()
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// retireKey renames the key file of the certificate at certPath,
+// with the same name and .key extension, by appending .compromised to it.
+// It does nothing and returns an empty string if the file does not exist
+// or does not hold the private key of pub.
+func retireKey(certPath string, pub crypto.PublicKey) (string, error) {
+	path := strings.TrimSuffix(certPath, filepath.Ext(certPath)) + ".key"
+	k, err := readKey(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	want, err := spkiPin(pub)
+	if err != nil {
+		return "", err
+	}
+	if have, err := spkiPin(k.Public()); err != nil || have != want {
+		return "", nil
+	}
+	dst := path + ".compromised"
+	if _, err := os.Stat(dst); err == nil {
+		return "", errors.New(dst + " already exists")
+	}
+	return dst, os.Rename(path, dst)
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMatchingCerts(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	dir, err := ioutil.TempDir("", "acme-revoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string, serial int64, k *ecdsa.PrivateKey, notAfter time.Time) {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			DNSNames:     []string{"example.com"},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, k.Public(), k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pemCerts([][]byte{der}), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create("a.example.com.crt", 1, key, now.Add(time.Hour))
+	create("a.example.com.pem", 1, key, now.Add(time.Hour)) // duplicate
+	create("b.example.com.crt", 2, key, now.Add(time.Hour))
+	create("c.example.com.crt", 3, key, now.Add(-time.Minute)) // expired
+	create("d.example.com.crt", 4, other, now.Add(time.Hour))
+	create("e.example.com.der", 5, key, now.Add(time.Hour)) // ignored extension
+	if err := writeKey(filepath.Join(dir, "a.example.com.key"), key); err != nil {
+		t.Fatal(err)
+	}
+
+	pin, err := spkiPin(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := matchingCerts([]string{dir}, pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "a.example.com.crt"),
+		filepath.Join(dir, "b.example.com.crt"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("matchingCerts = %q; want %q", files, want)
+	}
+
+	// key of b does not exist, key of a is moved away
+	if moved, err := retireKey(want[1], key.Public()); err != nil || moved != "" {
+		t.Errorf("retireKey(b) = %q, %v; want no-op", moved, err)
+	}
+	if moved, err := retireKey(want[0], other.Public()); err != nil || moved != "" {
+		t.Errorf("retireKey(a, other) = %q, %v; want no-op", moved, err)
+	}
+	moved, err := retireKey(want[0], key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != filepath.Join(dir, "a.example.com.key.compromised") {
+		t.Errorf("moved = %q", moved)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.example.com.key")); !os.IsNotExist(err) {
+		t.Errorf("key still exists: %v", err)
+	}
+}
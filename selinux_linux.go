@@ -0,0 +1,20 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "syscall"
+
+// setSELinuxContext sets the SELinux security context of the file at path.
+func setSELinuxContext(path, context string) error {
+	// the kernel expects a NUL terminated string
+	return syscall.Setxattr(path, "security.selinux", append([]byte(context), 0), 0)
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// settingsFile holds default command flag values.
+const settingsFile = "settings.json"
+
+// commandSettings maps command names to flag names to their default values.
+// A value is a JSON string, number or bool, or an array of those
+// for a repeatable flag.
+type commandSettings map[string]map[string]interface{}
+
+// applySettings sets flags of cmd which were not specified explicitly
+// to the values found in settings files: the one in configDir first,
+// then the one in accountDir, if different, which takes precedence.
+// Missing files are ignored.
+func applySettings(cmd *command) error {
+	explicit := make(map[string]bool)
+	cmd.flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	dirs := []string{configDir}
+	if d := accountDir(); d != configDir {
+		dirs = append(dirs, d)
+	}
+	values := make(map[string]interface{})
+	for _, dir := range dirs {
+		s, err := readSettings(filepath.Join(dir, settingsFile))
+		if err != nil {
+			return err
+		}
+		for name, v := range s[cmd.Name()] {
+			values[name] = v
+		}
+	}
+	for name, v := range values {
+		if explicit[name] {
+			continue
+		}
+		if name == "c" || name == "ca" || name == "tenant" {
+			return fmt.Errorf("%s: -%s cannot be set in %s", cmd.Name(), name, settingsFile)
+		}
+		if err := setFlag(&cmd.flag, name, v); err != nil {
+			return fmt.Errorf("%s: %s: -%s: %v", settingsFile, cmd.Name(), name, err)
+		}
+	}
+	return nil
+}
+
+// readSettings reads settings from path.
+// A missing file results in empty settings and no error.
+func readSettings(path string) (commandSettings, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s commandSettings
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// setFlag sets flag name of fs to v, a value decoded from JSON.
+// Each element of an array is set in turn.
+func setFlag(fs *flag.FlagSet, name string, v interface{}) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if err := setFlag(fs, name, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case string:
+		return fs.Set(name, v)
+	case bool:
+		return fs.Set(name, fmt.Sprint(v))
+	case float64:
+		// not fmt.Sprint, which formats large integers as 1e+06
+		return fs.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplySettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c, ca string) { configDir, flagCA = c, ca }(configDir, flagCA)
+	configDir, flagCA = dir, "staging"
+
+	shared := `{"test": {"s": "shared", "b": true, "n": 5, "list": ["x", "y"]}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, settingsFile), []byte(shared), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(accountDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	own := `{"test": {"s": "own"}, "other": {"s": "other"}}`
+	if err := ioutil.WriteFile(filepath.Join(accountDir(), settingsFile), []byte(own), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		s    string
+		b    bool
+		n    int
+		list extFlag
+	)
+	newCmd := func() *command {
+		s, b, n, list = "", false, 0, nil
+		cmd := &command{UsageLine: "test"}
+		cmd.flag.StringVar(&s, "s", "", "")
+		cmd.flag.BoolVar(&b, "b", false, "")
+		cmd.flag.IntVar(&n, "n", 0, "")
+		cmd.flag.Var(&list, "list", "")
+		if err := cmd.flag.Parse([]string{"-n", "7"}); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+	if err := applySettings(newCmd()); err == nil {
+		t.Fatal("applySettings: no error for invalid -list values")
+	}
+
+	shared = `{"test": {"s": "shared", "b": true, "n": 5, "list": ["1.2.3=05", "1.2.4=05"]}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, settingsFile), []byte(shared), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := applySettings(newCmd()); err != nil {
+		t.Fatal(err)
+	}
+	if s != "own" || !b || n != 7 || len(list) != 2 {
+		t.Errorf("s, b, n, len(list) = %q, %v, %d, %d; want own, true, 7, 2", s, b, n, len(list))
+	}
+	if want := "1.2.3=05 1.2.4=05"; list.String() != want {
+		t.Errorf("list = %q; want %q", list.String(), want)
+	}
+}
+
+func TestSetFlagNumber(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	n := fs.Int("n", 0, "")
+	f := fs.Float64("f", 0, "")
+	if err := setFlag(fs, "n", float64(1000000)); err != nil || *n != 1000000 {
+		t.Errorf("setFlag(1000000): %v, n = %d", err, *n)
+	}
+	if err := setFlag(fs, "f", 0.5); err != nil || *f != 0.5 {
+		t.Errorf("setFlag(0.5): %v, f = %v", err, *f)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// certSigner is the URL of a certificate key kept by a remote signer
+// service, used instead of a local key file. It is set with -signer flag
+// of cert command.
+var certSigner string
+
+// signerTimeout limits a single request to a remote signer.
+const signerTimeout = 30 * time.Second
+
+// remoteSigner is a crypto.Signer whose private key is kept
+// by a remote signer service at url:
+//
+//	GET url returns the public key in PEM "PUBLIC KEY" form.
+//	POST url with a signRequest JSON body signs a digest
+//	and returns a signResponse.
+//
+// Requests carry a bearer token from ACME_SIGNER_TOKEN environment
+// variable, if set. The token is only sent over https,
+// or plain http to a loopback address.
+type remoteSigner struct {
+	url    string
+	token  string
+	client *http.Client
+	pub    crypto.PublicKey
+}
+
+// signRequest is the body of a remote signer sign request.
+type signRequest struct {
+	Digest string `json:"digest"`        // base64 encoded; the message itself for Ed25519
+	Hash   string `json:"hash"`          // e.g. SHA-256; empty for Ed25519
+	PSS    bool   `json:"pss,omitempty"` // RSA-PSS with salt length equal to the hash size
+}
+
+// signResponse is the body of a remote signer sign response.
+type signResponse struct {
+	Signature string `json:"signature"` // base64 encoded
+}
+
+// newRemoteSigner returns a signer of the key at url,
+// fetching its public key.
+func newRemoteSigner(url string) (*remoteSigner, error) {
+	s := &remoteSigner{
+		url:    url,
+		token:  os.Getenv("ACME_SIGNER_TOKEN"),
+		client: &http.Client{Timeout: signerTimeout},
+	}
+	if s.token != "" && !secureSignerURL(url) {
+		return nil, fmt.Errorf("%s: ACME_SIGNER_TOKEN is set; signer URL must be https or a loopback address", url)
+	}
+	b, err := s.do("GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	d, _ := pem.Decode(b)
+	if d == nil || d.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s: no PEM PUBLIC KEY found", url)
+	}
+	if s.pub, err = x509.ParsePKIXPublicKey(d.Bytes); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	return s, nil
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := signRequest{Digest: base64.StdEncoding.EncodeToString(digest)}
+	if h := opts.HashFunc(); h != 0 {
+		req.Hash = h.String()
+	}
+	if o, ok := opts.(*rsa.PSSOptions); ok {
+		if o.SaltLength != rsa.PSSSaltLengthEqualsHash {
+			return nil, errors.New("remote signer: only PSS salt length equal to the hash size is supported")
+		}
+		req.PSS = true
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.do("POST", body)
+	if err != nil {
+		return nil, err
+	}
+	var res signResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.url, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(res.Signature)
+	if err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("%s: invalid signature %q", s.url, res.Signature)
+	}
+	return sig, nil
+}
+
+// secureSignerURL reports whether a bearer token may be sent to rawurl:
+// it is https, or http to localhost or a loopback IP address.
+func secureSignerURL(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+// do sends a request with body, if not nil, to the signer
+// and returns the body of a successful response.
+func (s *remoteSigner) do(method string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s: %s", s.url, res.Status, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
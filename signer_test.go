@@ -0,0 +1,128 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeSigner serves the remote signer interface for key.
+func fakeSigner(key crypto.Signer, token string) *httptest.Server {
+	hashes := map[string]crypto.Hash{"": 0, "SHA-256": crypto.SHA256, "SHA-384": crypto.SHA384}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "GET" {
+			der, err := x509.MarshalPKIXPublicKey(key.Public())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			return
+		}
+		var req signRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		digest, _ := base64.StdEncoding.DecodeString(req.Digest)
+		sig, err := key.Sign(rand.Reader, digest, hashes[req.Hash])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(signResponse{Signature: base64.StdEncoding.EncodeToString(sig)})
+	}))
+}
+
+func TestRemoteSigner(t *testing.T) {
+	defer os.Setenv("ACME_SIGNER_TOKEN", os.Getenv("ACME_SIGNER_TOKEN"))
+	os.Setenv("ACME_SIGNER_TOKEN", "secret")
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []crypto.Signer{ec, ed} {
+		ts := fakeSigner(key, "secret")
+		s, err := newRemoteSigner(ts.URL + "/keys/example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := newCSR(s, "", []string{"example.com"}, nil)
+		if err != nil {
+			t.Errorf("%T: newCSR: %v", key, err)
+			ts.Close()
+			continue
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			t.Errorf("%T: CSR signature: %v", key, err)
+		}
+		ts.Close()
+	}
+
+	ts := fakeSigner(ec, "other")
+	defer ts.Close()
+	if _, err := newRemoteSigner(ts.URL); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("wrong token: %v", err)
+	}
+}
+
+func TestSecureSignerURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://signer.example.com/keys/a", true},
+		{"http://127.0.0.1:8080/keys/a", true},
+		{"http://[::1]/keys/a", true},
+		{"http://localhost/keys/a", true},
+		{"http://signer.example.com/keys/a", false},
+		{"http://10.0.0.1/keys/a", false},
+		{"ftp://signer.example.com/keys/a", false},
+		{"signer.example.com", false},
+	}
+	for _, test := range tests {
+		if ok := secureSignerURL(test.url); ok != test.ok {
+			t.Errorf("secureSignerURL(%q) = %v; want %v", test.url, ok, test.ok)
+		}
+	}
+
+	defer os.Setenv("ACME_SIGNER_TOKEN", os.Getenv("ACME_SIGNER_TOKEN"))
+	os.Setenv("ACME_SIGNER_TOKEN", "secret")
+	if _, err := newRemoteSigner("http://signer.example.com/keys/a"); err == nil || !strings.Contains(err.Error(), "https") {
+		t.Errorf("newRemoteSigner over plain http: %v", err)
+	}
+}
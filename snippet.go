@@ -0,0 +1,140 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+var (
+	cmdSnippet = &command{
+		run:       runSnippet,
+		UsageLine: "snippet [-c config] [-server nginx] [-k key] domain",
+		Short:     "print web server TLS configuration",
+		Long: `
+Snippet prints a web server configuration stanza which points
+to the certificate and key files of the domain, as created by the cert command.
+
+The -server argument selects configuration syntax. Supported servers are:
+{{range $name, $t := .SnippetServers}}
+	{{$name}}{{end}}
+
+The -k argument specifies the key file location, as with the cert command.
+Default location for the key file is {{.ConfigDir}}/domain.key.
+The certificate file is taken from the latest issuance recorded
+in domain.manifest.json file next to the key: the -fullchain file,
+if the cert command wrote one, or the certificate file, as named by -out
+or -link arguments. Without a manifest, the certificate file is expected
+to be domain.crt next to the key.
+		`,
+	}
+
+	snippetServer = "nginx"
+	snippetKey    string
+
+	// snippetServers are configuration templates for -server argument.
+	// The template context is snippetData.
+	snippetServers = map[string]string{
+		"nginx": `server {
+	listen 443 ssl;
+	server_name {{.Domain}};
+	ssl_certificate {{.Cert}};
+	ssl_certificate_key {{.Key}};
+}
+`,
+		"apache": `<VirtualHost *:443>
+	ServerName {{.Domain}}
+	SSLEngine on
+	SSLCertificateFile {{.Cert}}
+	SSLCertificateKeyFile {{.Key}}
+</VirtualHost>
+`,
+		"haproxy": `# HAProxy expects the certificate and key in a single file:
+#   cat {{.Cert}} {{.Key}} > {{.Combined}}
+frontend {{.Domain}}
+	bind :443 ssl crt {{.Combined}}
+`,
+	}
+)
+
+// snippetData is the context of snippetServers templates.
+type snippetData struct {
+	Domain   string
+	Cert     string // certificate and CA chain file
+	Key      string // certificate key file
+	Combined string // certificate and key file, for servers which need one
+}
+
+func init() {
+	cmdSnippet.flag.StringVar(&snippetServer, "server", snippetServer, "")
+	cmdSnippet.flag.StringVar(&snippetKey, "k", "", "")
+}
+
+func runSnippet(args []string) {
+	if len(args) != 1 {
+		fatalf("exactly one domain must be specified")
+	}
+	domain, err := normalizeDomain(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if _, ok := snippetServers[snippetServer]; !ok {
+		fatalf("-server: unsupported server %q", snippetServer)
+	}
+	if snippetKey == "" {
+		snippetKey = filepath.Join(configDir, domain+".key")
+	}
+	if err := printSnippet(os.Stdout, snippetServer, domain, snippetKey); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// printSnippet writes configuration for server to w.
+func printSnippet(w io.Writer, server, domain, key string) error {
+	key, err := filepath.Abs(key)
+	if err != nil {
+		return err
+	}
+	data := snippetData{
+		Domain:   domain,
+		Cert:     snippetCert(domain, key),
+		Key:      key,
+		Combined: sameDir(key, domain+".pem"),
+	}
+	t := template.Must(template.New("snippet").Parse(snippetServers[server]))
+	return t.Execute(w, data)
+}
+
+// snippetCert returns the certificate file of domain with key
+// written by the latest cert command run recorded in the manifest
+// next to key, or the default certificate file if none is recorded.
+func snippetCert(domain, key string) string {
+	mm, err := readManifests(manifestPath(key, domain))
+	if err != nil {
+		return sameDir(key, domain+".crt")
+	}
+	var latest *issuanceManifest
+	for _, m := range mm {
+		if latest == nil || m.Issued.After(latest.Issued) {
+			latest = m
+		}
+	}
+	for _, name := range []string{"fullchain", "cert"} {
+		if latest != nil && latest.Files[name] != "" {
+			return latest.Files[name]
+		}
+	}
+	return sameDir(key, domain+".crt")
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintSnippet(t *testing.T) {
+	for server := range snippetServers {
+		var buf bytes.Buffer
+		if err := printSnippet(&buf, server, "example.com", "/etc/acme/example.com.key"); err != nil {
+			t.Errorf("%s: %v", server, err)
+			continue
+		}
+		out := buf.String()
+		if !strings.Contains(out, "/etc/acme/example.com.") {
+			t.Errorf("%s: no file paths in output:\n%s", server, out)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := printSnippet(&buf, "nginx", "example.com", "/etc/acme/example.com.key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"ssl_certificate /etc/acme/example.com.crt;",
+		"ssl_certificate_key /etc/acme/example.com.key;",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("nginx output lacks %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestSnippetCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-snippet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := filepath.Join(dir, "example.com.key")
+	if p := snippetCert("example.com", key); p != filepath.Join(dir, "example.com.crt") {
+		t.Errorf("no manifest: %q", p)
+	}
+
+	path := manifestPath(key, "example.com")
+	staging := &issuanceManifest{
+		CA:     "https://staging/directory",
+		Issued: time.Now().Add(-time.Hour),
+		Files:  map[string]string{"cert": "/etc/ssl/staging.crt"},
+	}
+	prod := &issuanceManifest{
+		CA:     "https://prod/directory",
+		Issued: time.Now(),
+		Files:  map[string]string{"cert": "/etc/ssl/current.crt"},
+	}
+	for _, m := range []*issuanceManifest{staging, prod} {
+		if err := writeManifest(path, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p := snippetCert("example.com", key); p != "/etc/ssl/current.crt" {
+		t.Errorf("latest cert = %q; want /etc/ssl/current.crt", p)
+	}
+	prod.Files["fullchain"] = "/etc/ssl/fullchain.pem"
+	if err := writeManifest(path, prod); err != nil {
+		t.Fatal(err)
+	}
+	if p := snippetCert("example.com", key); p != "/etc/ssl/fullchain.pem" {
+		t.Errorf("fullchain = %q; want /etc/ssl/fullchain.pem", p)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flagTrace makes the timing of every request to the CA reported.
+// It is set with -trace flag, common to all subcommands.
+var flagTrace bool
+
+// requestTiming is the timing breakdown of a single request,
+// as reported with -trace. Durations are in seconds; the phases
+// of establishing a connection are zero if one was reused.
+type requestTiming struct {
+	Event   string  `json:"event"` // always "request"
+	Method  string  `json:"method"`
+	URL     string  `json:"url"`
+	Status  int     `json:"status,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Reused  bool    `json:"reused"` // an idle connection was reused
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	TLS     float64 `json:"tls"`
+	TTFB    float64 `json:"ttfb"`  // until the first response byte
+	Total   float64 `json:"total"` // until the response was received
+
+	mu                  sync.Mutex // guards the fields below
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	wrote, firstByte    time.Time
+}
+
+// clientTrace returns an httptrace.ClientTrace recording into rt.
+func (rt *requestTiming) clientTrace() *httptrace.ClientTrace {
+	at := func(t *time.Time) {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		if t.IsZero() {
+			*t = timeNow()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { at(&rt.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { at(&rt.dnsDone) },
+		ConnectStart:      func(string, string) { at(&rt.connStart) },
+		ConnectDone:       func(string, string, error) { at(&rt.connDone) },
+		TLSHandshakeStart: func() { at(&rt.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { at(&rt.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.mu.Lock()
+			rt.Reused = info.Reused
+			rt.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(&rt.wrote) },
+		GotFirstResponseByte: func() { at(&rt.firstByte) },
+	}
+}
+
+// finish computes the durations of rt, for a request started at start,
+// with its outcome res or err.
+func (rt *requestTiming) finish(start time.Time, res *http.Response, err error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	since := func(a, b time.Time) float64 {
+		if a.IsZero() || b.IsZero() {
+			return 0
+		}
+		return b.Sub(a).Seconds()
+	}
+	rt.Event = "request"
+	rt.DNS = since(rt.dnsStart, rt.dnsDone)
+	rt.Connect = since(rt.connStart, rt.connDone)
+	rt.TLS = since(rt.tlsStart, rt.tlsDone)
+	rt.TTFB = since(rt.wrote, rt.firstByte)
+	rt.Total = since(start, timeNow())
+	if err != nil {
+		rt.Error = err.Error()
+	} else {
+		rt.Status = res.StatusCode
+	}
+}
+
+// report logs rt, or writes it to w as a JSON line in -json mode.
+func (rt *requestTiming) report(w io.Writer) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if flagJSON {
+		b, _ := json.Marshal(rt)
+		fmt.Fprintf(w, "%s\n", b)
+		return
+	}
+	d := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+	}
+	outcome := rt.Error
+	if outcome == "" {
+		outcome = fmt.Sprint(rt.Status)
+	}
+	var phases []string
+	if rt.Reused {
+		phases = append(phases, "reused connection")
+	} else {
+		phases = append(phases, "dns "+d(rt.DNS).String(), "connect "+d(rt.Connect).String())
+		if rt.TLS > 0 {
+			phases = append(phases, "tls "+d(rt.TLS).String())
+		}
+	}
+	phases = append(phases, "ttfb "+d(rt.TTFB).String())
+	logf("%s %s: %s in %v (%s)", rt.Method, rt.URL, outcome, d(rt.Total), strings.Join(phases, ", "))
+}
+
+// traceTransport is an http.RoundTripper which reports
+// the requestTiming of every request, as enabled with -trace.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := &requestTiming{Method: req.Method, URL: req.URL.String()}
+	ctx := httptrace.WithClientTrace(req.Context(), rt.clientTrace())
+	start := timeNow()
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	rt.finish(start, res, err)
+	rt.report(os.Stderr)
+	return res, err
+}
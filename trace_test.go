@@ -0,0 +1,71 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceTransport(t *testing.T) {
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	var logs []string
+	logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	hc := &http.Client{Transport: &traceTransport{base: ts.Client().Transport}}
+	for i := 0; i < 2; i++ {
+		res, err := hc.Get(ts.URL + "/new-cert")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if len(logs) != 2 {
+		t.Fatalf("logs = %q; want 2 lines", logs)
+	}
+	prefix := "GET " + ts.URL + "/new-cert: 201 in "
+	if !strings.HasPrefix(logs[0], prefix) || !strings.Contains(logs[0], "tls ") {
+		t.Errorf("first request: %q", logs[0])
+	}
+	if !strings.HasPrefix(logs[1], prefix) || !strings.Contains(logs[1], "reused connection") {
+		t.Errorf("second request: %q", logs[1])
+	}
+}
+
+func TestRequestTimingJSON(t *testing.T) {
+	defer func(v bool) { flagJSON = v }(flagJSON)
+	flagJSON = true
+	rt := &requestTiming{Method: "POST", URL: "https://ca/new-reg"}
+	rt.finish(timeNow(), nil, fmt.Errorf("connection refused"))
+	var buf bytes.Buffer
+	rt.report(&buf)
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("%s: %v", buf.Bytes(), err)
+	}
+	if v["event"] != "request" || v["error"] != "connection refused" || v["reused"] != false {
+		t.Errorf("report = %s", buf.Bytes())
+	}
+	if _, ok := v["status"]; ok {
+		t.Errorf("report of a failed request has status: %s", buf.Bytes())
+	}
+}
@@ -14,16 +14,14 @@ package main
 import (
 	"context"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/acme"
 )
 
 var (
 	cmdUpdate = &command{
 		run:       runUpdate,
-		UsageLine: "update [-c config] [-accept] [contact [contact ...]]",
+		UsageLine: "update [-c config] [-ca name] [-accept] [-trust-ca] [-fallback name,...] [contact [contact ...]]",
 		Short:     "update account data",
 		Long: `
 Update modifies account contact info and accepts the current CA
@@ -32,19 +30,39 @@ service agreement which can be seen using whoami command.
 Use -accept argument to indicate that the account holder agrees with
 the proposed CA's Terms and Conditions (the agreement).
 
+The -trust-ca argument records the current TLS certificate chain
+of the CA directory as the expected one, after the cert command warned
+it changed. Verify the change is legitimate, such as announced
+by the CA, before using it.
+
+The -fallback argument sets a comma separated list of CA account names,
+as used with -ca argument, to request certificates from when this account's CA
+fails to issue one. Use -fallback=none to clear the list.
+
 Default location of the config dir is
 {{.ConfigDir}}.
 		`,
 	}
 
-	updateAccept bool
+	updateAccept   bool
+	updateFallback string
+	updateTrustCA  bool
 )
 
 func init() {
 	cmdUpdate.flag.BoolVar(&updateAccept, "accept", updateAccept, "")
+	cmdUpdate.flag.StringVar(&updateFallback, "fallback", updateFallback, "")
+	cmdUpdate.flag.BoolVar(&updateTrustCA, "trust-ca", updateTrustCA, "")
 }
 
 func runUpdate(args []string) {
+	if updateFallback != "" && updateFallback != "none" {
+		for _, ca := range strings.Split(updateFallback, ",") {
+			if !validDirName(ca) {
+				fatalf("-fallback: invalid CA name %q", ca)
+			}
+		}
+	}
 	uc, err := readConfig()
 	if err != nil {
 		fatalf("read config: %v", err)
@@ -52,15 +70,28 @@ func runUpdate(args []string) {
 	if uc.key == nil {
 		fatalf("no key found for %s", uc.URI)
 	}
+	if uc.CA == "" {
+		// an account which has not recorded its CA uses the default one,
+		// as in the cert command
+		uc.CA = string(defaultDiscoFlag)
+	}
 
-	client := acme.Client{Key: uc.key}
+	client, err := newClient(uc.key, uc.CA)
+	if err != nil {
+		fatalf("%s: %v", uc.CA, err)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
+	if updateTrustCA {
+		if uc.CAFingerprint, err = discoverCA(ctx, client); err != nil {
+			fatalf("%s: %v", uc.CA, err)
+		}
+	}
 	if updateAccept {
 		a, err := client.GetReg(ctx, uc.URI)
 		if err != nil {
-			fatalf(err.Error())
+			fatalf("%v", err)
 		}
 		uc.Account = *a
 		uc.AgreedTerms = a.CurrentTerms
@@ -71,11 +102,19 @@ func runUpdate(args []string) {
 
 	a, err := client.UpdateReg(ctx, &uc.Account)
 	if err != nil {
-		fatalf(err.Error())
+		fatalf("%v", err)
 	}
 	uc.Account = *a
+	switch updateFallback {
+	case "":
+		// keep as is
+	case "none":
+		uc.Fallback = nil
+	default:
+		uc.Fallback = strings.Split(updateFallback, ",")
+	}
 	if err := writeConfig(uc); err != nil {
 		fatalf("write config: %v", err)
 	}
-	printAccount(os.Stdout, &uc.Account, filepath.Join(configDir, accountKey))
+	printAccount(os.Stdout, &uc.Account, accountKeyName(uc))
 }
@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
+	"encoding/asn1"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 	"text/template"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -22,6 +24,43 @@ The commands are:
 {{range .}}{{if .Runnable}}
 	{{.Name | printf "%-11s"}} {{.Short}}{{end}}{{end}}
 
+All commands accept -c flag to override the config dir,
+-ca flag to select one of multiple CA accounts,
+-tenant flag, or ACME_TENANT environment variable, to select
+an isolated tenant configuration,
+-json flag to report errors, including CA problem documents,
+as JSON objects on the standard error, with "retryable": true for transient
+failures, "terminal": true for failures caused by the request or the account,
+which retrying does not fix, and "scope" of account, authorization, challenge
+or certificate,
+and -fips flag to allow only FIPS 140 approved keys and algorithms:
+RSA 2048 or 3072 bit and ECDSA P-256 or P-384 keys with SHA-256 or SHA-384.
+The -fips flag is on by default in binaries built with "fips" build tag,
+which refuse -fips=false.
+The -log-id flag, or ACME_LOG_ID environment variable, specifies
+a correlation ID to prefix log messages with and include in JSON errors.
+Errors reported by a CA include its request ID, if the CA provides one.
+The -qps flag limits the number of requests per second sent to the CA,
+or CAs, by the whole command.
+The -ua flag, or ACME_USER_AGENT environment variable, specifies
+a suffix of the User-Agent header sent to the CA, e.g. to let operators
+of a private CA attribute requests to a team.
+The CA is connected to through the proxy specified with HTTPS_PROXY
+environment variable, unless the host is listed in NO_PROXY. The -proxy flag
+overrides the former with http://[user:password@]host:port URL; the password
+may also be given with ACME_PROXY_PASSWORD environment variable.
+The -header flag, which may be repeated, adds a "Name: value" header field
+to every request sent to the CA directory host, such as one required by
+an API gateway in front of it; requests to other hosts, including redirects
+and issuer or -root URLs, do not get it. Host, Content-Type, Content-Length and User-Agent
+cannot be set. To keep credentials off the command line, store the values
+in the settings file; see acme help account.
+The -trace flag reports how long every request to the CA took,
+broken down into DNS lookup, connect, TLS handshake and time to first
+response byte, or that an idle connection was reused, to tell whether
+the CA or the network is slow. With -json, it is written to the standard
+error as a JSON object with "event": "request" and durations in seconds.
+
 Use "acme help [command]" for more information about a command.
 
 Additional help topics:
@@ -47,6 +86,43 @@ Default location of the account config dir is
 
 Use -c argument with any acme command to override the default location
 of the config dir. Alternatively, set ACME_CONFIG environment variable.
+
+A config dir may hold accounts with multiple CAs. Use -ca argument
+with any acme command to select one by name, consisting of letters,
+digits, dots, dashes and underscores. Such accounts are stored
+in {{.ConfigDir}}/ca/<name>. They may have their own key
+or share the {{.AccountKey}} in the config dir.
+
+The account key can be moved into the OS keyring with acme keyring.
+
+Changes of the account contacts and runs of cert command hooks
+are logged in {{.AuditFile}} file in the account dir.
+
+Default command arguments can be stored in {{.SettingsFile}} file
+in the config dir, or in the account dir of -ca accounts, which takes
+precedence. Arguments specified on the command line override them.
+The file maps command names to argument names and values, for example:
+
+	{
+	  "cert": {
+	    "dns": true,
+	    "renew-before": "720h",
+	    "out": "/etc/ssl/{{"{{.Domain}}"}}.crt"
+	  }
+	}
+
+Repeatable arguments, such as -ext, take an array of values.
+The -c, -ca and -tenant arguments cannot be stored.
+
+A config dir may also hold isolated configurations of multiple tenants,
+such as customers of a hosting provider. Use -tenant argument with any
+acme command, or ACME_TENANT environment variable, to select one by name,
+consisting of letters, digits, dots, dashes and underscores. It is stored
+in {{.ConfigDir}}/tenants/<name>, which is then used as the config dir,
+with its own accounts, keys, certificates, settings and audit log.
+Nothing else in the config dir is used, so rate limits such as -qps
+and hook settings such as -hook-user can be set for each tenant
+in its {{.SettingsFile}}.
 		`,
 	}
 
@@ -61,6 +137,32 @@ A directory alias can also be used. Currently defined aliases are:
 {{range $alias, $url := .DiscoAliases}}
 	{{$alias}}: {{$url}}{{end}}
 
+A private CA, whose root certificate is not trusted by the system,
+can be specified together with the root certificate fingerprint
+in a single argument:
+
+	-d https://ca.internal/directory#<hex SHA-256 of the root certificate>
+
+In this case the CA TLS certificate chain is verified only against the root.
+If the CA does not include the root in the chain, as most do not,
+it is fetched from the /root/<fingerprint> endpoint of the CA host,
+as served by step-ca, and used only if its fingerprint matches.
+The value is stored in the account config and used by subsequent commands.
+
+A fingerprint of the TLS certificate chain the CA directory is served
+with is recorded in the account config too. The cert command warns
+if it changes, as it would if the connection were intercepted,
+or if -d names a different directory than the account's, such as
+a mistyped one. See acme help update for accepting a changed chain.
+
+Only ACME v1 directories are supported. A directory of the RFC 8555
+protocol, also known as ACME v2, is detected and reported as such.
+CAs which implement only RFC 8555, such as step-ca and Pebble,
+cannot be used to register accounts or request certificates,
+but acme conformance can check them.
+The directory must list new-reg, new-authz, new-cert and revoke-cert
+endpoints as absolute URLs with the same scheme and host as the directory.
+
 For more information about the spec see
 https://tools.ietf.org/html/draft-ietf-acme-acme.
 		`,
@@ -140,17 +242,43 @@ func help(args []string) {
 				fmt.Fprintf(os.Stdout, "usage: acme %s\n", cmd.UsageLine)
 			}
 			data := struct {
-				ConfigDir    string
-				AccountFile  string
-				AccountKey   string
-				DefaultDisco string
-				DiscoAliases map[string]string
+				ConfigDir       string
+				AccountFile     string
+				AccountKey      string
+				AuthzFile       string
+				AuditFile       string
+				PendingFile     string
+				DefaultDisco    string
+				DefaultKeyType  string
+				DiscoAliases    map[string]string
+				CertTimeout     time.Duration
+				HookTimeout     time.Duration
+				NoCN            string
+				ExtKeyUsages    map[string]asn1.ObjectIdentifier
+				CertRenewBefore time.Duration
+				GCAge           time.Duration
+				SnippetServers  map[string]string
+				SettingsFile    string
+				SchemaVersion   int
 			}{
-				ConfigDir:    configDir,
-				AccountFile:  accountFile,
-				AccountKey:   accountKey,
-				DefaultDisco: defaultDisco,
-				DiscoAliases: discoAliases,
+				ConfigDir:       configDir,
+				AccountFile:     accountFile,
+				AccountKey:      accountKey,
+				AuthzFile:       authzFile,
+				AuditFile:       auditFile,
+				PendingFile:     pendingFile,
+				DefaultDisco:    defaultDisco,
+				DefaultKeyType:  defaultKeyType,
+				DiscoAliases:    discoAliases,
+				CertTimeout:     certTimeout,
+				HookTimeout:     hookTimeout,
+				NoCN:            noCN,
+				ExtKeyUsages:    extKeyUsages,
+				CertRenewBefore: certRenew,
+				GCAge:           gcAge,
+				SnippetServers:  snippetServers,
+				SettingsFile:    settingsFile,
+				SchemaVersion:   configSchemaVersion,
 			}
 			tmpl(os.Stdout, cmd.Long, data)
 			return
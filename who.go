@@ -13,17 +13,22 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"text/tabwriter"
 	"time"
-
-	"golang.org/x/crypto/acme"
 )
 
 var (
 	cmdWho = &command{
 		run:       runWhoami,
-		UsageLine: "whoami [-c config]",
+		UsageLine: "whoami [-c config] [-ca name] [-json]",
 		Short:     "display info about the key holder",
 		Long: `
 Whoami makes a request to the ACME server signed with a private key
@@ -31,11 +36,45 @@ found in the config file and displays the formatted results.
 
 It is a simple way to verify the validity of an account key.
 
+The output includes authorizations of the account, with their status
+and expiry, and certificates issued to it, with their serial number
+and expiry, if the CA lists them.
+With -json flag, the account is written to the standard output
+as a JSON object.
+
 Default location of the config dir is {{.ConfigDir}}.
 		`,
 	}
 )
 
+// accountInfo is the whoami output in -json mode.
+type accountInfo struct {
+	URI            string         `json:"uri"`
+	Key            string         `json:"key"`
+	Contact        []string       `json:"contact"`
+	CurrentTerms   string         `json:"currentTerms,omitempty"`
+	AgreedTerms    string         `json:"agreedTerms,omitempty"`
+	Authorizations []accountAuthz `json:"authorizations"`
+	Certificates   []accountCert  `json:"certificates"`
+}
+
+// accountAuthz is an authorization listed in the account
+// authorizations collection.
+type accountAuthz struct {
+	URI     string     `json:"uri"`
+	Domain  string     `json:"domain"`
+	Status  string     `json:"status"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// accountCert is a certificate listed in the account
+// certificates collection.
+type accountCert struct {
+	URI      string    `json:"uri"`
+	Serial   string    `json:"serial"` // hex
+	NotAfter time.Time `json:"notAfter"`
+}
+
 func runWhoami([]string) {
 	uc, err := readConfig()
 	if err != nil {
@@ -48,10 +87,173 @@ func runWhoami([]string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client := acme.Client{Key: uc.key}
+	client, err := newClient(uc.key, uc.CA)
+	if err != nil {
+		fatalf("%s: %v", uc.CA, err)
+	}
 	a, err := client.GetReg(ctx, uc.URI)
 	if err != nil {
-		fatalf(err.Error())
+		fatalf("%v", err)
+	}
+	info := accountInfo{
+		URI:          a.URI,
+		Key:          accountKeyName(uc),
+		Contact:      a.Contact,
+		CurrentTerms: a.CurrentTerms,
+		AgreedTerms:  a.AgreedTerms,
+	}
+	if a.Authorizations != "" {
+		info.Authorizations, err = listAuthz(ctx, client.HTTPClient, a.Authorizations)
+		if err != nil {
+			errorf("authorizations: %v", err)
+		}
+	}
+	if a.Certificates != "" {
+		info.Certificates, err = listCerts(ctx, client.HTTPClient, a.Certificates)
+		if err != nil {
+			errorf("certificates: %v", err)
+		}
+	}
+	if flagJSON {
+		b, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Printf("%s\n", b)
+		return
+	}
+	printAccount(os.Stdout, a, info.Key)
+	printAccountItems(os.Stdout, info.Authorizations, info.Certificates)
+}
+
+// printAccountItems outputs account authorizations and certificates
+// into w using tabwriter.
+func printAccountItems(w io.Writer, authz []accountAuthz, certs []accountCert) {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, '\t', 0)
+	fmt.Fprintf(tw, "\nAuthorizations:\t%d\n", len(authz))
+	for _, z := range authz {
+		exp := "-"
+		if z.Expires != nil {
+			exp = z.Expires.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", z.Domain, z.Status, exp)
+	}
+	fmt.Fprintf(tw, "\nCertificates:\t%d\n", len(certs))
+	for _, c := range certs {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Serial, c.NotAfter.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
+
+// listAuthz fetches the authorizations collection at uri
+// and each authorization in it.
+func listAuthz(ctx context.Context, hc *http.Client, uri string) ([]accountAuthz, error) {
+	uris, err := listCollection(ctx, hc, uri, "authorizations")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]accountAuthz, 0, len(uris))
+	for _, u := range uris {
+		var z struct {
+			Identifier struct{ Value string }
+			Status     string
+			Expires    *time.Time
+		}
+		if err := getJSON(ctx, hc, u, &z); err != nil {
+			return res, err
+		}
+		res = append(res, accountAuthz{
+			URI:     u,
+			Domain:  z.Identifier.Value,
+			Status:  z.Status,
+			Expires: z.Expires,
+		})
+	}
+	return res, nil
+}
+
+// listCerts fetches the certificates collection at uri
+// and each certificate in it.
+func listCerts(ctx context.Context, hc *http.Client, uri string) ([]accountCert, error) {
+	uris, err := listCollection(ctx, hc, uri, "certificates")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]accountCert, 0, len(uris))
+	for _, u := range uris {
+		b, err := fetchURL(ctx, hc, u)
+		if err != nil {
+			return res, err
+		}
+		if p, _ := pem.Decode(b); p != nil {
+			b = p.Bytes
+		}
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return res, fmt.Errorf("%s: %v", u, err)
+		}
+		res = append(res, accountCert{
+			URI:      u,
+			Serial:   fmt.Sprintf("%x", c.SerialNumber),
+			NotAfter: c.NotAfter,
+		})
+	}
+	return res, nil
+}
+
+// maxCollectionPages limits the number of pages of a collection,
+// in case a CA links them in a loop.
+const maxCollectionPages = 1000
+
+// listCollection fetches the collection at uri and returns the URIs
+// listed in its field. A collection may be split into pages, each linking
+// to the next one with Link rel="next" header, which are all fetched.
+func listCollection(ctx context.Context, hc *http.Client, uri, field string) ([]string, error) {
+	var all []string
+	seen := make(map[string]bool)
+	for page := uri; page != ""; {
+		if seen[page] || len(seen) == maxCollectionPages {
+			return all, fmt.Errorf("%s: too many pages, or a loop at %s", uri, page)
+		}
+		seen[page] = true
+		b, h, err := fetchURLHeader(ctx, hc, page)
+		if err != nil {
+			return all, err
+		}
+		var v map[string]json.RawMessage
+		var uris []string
+		if err := json.Unmarshal(b, &v); err != nil {
+			return all, fmt.Errorf("%s: %v", page, err)
+		}
+		if err := json.Unmarshal(v[field], &uris); v[field] != nil && err != nil {
+			return all, fmt.Errorf("%s: %s: %v", page, field, err)
+		}
+		all = append(all, uris...)
+		next := linkHeader(h, "next")
+		if len(next) == 0 {
+			break
+		}
+		u, err := url.Parse(page)
+		if err != nil {
+			return all, err
+		}
+		ref, err := u.Parse(next[0])
+		if err != nil {
+			return all, fmt.Errorf("%s: next page: %v", page, err)
+		}
+		page = ref.String()
+	}
+	return all, nil
+}
+
+// getJSON fetches uri and decodes the JSON response into v.
+func getJSON(ctx context.Context, hc *http.Client, uri string, v interface{}) error {
+	b, err := fetchURL(ctx, hc, uri)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", uri, err)
 	}
-	printAccount(os.Stdout, a, filepath.Join(configDir, accountKey))
+	return nil
 }
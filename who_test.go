@@ -0,0 +1,103 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListAccountItems(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabc),
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := "http://" + r.Host
+		switch r.URL.Path {
+		case "/authz":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprintf(w, `{"authorizations":["%s/authz/2"]}`, base)
+				return
+			}
+			w.Header().Add("Link", `<https://ca/terms>;rel="terms-of-service"`)
+			w.Header().Add("Link", `</authz?page=2>; rel="next"`)
+			fmt.Fprintf(w, `{"authorizations":["%s/authz/1"]}`, base)
+		case "/loop":
+			w.Header().Set("Link", `</loop>;rel="next"`)
+			fmt.Fprint(w, `{"certificates":[]}`)
+		case "/authz/1":
+			fmt.Fprint(w, `{"identifier":{"type":"dns","value":"example.com"},"status":"valid","expires":"2016-02-01T00:00:00Z"}`)
+		case "/authz/2":
+			fmt.Fprint(w, `{"identifier":{"type":"dns","value":"example.org"},"status":"pending"}`)
+		case "/certs":
+			fmt.Fprintf(w, `{"certificates":["%s/cert/1"]}`, base)
+		case "/cert/1":
+			w.Write(der)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	authz, err := listAuthz(ctx, nil, ts.URL+"/authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authz) != 2 || authz[0].Domain != "example.com" || authz[0].Status != "valid" ||
+		authz[0].Expires == nil || authz[1].Status != "pending" || authz[1].Expires != nil {
+		t.Errorf("listAuthz = %+v", authz)
+	}
+	certs, err := listCerts(ctx, nil, ts.URL+"/certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Serial != "abc" || !certs[0].NotAfter.Equal(notAfter) {
+		t.Errorf("listCerts = %+v", certs)
+	}
+	if _, err := listCerts(ctx, nil, ts.URL+"/missing"); err == nil {
+		t.Error("listCerts of missing collection: no error")
+	}
+	if _, err := listCerts(ctx, nil, ts.URL+"/loop"); err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("listCerts of looping pages: %v", err)
+	}
+
+	var buf bytes.Buffer
+	printAccountItems(&buf, authz, certs)
+	for _, s := range []string{"example.com", "2016-02-01T00:00:00Z", "pending", "abc", "2017-01-01T00:00:00Z"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("printAccountItems output does not contain %q:\n%s", s, buf.String())
+		}
+	}
+}